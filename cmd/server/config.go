@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"go.yaml.in/yaml/v3"
+)
+
+const configUsage = `usage: server [flags] config <command>
+
+commands:
+  print [--redacted]  print the configuration as YAML, which can be used as --config file`
+
+// runConfig handles the "server config ..." subcommands. The configuration is
+// printed even when it is invalid, followed by its problems.
+func runConfig(cfg config.Config, loadErr error, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redact := fs.Bool("redacted", false, "hide the passwords, secrets and tokens")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *redact {
+		cfg = cfg.Redacted()
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	if loadErr != nil {
+		return fmt.Errorf("invalid configuration:\n%w", loadErr)
+	}
+	return nil
+}
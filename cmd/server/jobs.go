@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/digest"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/scheduler"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+)
+
+// addJobs adds the maintenance jobs of the server to s.
+func addJobs(s *scheduler.Scheduler, cfg config.Config, tokens auth.Service, users user.Service, tils til.Service, digests digest.Service, jobs *queue.DBQueue) error {
+	return errors.Join(
+		s.Add("token_cleanup", cfg.Scheduler.TokenCleanup, func(ctx context.Context) error {
+			return cleanupRefreshTokens(ctx, tokens, cfg.Account.RefreshTokenGracePeriod)
+		}),
+		s.Add("trash_purge", cfg.Scheduler.TrashPurge, func(ctx context.Context) error {
+			return purgeTrash(ctx, tils, users, cfg.Scheduler.TrashRetention)
+		}),
+		s.Add("weekly_digest", cfg.Scheduler.WeeklyDigest, func(ctx context.Context) error {
+			n, err := digests.EnqueueWeekly(ctx, time.Now())
+			slog.InfoContext(ctx, "Queued the weekly digests", "count", n)
+			return err
+		}),
+		s.Add("queue_cleanup", cfg.Scheduler.QueueCleanup, func(ctx context.Context) error {
+			n, err := jobs.PurgeDone(ctx, time.Now().Add(-cfg.Queue.Retention))
+			if err != nil {
+				return fmt.Errorf("could not purge finished queued jobs: %w", err)
+			}
+			slog.InfoContext(ctx, "Purged finished queued jobs", "count", n)
+			return nil
+		}),
+	)
+}
+
+// cleanupRefreshTokens hard-deletes the refresh tokens that expired, and the
+// revoked ones once they are older than the grace period.
+func cleanupRefreshTokens(ctx context.Context, svc auth.Service, gracePeriod time.Duration) error {
+	now := time.Now()
+	expired, err := svc.PurgeExpiredRefreshTokens(ctx, now)
+	if err != nil {
+		return fmt.Errorf("could not purge expired refresh tokens: %w", err)
+	}
+	revoked, err := svc.PurgeDeletedRefreshTokens(ctx, now.Add(-gracePeriod))
+	if err != nil {
+		return fmt.Errorf("could not purge revoked refresh tokens: %w", err)
+	}
+	slog.InfoContext(ctx, "Purged refresh tokens", "expired", expired, "revoked", revoked)
+	return nil
+}
+
+// purgeTrash hard-deletes the TILs and then the accounts that were deleted
+// longer than retention ago. Accounts that still own TILs are kept.
+func purgeTrash(ctx context.Context, tils til.Service, users user.Service, retention time.Duration) error {
+	before := time.Now().Add(-retention)
+	purgedTils, err := tils.PurgeDeleted(ctx, before)
+	if err != nil {
+		return fmt.Errorf("could not purge deleted TILs: %w", err)
+	}
+	purgedUsers, err := users.PurgeDeleted(ctx, before)
+	if err != nil {
+		return fmt.Errorf("could not purge deleted users: %w", err)
+	}
+	slog.InfoContext(ctx, "Purged the trash", "tils", purgedTils, "users", purgedUsers)
+	return nil
+}
@@ -1,23 +1,48 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/amavis442/til-backend/internal/account"
 	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/buildinfo"
 	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/dbmigrate"
+	"github.com/amavis442/til-backend/internal/digest"
 	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/health"
+	"github.com/amavis442/til-backend/internal/logging"
+	"github.com/amavis442/til-backend/internal/mail"
+	"github.com/amavis442/til-backend/internal/metrics"
 	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/oidc"
+	"github.com/amavis442/til-backend/internal/openapi"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/amavis442/til-backend/internal/scheduler"
 	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/tracing"
 	"github.com/amavis442/til-backend/internal/user"
+	"github.com/amavis442/til-backend/internal/worker"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
-	"gorm.io/driver/postgres"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"gorm.io/gorm"
+
+	_ "time/tzdata" // Profile time zones must be valid without system tzdata
 )
 
 func waitForDB(dsn string, maxRetries int, delay time.Duration) *gorm.DB {
@@ -25,16 +50,16 @@ func waitForDB(dsn string, maxRetries int, delay time.Duration) *gorm.DB {
 	var err error
 
 	for i := 0; i < maxRetries; i++ {
-		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		db, err = database.Open(dsn, &gorm.Config{Logger: database.Logger(slog.Default())})
 		if err == nil {
 			sqlDB, _ := db.DB()
 			if pingErr := sqlDB.Ping(); pingErr == nil {
-				slog.Info("Connected to the database.")
+				slog.Info("Connected to the database")
 				return db
 			}
 		}
 
-		slog.Info(fmt.Sprintf("Waiting for database... (%d/%d)", i+1, maxRetries))
+		slog.Info("Waiting for database", "attempt", i+1, "max_attempts", maxRetries)
 		time.Sleep(delay)
 	}
 
@@ -42,59 +67,284 @@ func waitForDB(dsn string, maxRetries int, delay time.Duration) *gorm.DB {
 	return nil
 }
 
+// purgeRateLimits forgets the rate limit windows that have ended, until ctx is
+// done.
+func purgeRateLimits(ctx context.Context, store ratelimit.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := store.Purge(ctx, time.Now()); err != nil {
+			slog.Error("Could not purge the ended rate limit windows", "error", err)
+		}
+	}
+}
+
 func main() {
-	cfg := config.Load()
-	if err := auth.InitJWTKeys(""); err != nil {
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(cfg, err, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	slogger := logging.New(os.Stdout, cfg.LogLevel)
+	slog.SetDefault(slogger)
+
+	dsn := cfg.Database.DSN
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(dsn, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	tokens, err := auth.NewTokens(cfg.JWT)
+	if err != nil {
 		log.Fatalf("failed to initialize JWT keys: %v", err)
 	}
 
-	dsn := cfg.DB_DSN
-	port := cfg.PORT
-	corsAllowedOrigin := cfg.CORSAllowedOrigin
+	slog.Info("Loaded configuration", "env", cfg.Env)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
 
 	db := waitForDB(dsn, 10, 2*time.Second)
-	slogger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Fatalf("failed to trace the database queries: %v", err)
+	}
+	if cfg.Database.MigrateOnStart {
+		if err := migrateOnStart(dsn); err != nil {
+			log.Fatalf("failed to migrate the database: %v", err)
+		}
+	}
+
+	// Side effects like email are queued in the transaction of their change
+	jobQueue := queue.NewDBQueue(db, cfg.Queue)
+	mailer := mail.New(cfg.Mail, slogger)
 
 	// User and Auth
 	userRepo := user.NewRepository(db)
-	userService := user.NewService(userRepo)
+	userService := user.NewService(userRepo, jobQueue)
 	refreshTokenRepo := auth.NewRepository(db)
 	refreshTokenService := auth.NewService(refreshTokenRepo)
-	authHandler := handler.NewAuthHandler(userService, refreshTokenService, slogger)
+	cookies := handler.NewCookieBuilder(cfg.Cookie)
+	authHandler := handler.NewAuthHandler(userService, refreshTokenService, tokens, cookies, slogger)
+
+	// Preferences
+	preferencesRepo := preferences.NewRepository(db)
+	preferencesService := preferences.NewService(preferencesRepo)
+	preferencesHandler := handler.NewPreferencesHandler(preferencesService, slogger)
 
 	// Today I Learned (TIL)
 	tilRepo := til.NewRepository(db)
-	tilService := til.NewService(tilRepo)
-	tilHandler := handler.NewTilHandler(tilService, userService)
+	tilService := til.NewService(tilRepo, jobQueue)
+	tilHandler := handler.NewTilHandler(tilService, userService, preferencesService)
+
+	// Profile
+	profileHandler := handler.NewProfileHandler(userService, slogger)
+
+	// Account export and deletion
+	accountService := account.NewService(db, userService, tilService, refreshTokenService, preferencesService, cfg.Account)
+	accountHandler := handler.NewAccountHandler(accountService, cookies, slogger)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("failed to get the database connection pool: %v", err)
+	}
+	if err := metrics.RegisterDB(sqlDB, "til"); err != nil {
+		log.Fatalf("failed to register the database metrics: %v", err)
+	}
 
-	app := fiber.New()
+	// Rate limits are counted in the database when replicas must share them
+	var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == config.RateLimitStoreDatabase {
+		rateLimits = ratelimit.NewDBStore(db)
+	}
+
+	// Background workers run until the server has shut down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Go(func() {
+		purgeRateLimits(workerCtx, rateLimits, 10*time.Minute)
+	})
+
+	// Maintenance jobs run on one replica at a time; on Postgres the replicas
+	// elect it with an advisory lock
+	var locker scheduler.Locker = scheduler.NewMemoryLocker()
+	if database.Dialect(dsn) == database.Postgres {
+		locker = scheduler.NewPostgresLocker(sqlDB)
+	}
+	jobs := scheduler.New(scheduler.NewRepository(db), locker, cfg.Scheduler, slogger)
+	digests := digest.NewService(userService, preferencesService, tilService, jobQueue)
+	if err := addJobs(jobs, cfg, refreshTokenService, userService, tilService, digests, jobQueue); err != nil {
+		log.Fatalf("failed to add the jobs: %v", err)
+	}
+	if cfg.Scheduler.Enabled {
+		workers.Go(func() { jobs.Run(workerCtx) })
+	}
+
+	// The workers run the queued jobs on every replica
+	jobWorker := worker.New(jobQueue, cfg.Queue, slogger)
+	jobWorker.Handle(mail.SendJob, mail.SendHandler(mailer))
+	jobWorker.Handle(user.VerifyEmailJob, user.VerificationMailHandler(userService, mailer, cfg.Mail.VerifyURL))
+	jobWorker.Handle(user.DeletionCodeJob, user.DeletionCodeMailHandler(userService, mailer))
+	jobWorker.Handle(til.RenderJob, til.RenderHandler(tilService))
+	workers.Go(func() { jobWorker.Run(workerCtx) })
+	jobHandler := handler.NewJobHandler(jobs)
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: handler.ErrorHandler(slogger),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		BodyLimit:    cfg.Server.BodyLimit,
+		Concurrency:  cfg.Server.Concurrency,
+	})
+	// Probes come before the access log, which they would flood
+	healthHandler := handler.NewHealthHandler(health.NewChecker(cfg.ReadyTimeout,
+		health.Check{Name: "database", Run: sqlDB.PingContext},
+		health.Check{Name: "migrations", Run: func(ctx context.Context) error {
+			return dbmigrate.CheckVersion(ctx, sqlDB, database.Dialect(dsn))
+		}},
+		health.Check{Name: "jwt_keys", Run: func(ctx context.Context) error {
+			if !tokens.KeysLoaded() {
+				return errors.New("JWT keys are not loaded")
+			}
+			return nil
+		}},
+	), slogger)
+	app.Get("/healthz", healthHandler.Live)
+	app.Get("/readyz", healthHandler.Ready)
+	app.Get("/version", healthHandler.Version)
+
+	// The metrics get their own listener when METRICS_ADDR is set, so they
+	// need not be reachable from where the API is
+	metricsHandlers := []fiber.Handler{metrics.Handler()}
+	if cfg.Metrics.Token != "" {
+		metricsHandlers = append([]fiber.Handler{middleware.BearerToken(cfg.Metrics.Token)}, metricsHandlers...)
+	}
+	var metricsApp *fiber.App
+	if cfg.Metrics.Addr != "" {
+		metricsApp = fiber.New(fiber.Config{DisableStartupMessage: true})
+		metricsApp.Get("/metrics", metricsHandlers...)
+	} else {
+		app.Get("/metrics", metricsHandlers...)
+	}
+
+	// The header of a proxy counts only on connections from TrustedProxies,
+	// anyone else could pick the address the rate limits count
+	app.Use(middleware.ClientIP(cfg.Server.TrustedProxies, cfg.Server.ClientIPHeader()))
+	app.Use(requestid.New())
+	app.Use(middleware.AccessLog(slogger))
+	app.Use(middleware.Trace())
+	app.Use(metrics.Middleware())
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     corsAllowedOrigin,
-		AllowHeaders:     "Origin, Content-Type, Accept",
-		AllowMethods:     "GET,POST,OPTIONS,PUT",
+		AllowOrigins:     strings.Join(cfg.CORSAllowedOrigins, ","),
+		AllowHeaders:     "Origin, Content-Type, Accept, If-Match, If-None-Match, X-Request-ID",
+		ExposeHeaders:    "ETag, X-Request-ID, Retry-After, RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset",
+		AllowMethods:     "GET,POST,OPTIONS,PUT,PATCH,DELETE",
 		AllowCredentials: true,
 	}))
 
-	app.Use(logger.New(logger.Config{
-		Format:     "${pid} ${status} - ${method} ${path}\n",
-		TimeFormat: "02-Jan-2006",
-		TimeZone:   "Europe/Amsterdam",
-	}))
+	app.Use(middleware.QueryDeadline(cfg.Database.QueryTimeout))
 
-	authGroup := app.Group("/auth")
+	app.Get("/openapi.json", openapi.Spec)
+	app.Get("/docs", openapi.Docs)
+	app.Get("/docs/:file", openapi.Asset)
+
+	authGroup := app.Group("/auth", middleware.RateLimit(rateLimits, "auth", cfg.RateLimit.Auth, middleware.ByIP))
 	authGroup.Post("/register", authHandler.Register)
 	authGroup.Post("/login", authHandler.Login)
 	authGroup.Post("/refresh-token", authHandler.RefreshToken)
+	authGroup.Post("/verify-email", profileHandler.VerifyEmail)
 
-	verifier := &auth.JWTVerifier{}
+	if cfg.OIDC.Enabled() {
+		provider, err := oidc.NewProvider(context.Background(), cfg.OIDC)
+		if err != nil {
+			log.Fatalf("failed to initialize OIDC provider: %v", err)
+		}
+		oidcHandler := handler.NewOIDCHandler(provider, authHandler)
+		authGroup.Get("/oidc/login", oidcHandler.Login)
+		authGroup.Get("/oidc/callback", oidcHandler.Callback)
+	}
 
-	apiGroup := app.Group("/api", middleware.AuthMiddleware(verifier))
+	apiGroup := app.Group("/api",
+		middleware.AuthMiddleware(tokens, cfg.Cookie.FullName()),
+		middleware.RateLimit(rateLimits, "api", cfg.RateLimit.API, middleware.ByUser),
+	)
 	apiGroup.Get("/tils", tilHandler.List)
-	apiGroup.Post("/tils/search", tilHandler.Search)
+	apiGroup.Post("/tils/search", middleware.RateLimit(rateLimits, "search", cfg.RateLimit.Search, middleware.ByUser), tilHandler.Search)
 	apiGroup.Get("/tils/:id", tilHandler.GetByID)
 	apiGroup.Post("/tils", tilHandler.Create)
 	apiGroup.Put("/tils/:id", tilHandler.Update)
+	apiGroup.Patch("/tils/:id", tilHandler.Patch)
 	apiGroup.Post("/change-password", authHandler.UpdatePassword)
+	apiGroup.Get("/me", profileHandler.Get)
+	apiGroup.Patch("/me", profileHandler.Update)
+	apiGroup.Get("/me/preferences", preferencesHandler.Get)
+	apiGroup.Put("/me/preferences", preferencesHandler.Put)
+	apiGroup.Get("/me/export", middleware.RateLimit(rateLimits, "export", cfg.RateLimit.Export, middleware.ByUser), accountHandler.Export)
+	apiGroup.Post("/me/deletion-code", accountHandler.RequestDeletionCode)
+	apiGroup.Delete("/me", accountHandler.Delete)
+
+	// Administration
+	adminGroup := apiGroup.Group("/admin", middleware.RequireRole(userService, user.RoleAdmin))
+	adminGroup.Get("/jobs", jobHandler.List)
+	adminGroup.Get("/jobs/:name/runs", jobHandler.Runs)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	build := buildinfo.Get()
+	slog.Info("Starting server", "version", build.Version, "commit", build.Commit, "port", cfg.Port)
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- app.Listen(":" + strconv.Itoa(cfg.Port)) }()
+	if metricsApp != nil {
+		slog.Info("Serving metrics", "addr", cfg.Metrics.Addr)
+		go func() { listenErr <- metricsApp.Listen(cfg.Metrics.Addr) }()
+	}
 
-	log.Fatal(app.Listen(":" + port))
+	select {
+	case err := <-listenErr:
+		log.Fatalf("server stopped: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal stops the server right away
+
+	slog.Info("Shutting down, waiting for running requests", "timeout", cfg.Server.ShutdownTimeout.String())
+	if err := app.ShutdownWithTimeout(cfg.Server.ShutdownTimeout); err != nil {
+		slog.Error("Could not finish all running requests", "error", err)
+	}
+	if metricsApp != nil {
+		if err := metricsApp.Shutdown(); err != nil {
+			slog.Error("Could not stop the metrics server", "error", err)
+		}
+	}
+	stopWorkers()
+	workers.Wait()
+	tracingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("Could not export the last spans", "error", err)
+	}
+	cancel()
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("Could not close the database connection pool", "error", err)
+	}
+	slog.Info("Server stopped")
 }
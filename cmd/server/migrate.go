@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/dbmigrate"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const migrateUsage = `usage: server migrate <command>
+
+commands:
+  up        apply all pending migrations
+  down [N]  revert the last N migrations (default 1)
+  status    show the applied version and the pending migrations
+  goto V    migrate up or down to version V
+  force V   record version V as applied after a failed migration was fixed by hand
+  drift     compare the schema with the GORM models`
+
+// runMigrate handles the "server migrate ..." subcommands.
+func runMigrate(dsn string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if args[0] == "drift" {
+		return runDrift(dsn)
+	}
+
+	m, err := dbmigrate.Open(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		if err := m.Up(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("invalid number of steps %q\n\n%s", args[1], migrateUsage)
+			}
+		}
+		if err := m.Down(steps); err != nil {
+			return err
+		}
+	case "goto", "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q\n\n%s", args[1], migrateUsage)
+		}
+		move := m.Goto
+		if args[0] == "force" {
+			move = m.Force
+		}
+		if err := move(uint(version)); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], migrateUsage)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	printStatus(status)
+	return nil
+}
+
+// runDrift prints the differences between the migrated schema and the GORM
+// models, and fails when there are any so it can be used in CI.
+func runDrift(dsn string) error {
+	db, err := database.Open(dsn, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return err
+	}
+
+	drifts, err := dbmigrate.CheckDrift(db, dbmigrate.Models()...)
+	if err != nil {
+		return err
+	}
+	if len(drifts) == 0 {
+		fmt.Println("no drift: the schema matches the models")
+		return nil
+	}
+	for _, d := range drifts {
+		fmt.Println(d)
+	}
+	return fmt.Errorf("schema drift detected in %d places", len(drifts))
+}
+
+func printStatus(s dbmigrate.Status) {
+	fmt.Printf("version: %d (latest %d)\n", s.Version, s.Latest)
+	if s.Dirty {
+		fmt.Println("dirty:   yes, the last migration failed; undo or finish it by hand and use 'force V' with the version the schema is at")
+	}
+	if len(s.Pending) == 0 {
+		fmt.Println("pending: none")
+		return
+	}
+	pending := make([]string, len(s.Pending))
+	for i, v := range s.Pending {
+		pending[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	fmt.Printf("pending: %s\n", strings.Join(pending, ", "))
+}
+
+// migrateOnStart applies pending migrations before the server starts. The
+// migrations run under an advisory lock, so replicas do not race each other.
+func migrateOnStart(dsn string) error {
+	m, err := dbmigrate.Open(dsn)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.UpWithLock(context.Background()); err != nil {
+		return err
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	slog.Info("Database migrated", "version", status.Version)
+	return nil
+}
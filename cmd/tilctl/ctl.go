@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/amavis442/til-backend/internal/validate"
+	"gorm.io/gorm"
+)
+
+const usage = `usage: tilctl [flags] <command>
+
+commands:
+  user create [--admin] <username> <email>  create a user, the password is read from stdin
+  user reset-password <username>            set a new password and revoke all refresh tokens
+  user promote <username>                   give the user ROLE_ADMIN
+  user demote <username>                    take ROLE_ADMIN away again
+  user disable <username>                   stop the user from logging in and revoke all refresh tokens
+  user enable <username>                    let a disabled user log in again
+  user revoke-tokens <username>             revoke all refresh tokens, so the user has to log in again
+  tokens purge-expired                      delete the expired refresh tokens
+  til render [--missing]                    render the Markdown of every TIL, or only of those without HTML
+  queue dead [--limit n]                    list the jobs that failed their last attempt, the last first
+  queue retry <id>                          put a dead job back in the queue with all its attempts
+
+The flags are those of the server, like --config or --db-dsn.`
+
+// ctl runs the tilctl commands against the services.
+type ctl struct {
+	users        user.Service
+	tokens       auth.Service
+	tils         til.Service
+	jobs         *queue.DBQueue
+	out          io.Writer
+	readPassword func() (string, error)
+}
+
+func (c *ctl) run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "user create":
+		return c.createUser(ctx, args[2:])
+	case "user reset-password":
+		return c.withUser(ctx, args[2:], c.resetPassword)
+	case "user promote":
+		return c.withUser(ctx, args[2:], func(ctx context.Context, u *user.User) error {
+			return c.setRole(ctx, u, user.RoleAdmin)
+		})
+	case "user demote":
+		return c.withUser(ctx, args[2:], func(ctx context.Context, u *user.User) error {
+			return c.setRole(ctx, u, user.RoleUser)
+		})
+	case "user disable":
+		return c.withUser(ctx, args[2:], c.disable)
+	case "user enable":
+		return c.withUser(ctx, args[2:], c.enable)
+	case "user revoke-tokens":
+		return c.withUser(ctx, args[2:], c.revokeTokens)
+	case "tokens purge-expired":
+		return c.purgeExpiredTokens(ctx)
+	case "til render":
+		return c.render(ctx, args[2:])
+	case "queue dead":
+		return c.deadJobs(ctx, args[2:])
+	case "queue retry":
+		return c.retryJob(ctx, args[2:])
+	}
+	return fmt.Errorf("unknown command %q\n\n%s", strings.Join(args[:2], " "), usage)
+}
+
+// withUser looks up the user named by the only argument and calls fn with it.
+func (c *ctl) withUser(ctx context.Context, args []string, fn func(context.Context, *user.User) error) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	u, err := c.users.GetByUsername(ctx, args[0])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("user %s not found", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return fn(ctx, u)
+}
+
+// password reads a password and checks it against the rules of the API.
+func (c *ctl) password() (string, error) {
+	password, err := c.readPassword()
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(password) < 8 || len(password) > 72 { // bcrypt refuses passwords of more than 72 bytes
+		return "", errors.New("the password must be at least 8 characters and at most 72 bytes")
+	}
+	return password, nil
+}
+
+func (c *ctl) createUser(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	admin := fs.Bool("admin", false, "give the user ROLE_ADMIN")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+
+	req := struct {
+		Username string `json:"username" validate:"required,username"`
+		Email    string `json:"email" validate:"required,email,max=255"`
+	}{fs.Arg(0), fs.Arg(1)}
+	if fields := validate.Struct(req); fields != nil {
+		return fmt.Errorf("%s %s", fields[0].Field, fields[0].Message)
+	}
+
+	password, err := c.password()
+	if err != nil {
+		return err
+	}
+	if err := c.users.Register(ctx, req.Username, req.Email, password); err != nil {
+		return err
+	}
+	u, err := c.users.GetByUsername(ctx, req.Username)
+	if err != nil {
+		return err
+	}
+	if *admin {
+		if err := c.users.SetRole(ctx, u.ID, user.RoleAdmin); err != nil {
+			return err
+		}
+		u.Role = user.RoleAdmin
+	}
+
+	fmt.Fprintf(c.out, "Created user %s with id %d and role %s\n", u.Username, u.ID, u.Role)
+	return nil
+}
+
+func (c *ctl) resetPassword(ctx context.Context, u *user.User) error {
+	password, err := c.password()
+	if err != nil {
+		return err
+	}
+	if err := c.users.UpdatePassword(ctx, u.ID, password); err != nil {
+		return err
+	}
+	if err := c.tokens.DeleteRefreshTokenByUserID(ctx, u.ID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Changed the password of %s and revoked the refresh tokens\n", u.Username)
+	return nil
+}
+
+func (c *ctl) setRole(ctx context.Context, u *user.User, role string) error {
+	if err := c.users.SetRole(ctx, u.ID, role); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "%s now has role %s\n", u.Username, role)
+	return nil
+}
+
+func (c *ctl) disable(ctx context.Context, u *user.User) error {
+	if err := c.users.SetDisabled(ctx, u.ID, true); err != nil {
+		return err
+	}
+	if err := c.tokens.DeleteRefreshTokenByUserID(ctx, u.ID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Disabled %s and revoked the refresh tokens\n", u.Username)
+	return nil
+}
+
+func (c *ctl) enable(ctx context.Context, u *user.User) error {
+	if err := c.users.SetDisabled(ctx, u.ID, false); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Enabled %s\n", u.Username)
+	return nil
+}
+
+func (c *ctl) revokeTokens(ctx context.Context, u *user.User) error {
+	if err := c.tokens.DeleteRefreshTokenByUserID(ctx, u.ID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Revoked the refresh tokens of %s\n", u.Username)
+	return nil
+}
+
+func (c *ctl) purgeExpiredTokens(ctx context.Context) error {
+	n, err := c.tokens.PurgeExpiredRefreshTokens(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Purged %d expired refresh tokens\n", n)
+	return nil
+}
+
+func (c *ctl) render(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("til render", flag.ContinueOnError)
+	missing := fs.Bool("missing", false, "only render the TILs without HTML")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	n, err := c.tils.RenderAll(ctx, *missing)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "Rendered %d TILs\n", n)
+	return nil
+}
+
+func (c *ctl) deadJobs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("queue dead", flag.ContinueOnError)
+	limit := fs.Int("limit", 20, "how many jobs to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	jobs, err := c.jobs.List(ctx, queue.StatusDead, *limit)
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		lastError, _, _ := strings.Cut(j.LastError, "\n")
+		fmt.Fprintf(c.out, "%d\t%s\t%d attempts\t%s\n", j.ID, j.Kind, j.Attempts, lastError)
+	}
+	fmt.Fprintf(c.out, "%d dead jobs\n", len(jobs))
+	return nil
+}
+
+func (c *ctl) retryJob(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil {
+		return fmt.Errorf("invalid job id %q", args[0])
+	}
+
+	if err := c.jobs.Retry(ctx, uint(id)); err != nil {
+		return fmt.Errorf("could not retry job %d: %w", id, err)
+	}
+	fmt.Fprintf(c.out, "Job %d is queued again\n", id)
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestCtl(t *testing.T) (*ctl, *gorm.DB, *bytes.Buffer) {
+	db := storagetest.OpenSQLite(t)
+	out := &bytes.Buffer{}
+	jobs := queue.NewDBQueue(db, config.Default().Queue)
+	return &ctl{
+		users:        user.NewService(user.NewRepository(db), jobs),
+		tokens:       auth.NewService(auth.NewRepository(db)),
+		tils:         til.NewService(til.NewRepository(db), jobs),
+		jobs:         jobs,
+		out:          out,
+		readPassword: func() (string, error) { return "correct horse", nil },
+	}, db, out
+}
+
+func TestUserCommands(t *testing.T) {
+	c, db, out := newTestCtl(t)
+	ctx := t.Context()
+
+	require.NoError(t, c.run(ctx, []string{"user", "create", "--admin", "alice", "alice@example.com"}))
+	assert.Contains(t, out.String(), "Created user alice")
+
+	var alice user.User
+	require.NoError(t, db.Where("username = ?", "alice").First(&alice).Error)
+	assert.Equal(t, user.RoleAdmin, alice.Role)
+
+	valid, _, err := c.users.ValidateCredentials(ctx, "alice", "correct horse")
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	require.NoError(t, c.run(ctx, []string{"user", "demote", "alice"}))
+	require.NoError(t, db.First(&alice, alice.ID).Error)
+	assert.Equal(t, user.RoleUser, alice.Role)
+
+	require.NoError(t, db.Create(&auth.RefreshToken{Token: "session", UserID: alice.ID, ExpiresAt: time.Now().Add(time.Hour)}).Error)
+	require.NoError(t, c.run(ctx, []string{"user", "disable", "alice"}))
+	_, _, err = c.users.ValidateCredentials(ctx, "alice", "correct horse")
+	assert.ErrorIs(t, err, user.ErrDisabled)
+	_, err = c.tokens.FindRefreshTokenByUserID(ctx, alice.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "disabling logs the user out")
+
+	require.NoError(t, c.run(ctx, []string{"user", "enable", "alice"}))
+	c.readPassword = func() (string, error) { return "battery staple", nil }
+	require.NoError(t, c.run(ctx, []string{"user", "reset-password", "alice"}))
+	valid, _, err = c.users.ValidateCredentials(ctx, "alice", "battery staple")
+	require.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestUserCommands_Errors(t *testing.T) {
+	c, _, _ := newTestCtl(t)
+	ctx := t.Context()
+
+	assert.ErrorContains(t, c.run(ctx, []string{"user", "promote", "nobody"}), "user nobody not found")
+	assert.ErrorContains(t, c.run(ctx, []string{"user", "create", "a", "a@example.com"}), "username must be")
+	assert.ErrorContains(t, c.run(ctx, []string{"user", "frobnicate", "alice"}), `unknown command "user frobnicate"`)
+
+	c.readPassword = func() (string, error) { return "short", nil }
+	assert.ErrorContains(t, c.run(ctx, []string{"user", "create", "bob", "bob@example.com"}), "at least 8 characters")
+	c.readPassword = func() (string, error) { return strings.Repeat("é", 40), nil }
+	assert.ErrorContains(t, c.run(ctx, []string{"user", "create", "bob", "bob@example.com"}), "at most 72 bytes")
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	c, db, out := newTestCtl(t)
+	ids := storagetest.CreateUsers(t, db, 1)
+	require.NoError(t, db.Create(&auth.RefreshToken{Token: "expired", UserID: ids[0], ExpiresAt: time.Now().Add(-time.Minute)}).Error)
+	require.NoError(t, db.Create(&auth.RefreshToken{Token: "valid", UserID: ids[0], ExpiresAt: time.Now().Add(time.Hour)}).Error)
+
+	require.NoError(t, c.run(t.Context(), []string{"tokens", "purge-expired"}))
+	assert.Equal(t, "Purged 1 expired refresh tokens\n", out.String())
+}
+
+func TestRenderTILs(t *testing.T) {
+	c, db, out := newTestCtl(t)
+	ids := storagetest.CreateUsers(t, db, 1)
+	require.NoError(t, db.Create(&til.TIL{Title: "Go", Content: "*fast*", Category: "go", UserID: ids[0]}).Error)
+
+	require.NoError(t, c.run(t.Context(), []string{"til", "render", "--missing"}))
+	assert.Equal(t, "Rendered 1 TILs\n", out.String())
+
+	var got til.TIL
+	require.NoError(t, db.First(&got).Error)
+	assert.Equal(t, "<p><em>fast</em></p>\n", got.HTML)
+}
+
+func TestQueueCommands(t *testing.T) {
+	c, db, out := newTestCtl(t)
+	dead := &queue.Job{Kind: "mail.send", Payload: "{}", Status: queue.StatusDead, RunAt: time.Now(), Attempts: 10, MaxAttempts: 10, LastError: "connection refused\nretrying"}
+	require.NoError(t, db.Create(dead).Error)
+
+	require.NoError(t, c.run(t.Context(), []string{"queue", "dead"}))
+	assert.Equal(t, "1\tmail.send\t10 attempts\tconnection refused\n1 dead jobs\n", out.String())
+
+	out.Reset()
+	require.NoError(t, c.run(t.Context(), []string{"queue", "retry", "1"}))
+	assert.Equal(t, "Job 1 is queued again\n", out.String())
+	require.NoError(t, db.First(dead, dead.ID).Error)
+	assert.Equal(t, queue.StatusPending, dead.Status)
+	assert.Zero(t, dead.Attempts)
+
+	err := c.run(t.Context(), []string{"queue", "retry", "1"})
+	assert.ErrorContains(t, err, "could not retry job 1", "only dead jobs are retried")
+	assert.Error(t, c.run(t.Context(), []string{"queue", "retry", "one"}))
+}
@@ -0,0 +1,101 @@
+// Command tilctl manages the users and data of the TIL backend straight in its
+// database, through the same services as the server. It reads the same
+// configuration as the server, but only needs DB_DSN.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"golang.org/x/term"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	cfg, args, err := config.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, "\n"+usage)
+		return
+	}
+	if err == nil {
+		err = cfg.Database.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	db, err := database.Open(cfg.Database.DSN, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatalf("failed to open the database: %v", err)
+	}
+
+	// Side effects, like the verification email of a new user, are queued
+	// for the workers of the server
+	jobs := queue.NewDBQueue(db, cfg.Queue)
+	ctl := &ctl{
+		users:        user.NewService(user.NewRepository(db), jobs),
+		tokens:       auth.NewService(auth.NewRepository(db)),
+		tils:         til.NewService(til.NewRepository(db), jobs),
+		jobs:         jobs,
+		out:          os.Stdout,
+		readPassword: passwordReader(os.Stdin),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err = ctl.run(ctx, args)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// passwordReader asks for the password twice without echo on a terminal, and
+// reads the first line otherwise, so it can be piped in.
+func passwordReader(in *os.File) func() (string, error) {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		lines := bufio.NewReader(in)
+		return func() (string, error) {
+			line, err := lines.ReadString('\n')
+			if line == "" && err != nil {
+				return "", fmt.Errorf("could not read the password: %w", err)
+			}
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+	}
+
+	return func() (string, error) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprint(os.Stderr, "Repeat password: ")
+		repeated, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(password) != string(repeated) {
+			return "", errors.New("the passwords do not match")
+		}
+		return string(password), nil
+	}
+}
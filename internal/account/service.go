@@ -0,0 +1,276 @@
+package account
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/user"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPassword = errors.New("invalid password")                            // ErrInvalidPassword is returned when the password re-confirmation fails.
+	ErrReassignToSelf  = errors.New("cannot reassign TILs to the deleted account") // ErrReassignToSelf is returned when the reassign target is the account being deleted.
+	ErrInvalidCode     = errors.New("invalid deletion code")                       // ErrInvalidCode is returned when the deletion code is wrong or expired.
+)
+
+// Service exports and deletes all data that belongs to a user account.
+type Service interface {
+	Export(ctx context.Context, userID uint, w io.Writer) error
+	RequestDeletionCode(ctx context.Context, userID uint) error
+	Delete(ctx context.Context, userID uint, confirm Confirmation) error
+}
+
+// Confirmation confirms the deletion of an account with the password, or with
+// the code mailed by RequestDeletionCode when Code is set. Users that log in
+// through an identity provider have no password they know.
+type Confirmation struct {
+	Password string
+	Code     string
+}
+
+type service struct {
+	db     *gorm.DB
+	users  user.Service
+	tils   til.Service
+	tokens auth.Service
+	prefs  preferences.Service
+	cfg    config.AccountConfig
+}
+
+// NewService returns the account service. A deletion runs in one transaction
+// in db, which the services join.
+func NewService(db *gorm.DB, users user.Service, tils til.Service, tokens auth.Service, prefs preferences.Service, cfg config.AccountConfig) Service {
+	return &service{db: db, users: users, tils: tils, tokens: tokens, prefs: prefs, cfg: cfg}
+}
+
+// Profile is the exported view of a user, without the password hash.
+type Profile struct {
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	DisplayName   string    `json:"display_name"`
+	AvatarURL     string    `json:"avatar_url"`
+	Timezone      string    `json:"timezone"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TIL is the exported view of a TIL entry.
+type TIL struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	HTML      string    `json:"html"`
+	Category  string    `json:"category"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Session is the exported view of a refresh token, without the token itself.
+type Session struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Export writes a zip archive with the profile, preferences, TILs and sessions
+// of the user as JSON, and every TIL as a Markdown file.
+func (s *service) Export(ctx context.Context, userID uint, w io.Writer) error {
+	ctx, span := tracing.Start(ctx, "account.Export")
+	defer span.End()
+
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tils, err := s.tils.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tokens, err := s.tokens.FindRefreshTokensByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	prefs, err := s.prefs.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	profile := Profile{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		AvatarURL:     u.AvatarURL,
+		Timezone:      u.Timezone,
+		Role:          u.Role,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+	}
+	exported := make([]TIL, 0, len(tils))
+	for _, t := range tils {
+		exported = append(exported, TIL{
+			ID:        t.ID,
+			Title:     t.Title,
+			Content:   t.Content,
+			HTML:      t.HTML,
+			Category:  t.Category,
+			CreatedAt: t.CreatedAt,
+			UpdatedAt: t.UpdatedAt,
+		})
+	}
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, Session{ID: t.ID, CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt})
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeJSON(zw, "profile.json", profile); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "preferences.json", prefs); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "tils.json", exported); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "sessions.json", sessions); err != nil {
+		return err
+	}
+	for _, t := range tils {
+		f, err := zw.Create(fmt.Sprintf("tils/%04d-%s.md", t.ID, slug(t.Title)))
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, Markdown(t)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// RequestDeletionCode mails the user a code to confirm the deletion with.
+func (s *service) RequestDeletionCode(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "account.RequestDeletionCode")
+	defer span.End()
+
+	return s.users.RequestDeletionCode(ctx, userID)
+}
+
+// Delete removes the account after the password or the deletion code has been
+// confirmed. The TILs are deleted or reassigned according to the configured
+// policy, the refresh tokens are revoked, the preferences are deleted and the
+// user is anonymized and soft-deleted, all in one transaction. The revoked
+// refresh tokens are hard-deleted once the grace period has passed.
+func (s *service) Delete(ctx context.Context, userID uint, confirm Confirmation) error {
+	ctx, span := tracing.Start(ctx, "account.Delete")
+	defer span.End()
+
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if confirm.Code != "" {
+		valid, err := s.users.CheckDeletionCode(ctx, u.ID, confirm.Code)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return apperr.Unauthorized("Invalid or expired deletion code", ErrInvalidCode)
+		}
+	} else {
+		valid, _, err := s.users.ValidateCredentials(ctx, u.Username, confirm.Password)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return apperr.Unauthorized("Invalid password", ErrInvalidPassword)
+		}
+	}
+
+	var reassignTo uint
+	if s.cfg.TILPolicy == config.TILPolicyReassign {
+		target, err := s.users.GetByUsername(ctx, s.cfg.TILReassignTo)
+		if err != nil {
+			return fmt.Errorf("could not find user %q to reassign TILs to: %w", s.cfg.TILReassignTo, err)
+		}
+		if target.ID == u.ID {
+			return ErrReassignToSelf
+		}
+		reassignTo = target.ID
+	}
+
+	return database.Transaction(ctx, s.db, func(ctx context.Context) error {
+		if reassignTo != 0 {
+			if err := s.tils.ReassignUser(ctx, u.ID, reassignTo); err != nil {
+				return err
+			}
+		} else if err := s.tils.DeleteByUser(ctx, u.ID); err != nil {
+			return err
+		}
+
+		if err := s.tokens.DeleteRefreshTokenByUserID(ctx, u.ID); err != nil {
+			return err
+		}
+		if err := s.prefs.Delete(ctx, u.ID); err != nil {
+			return err
+		}
+
+		return s.users.DeleteAccount(ctx, u.ID)
+	})
+}
+
+// Markdown renders a TIL as a Markdown document.
+func Markdown(t til.TIL) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", t.Title)
+	fmt.Fprintf(&b, "- Category: %s\n", t.Category)
+	fmt.Fprintf(&b, "- Created: %s\n", t.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Updated: %s\n\n", t.UpdatedAt.Format(time.RFC3339))
+	b.WriteString(strings.TrimSpace(t.Content))
+	b.WriteString("\n")
+	return b.String()
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns a title into something that is safe to use in a file name.
+func slug(title string) string {
+	s := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(s) > 50 {
+		s = strings.TrimRight(s[:50], "-")
+	}
+	if s == "" {
+		s = "til"
+	}
+	return s
+}
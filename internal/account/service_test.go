@@ -0,0 +1,253 @@
+package account_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/account"
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type testSetup struct {
+	db         *gorm.DB
+	users      user.Service
+	tokens     auth.Service
+	aliceID    uint
+	adminID    uint
+	tils       til.Service
+	prefs      preferences.Service
+	jobs       *queue.MemoryQueue
+	newService func(cfg config.AccountConfig) account.Service
+}
+
+func setup(t *testing.T) *testSetup {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&user.User{}, &til.TIL{}, &auth.RefreshToken{}, &preferences.UserPreferences{}))
+
+	jobs := queue.NewMemoryQueue()
+	users := user.NewService(user.NewRepository(db), jobs)
+	tils := til.NewService(til.NewRepository(db), queue.NewMemoryQueue())
+	tokens := auth.NewService(auth.NewRepository(db))
+	prefs := preferences.NewService(preferences.NewRepository(db))
+
+	require.NoError(t, users.Register(t.Context(), "alice", "alice@example.com", "secret123"))
+	require.NoError(t, users.Register(t.Context(), "admin", "admin@example.com", "adminpass"))
+	alice, err := users.GetByUsername(t.Context(), "alice")
+	require.NoError(t, err)
+	admin, err := users.GetByUsername(t.Context(), "admin")
+	require.NoError(t, err)
+
+	require.NoError(t, tils.Create(t.Context(), til.TIL{Title: "Go embeds", Content: "Use embed.FS", Category: "go", UserID: alice.ID}))
+	require.NoError(t, tils.Create(t.Context(), til.TIL{Title: "SQL / joins?", Content: "Left joins keep rows", Category: "sql", UserID: alice.ID}))
+	require.NoError(t, db.Create(&auth.RefreshToken{Token: "alice-token", UserID: alice.ID, ExpiresAt: time.Now().Add(time.Hour)}).Error)
+	alicePrefs := preferences.Defaults()
+	alicePrefs.EditorMode = "wysiwyg"
+	_, err = prefs.Save(t.Context(), alice.ID, alicePrefs)
+	require.NoError(t, err)
+
+	return &testSetup{
+		db:      db,
+		users:   users,
+		tokens:  tokens,
+		tils:    tils,
+		prefs:   prefs,
+		jobs:    jobs,
+		aliceID: alice.ID,
+		adminID: admin.ID,
+		newService: func(cfg config.AccountConfig) account.Service {
+			return account.NewService(db, users, tils, tokens, prefs, cfg)
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+
+	var buf bytes.Buffer
+	require.NoError(t, svc.Export(t.Context(), s.aliceID, &buf))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	assert.Contains(t, files, "profile.json")
+	assert.Contains(t, files, "preferences.json")
+	assert.Contains(t, files, "tils.json")
+	assert.Contains(t, files, "sessions.json")
+	assert.Contains(t, files, "tils/0001-go-embeds.md")
+	assert.Contains(t, files, "tils/0002-sql-joins.md")
+
+	var profile map[string]any
+	require.NoError(t, json.Unmarshal([]byte(files["profile.json"]), &profile))
+	assert.Equal(t, "alice", profile["username"])
+	assert.NotContains(t, files["profile.json"], "password", "password hash must not be exported")
+
+	var tils []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(files["tils.json"]), &tils))
+	require.Len(t, tils, 2)
+	assert.Contains(t, tils[0], "updated_at")
+	assert.NotContains(t, tils[0], "DeletedAt")
+
+	var prefs map[string]any
+	require.NoError(t, json.Unmarshal([]byte(files["preferences.json"]), &prefs))
+	assert.Equal(t, "wysiwyg", prefs["editor_mode"])
+
+	assert.NotContains(t, files["sessions.json"], "alice-token", "refresh token must not be exported")
+	assert.Contains(t, files["tils/0001-go-embeds.md"], "# Go embeds")
+	assert.Contains(t, files["tils/0001-go-embeds.md"], "Use embed.FS")
+}
+
+func TestDelete_InvalidPassword(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+
+	err := svc.Delete(t.Context(), s.aliceID, account.Confirmation{Password: "wrong"})
+	assert.True(t, errors.Is(err, account.ErrInvalidPassword))
+
+	_, err = s.users.GetByUsername(t.Context(), "alice")
+	assert.NoError(t, err, "user must not be deleted")
+}
+
+func TestDelete_DeletePolicy(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+
+	require.NoError(t, svc.Delete(t.Context(), s.aliceID, account.Confirmation{Password: "secret123"}))
+
+	// The user is soft-deleted and anonymized
+	_, err := s.users.GetByUsername(t.Context(), "alice")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	var deleted user.User
+	require.NoError(t, s.db.Unscoped().First(&deleted, s.aliceID).Error)
+	assert.True(t, deleted.DeletedAt.Valid)
+	assert.NotContains(t, deleted.Email, "alice")
+	assert.NotContains(t, deleted.Username, "alice")
+
+	// The TILs and preferences are gone
+	var count int64
+	s.db.Model(&til.TIL{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Zero(t, count)
+	s.db.Model(&preferences.UserPreferences{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Zero(t, count)
+
+	// The refresh tokens are revoked, and hard-deleted after the grace period
+	s.db.Model(&auth.RefreshToken{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Zero(t, count)
+	s.db.Unscoped().Model(&auth.RefreshToken{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	n, err := s.tokens.PurgeDeletedRefreshTokens(t.Context(), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n, "tokens within the grace period must be kept")
+
+	n, err = s.tokens.PurgeDeletedRefreshTokens(t.Context(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestDelete_ReassignPolicy(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyReassign, TILReassignTo: "admin"})
+
+	require.NoError(t, svc.Delete(t.Context(), s.aliceID, account.Confirmation{Password: "secret123"}))
+
+	var count int64
+	s.db.Model(&til.TIL{}).Where("user_id = ?", s.adminID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestDelete_ReassignToSelf(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyReassign, TILReassignTo: "alice"})
+
+	err := svc.Delete(t.Context(), s.aliceID, account.Confirmation{Password: "secret123"})
+	assert.True(t, errors.Is(err, account.ErrReassignToSelf))
+}
+
+// failingDelete is a user service that fails to delete the account.
+type failingDelete struct {
+	user.Service
+}
+
+func (failingDelete) DeleteAccount(ctx context.Context, userID uint) error {
+	return errors.New("connection lost")
+}
+
+func TestDelete_RollsBack(t *testing.T) {
+	s := setup(t)
+	svc := account.NewService(s.db, failingDelete{s.users}, s.tils, s.tokens, s.prefs, config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+
+	require.Error(t, svc.Delete(t.Context(), s.aliceID, account.Confirmation{Password: "secret123"}))
+
+	var count int64
+	s.db.Model(&til.TIL{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Equal(t, int64(2), count, "the TILs are kept with the account")
+	s.db.Model(&auth.RefreshToken{}).Where("user_id = ?", s.aliceID).Count(&count)
+	assert.Equal(t, int64(1), count, "the refresh tokens are not revoked")
+}
+
+func TestDelete_WithCode(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+
+	err := svc.RequestDeletionCode(t.Context(), s.aliceID)
+	assert.True(t, errors.Is(err, user.ErrEmailNotVerified), "the code is only mailed to a verified address, got %v", err)
+
+	// Users that log in through an identity provider have a random password
+	bob, err := s.users.FindOrCreateByEmail(t.Context(), "bob@example.com", "bob")
+	require.NoError(t, err)
+	require.NoError(t, svc.RequestDeletionCode(t.Context(), bob.ID))
+	assert.Len(t, s.jobs.Jobs(user.DeletionCodeJob), 1, "the code is mailed")
+
+	err = svc.Delete(t.Context(), bob.ID, account.Confirmation{Code: "WRONG"})
+	assert.True(t, errors.Is(err, account.ErrInvalidCode))
+
+	bob, err = s.users.GetByID(t.Context(), bob.ID)
+	require.NoError(t, err)
+	require.NotNil(t, bob.DeletionCode)
+	require.NoError(t, svc.Delete(t.Context(), bob.ID, account.Confirmation{Code: *bob.DeletionCode}))
+	_, err = s.users.GetByUsername(t.Context(), "bob")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+	var deleted user.User
+	require.NoError(t, s.db.Unscoped().First(&deleted, bob.ID).Error)
+	assert.Nil(t, deleted.DeletionCode)
+}
+
+func TestDelete_ExpiredCode(t *testing.T) {
+	s := setup(t)
+	svc := s.newService(config.AccountConfig{TILPolicy: config.TILPolicyDelete})
+	require.NoError(t, s.db.Model(&user.User{}).Where("id = ?", s.aliceID).Updates(map[string]any{
+		"deletion_code":            "CODE",
+		"deletion_code_expires_at": time.Now().Add(-time.Minute),
+	}).Error)
+
+	err := svc.Delete(t.Context(), s.aliceID, account.Confirmation{Code: "CODE"})
+	assert.True(t, errors.Is(err, account.ErrInvalidCode))
+}
@@ -0,0 +1,97 @@
+// Package apperr has the typed errors that services return for expected
+// failures, like a missing record or an invalid field. The HTTP layer turns
+// them into responses; every other error is treated as an internal error and
+// its message is never shown to clients.
+package apperr
+
+import (
+	"errors"
+	"strings"
+)
+
+// The kinds of errors. Match them with errors.Is.
+var (
+	ErrBadRequest         = errors.New("bad request")         // ErrBadRequest is the kind of error for requests that cannot be processed as sent.
+	ErrUnauthorized       = errors.New("unauthorized")        // ErrUnauthorized is the kind of error for missing or wrong credentials.
+	ErrForbidden          = errors.New("forbidden")           // ErrForbidden is the kind of error for actions the user is not allowed to do.
+	ErrNotFound           = errors.New("not found")           // ErrNotFound is the kind of error for records that do not exist.
+	ErrConflict           = errors.New("conflict")            // ErrConflict is the kind of error for records that clash with existing ones.
+	ErrValidation         = errors.New("validation")          // ErrValidation is the kind of error for invalid fields.
+	ErrPreconditionFailed = errors.New("precondition failed") // ErrPreconditionFailed is the kind of error for records that changed since the client read them.
+)
+
+// FieldError describes why one field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name of the field
+	Code    string `json:"code"`    // Rule that failed, like required or max
+	Message string `json:"message"` // Message for the user
+}
+
+// Error is an expected error with a message that is safe to show to clients.
+type Error struct {
+	Kind   error        // One of the Err* kinds of this package
+	Detail string       // Message for the client
+	Fields []FieldError // Invalid fields, for ErrValidation
+	Err    error        // Cause, for errors.Is and logging; never shown to clients
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// BadRequest returns an error for a request that cannot be processed as sent.
+func BadRequest(detail string, cause error) error {
+	return &Error{Kind: ErrBadRequest, Detail: detail, Err: cause}
+}
+
+// Unauthorized returns an error for missing or wrong credentials.
+func Unauthorized(detail string, cause error) error {
+	return &Error{Kind: ErrUnauthorized, Detail: detail, Err: cause}
+}
+
+// Forbidden returns an error for an action the user is not allowed to do.
+func Forbidden(detail string, cause error) error {
+	return &Error{Kind: ErrForbidden, Detail: detail, Err: cause}
+}
+
+// NotFound returns an error for a record that does not exist.
+func NotFound(detail string, cause error) error {
+	return &Error{Kind: ErrNotFound, Detail: detail, Err: cause}
+}
+
+// Conflict returns an error for a record that clashes with an existing one.
+func Conflict(detail string, cause error) error {
+	return &Error{Kind: ErrConflict, Detail: detail, Err: cause}
+}
+
+// PreconditionFailed returns an error for a record that changed since the
+// client read it.
+func PreconditionFailed(detail string, cause error) error {
+	return &Error{Kind: ErrPreconditionFailed, Detail: detail, Err: cause}
+}
+
+// Validation returns an error for the invalid fields. cause is the validation
+// error of the package, so callers can still match it with errors.Is.
+func Validation(cause error, fields ...FieldError) error {
+	messages := make([]string, len(fields))
+	for i, f := range fields {
+		messages[i] = f.Field + " " + f.Message
+	}
+	return &Error{
+		Kind:   ErrValidation,
+		Detail: "Validation failed: " + strings.Join(messages, "; "),
+		Fields: fields,
+		Err:    cause,
+	}
+}
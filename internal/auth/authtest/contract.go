@@ -0,0 +1,143 @@
+// Package authtest has the contract that every auth.Repository must pass.
+package authtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Setup returns an empty repository, and the ids of two existing users that
+// refresh tokens can belong to.
+type Setup func(t *testing.T) (repo auth.Repository, userA, userB uint)
+
+var day0 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func create(t *testing.T, repo auth.Repository, token string, userID uint, day int) *auth.RefreshToken {
+	t.Helper()
+	rt := &auth.RefreshToken{Token: token, UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	rt.CreatedAt = day0.AddDate(0, 0, day)
+	require.NoError(t, repo.Create(t.Context(), rt))
+	return rt
+}
+
+func tokens(rts []auth.RefreshToken) []string {
+	out := make([]string, 0, len(rts))
+	for _, rt := range rts {
+		out = append(out, rt.Token)
+	}
+	return out
+}
+
+// RunRepositoryContract runs the contract against the repositories of setup.
+func RunRepositoryContract(t *testing.T, setup Setup) {
+	t.Run("FindRefreshTokenByUserID returns the last token", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "alice-1", alice, 0)
+		create(t, repo, "alice-2", alice, 1)
+		create(t, repo, "bob-1", bob, 2)
+
+		rt, err := repo.FindRefreshTokenByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Equal(t, "alice-2", rt.Token)
+	})
+
+	t.Run("FindRefreshTokensByUserID lists newest first", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "alice-old", alice, 0)
+		create(t, repo, "bob-1", bob, 1)
+		create(t, repo, "alice-new", alice, 2)
+
+		rts, err := repo.FindRefreshTokensByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice-new", "alice-old"}, tokens(rts))
+	})
+
+	t.Run("duplicate tokens are rejected", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "same", alice, 0)
+
+		err := repo.Create(t.Context(), &auth.RefreshToken{Token: "same", UserID: bob, ExpiresAt: time.Now().Add(time.Hour)})
+		assert.Error(t, err)
+
+		rts, err := repo.FindRefreshTokensByUserID(t.Context(), bob)
+		require.NoError(t, err)
+		assert.Empty(t, rts)
+	})
+
+	t.Run("missing tokens are not found", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+
+		_, err := repo.FindRefreshTokenByUserID(t.Context(), alice)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+		rts, err := repo.FindRefreshTokensByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Empty(t, rts)
+	})
+
+	t.Run("queries stop when the context is cancelled", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "alice-1", alice, 0)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err := repo.FindRefreshTokensByUserID(ctx, alice)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("revoked tokens are hidden until purged", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "alice-1", alice, 0)
+		create(t, repo, "alice-2", alice, 1)
+		create(t, repo, "bob-1", bob, 2)
+
+		require.NoError(t, repo.DeleteRefreshToken(t.Context(), "alice-2"))
+		rt, err := repo.FindRefreshTokenByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Equal(t, "alice-1", rt.Token)
+
+		require.NoError(t, repo.DeleteRefreshTokenByUserID(t.Context(), alice))
+		_, err = repo.FindRefreshTokenByUserID(t.Context(), alice)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+		n, err := repo.PurgeDeletedRefreshTokens(t.Context(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, n, "tokens revoked after the cut-off are kept")
+
+		n, err = repo.PurgeDeletedRefreshTokens(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+
+		rts, err := repo.FindRefreshTokensByUserID(t.Context(), bob)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bob-1"}, tokens(rts), "tokens that are not revoked are never purged")
+
+		assert.Error(t, repo.DeleteRefreshToken(t.Context(), ""))
+		assert.Error(t, repo.DeleteRefreshTokenByUserID(t.Context(), 0))
+	})
+
+	t.Run("expired tokens are purged, revoked or not", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		expired := &auth.RefreshToken{Token: "alice-expired", UserID: alice, ExpiresAt: time.Now().Add(-time.Hour)}
+		require.NoError(t, repo.Create(t.Context(), expired))
+		revoked := &auth.RefreshToken{Token: "bob-expired", UserID: bob, ExpiresAt: time.Now().Add(-time.Hour)}
+		require.NoError(t, repo.Create(t.Context(), revoked))
+		require.NoError(t, repo.DeleteRefreshToken(t.Context(), "bob-expired"))
+		create(t, repo, "alice-valid", alice, 0)
+
+		n, err := repo.PurgeExpiredRefreshTokens(t.Context(), time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+
+		rts, err := repo.FindRefreshTokensByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice-valid"}, tokens(rts))
+	})
+}
@@ -4,76 +4,80 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"os"
-	"path"
 	"strconv"
 	"time"
 
+	"github.com/amavis442/til-backend/internal/config"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var (
+// Tokens signs and verifies the access and refresh tokens.
+type Tokens struct {
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
-)
-
-func InitJWTKeys(root string) error {
-	privPath := os.Getenv("JWT_PRIVATE_KEY_PATH")
-	if privPath == "" {
-		return fmt.Errorf("JWT_PRIVATE_KEY_PATH not set")
-	}
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
 
-	pubPath := os.Getenv("JWT_PUBLIC_KEY_PATH")
-	if pubPath == "" {
-		return fmt.Errorf("JWT_PUBLIC_KEY_PATH not set")
-	}
-	if root == "" {
-		root = "./"
-	}
+// NewTokens reads the RSA keys cfg points to.
+func NewTokens(cfg config.JWTConfig) (*Tokens, error) {
+	t := &Tokens{accessTTL: cfg.AccessTokenTTL, refreshTTL: cfg.RefreshTokenTTL}
 
-	privData, err := os.ReadFile(path.Join(root, privPath))
+	privData, err := os.ReadFile(cfg.PrivateKeyPath)
 	if err != nil {
-		return fmt.Errorf("could not read private key: %w", err)
+		return nil, fmt.Errorf("could not read private key: %w", err)
 	}
 
-	privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privData)
+	t.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privData)
 	if err != nil {
-		return fmt.Errorf("invalid private key: %w", err)
+		return nil, fmt.Errorf("invalid private key: %w", err)
 	}
 
-	pubData, err := os.ReadFile(path.Join(root, pubPath))
+	pubData, err := os.ReadFile(cfg.PublicKeyPath)
 	if err != nil {
-		return fmt.Errorf("could not read public key: %w", err)
+		return nil, fmt.Errorf("could not read public key: %w", err)
 	}
 
-	publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pubData)
+	t.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pubData)
 	if err != nil {
-		return fmt.Errorf("invalid public key: %w", err)
+		return nil, fmt.Errorf("invalid public key: %w", err)
 	}
-	return nil
+	return t, nil
 }
 
-func GenerateTokens(userID uint) (accessToken string, refreshToken string, err error) {
-	accessToken, err = GenerateAccessToken(userID)
+// KeysLoaded reports whether both keys are loaded, so tokens can be signed
+// and verified.
+func (t *Tokens) KeysLoaded() bool {
+	return t != nil && t.privateKey != nil && t.publicKey != nil
+}
+
+// AccessTTL returns how long access tokens are valid.
+func (t *Tokens) AccessTTL() time.Duration {
+	return t.accessTTL
+}
+
+func (t *Tokens) Generate(userID uint) (accessToken string, refreshToken string, err error) {
+	accessToken, err = t.GenerateAccessToken(userID)
 	if err != nil {
 		return
 	}
 
-	refreshToken, err = GenerateRefreshToken(userID)
+	refreshToken, err = t.GenerateRefreshToken(userID)
 	return
 }
 
-func GenerateAccessToken(userID uint) (accessToken string, err error) {
+func (t *Tokens) GenerateAccessToken(userID uint) (accessToken string, err error) {
 	now := time.Now()
 	userIDStr := strconv.FormatUint(uint64(userID), 10)
 
 	accessClaims := jwt.MapClaims{
 		"sub": userIDStr,
-		"exp": now.Add(time.Minute * 15).Unix(), // 15 minutes access token
+		"exp": now.Add(t.accessTTL).Unix(),
 		"typ": "access",
 	}
 
 	access := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims)
-	accessToken, err = access.SignedString(privateKey)
+	accessToken, err = access.SignedString(t.privateKey)
 	if err != nil {
 		return
 	}
@@ -81,25 +85,25 @@ func GenerateAccessToken(userID uint) (accessToken string, err error) {
 	return
 }
 
-func GenerateRefreshToken(userID uint) (refreshToken string, err error) {
+func (t *Tokens) GenerateRefreshToken(userID uint) (refreshToken string, err error) {
 	now := time.Now()
 	userIDStr := strconv.FormatUint(uint64(userID), 10)
 
 	refreshClaims := jwt.MapClaims{
 		"sub": userIDStr,
-		"exp": now.Add(7 * 24 * time.Hour).Unix(), // 1 week refresh token
+		"exp": now.Add(t.refreshTTL).Unix(),
 		"typ": "refresh",
 	}
 
 	refresh := jwt.NewWithClaims(jwt.SigningMethodRS256, refreshClaims)
 
-	refreshToken, err = refresh.SignedString(privateKey)
+	refreshToken, err = refresh.SignedString(t.privateKey)
 	return
 }
 
-func VerifyToken(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		return publicKey, nil
+func (t *Tokens) Verify(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, func(*jwt.Token) (interface{}, error) {
+		return t.publicKey, nil
 	})
 
 	if err != nil || !token.Valid {
@@ -114,6 +118,10 @@ func VerifyToken(tokenStr string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
+func (t *Tokens) ExtractUserID(claims jwt.MapClaims) (uint, error) {
+	return ExtractUserIDFromClaims(claims)
+}
+
 func ExtractUserIDFromClaims(claims jwt.MapClaims) (uint, error) {
 	sub, ok := claims["sub"].(string)
 	if !ok {
@@ -4,37 +4,40 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/amavis442/til-backend/internal/auth"
 	"github.com/amavis442/til-backend/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestMain(m *testing.M) {
-	root := "../../"
-	// Load env file before anything else
-	config.Load()
+var tokens *auth.Tokens
 
-	if err := auth.InitJWTKeys(root); err != nil {
+func TestMain(m *testing.M) {
+	var err error
+	tokens, err = auth.NewTokens(config.JWTConfig{
+		PrivateKeyPath:  "../../config/jwt/private.pem",
+		PublicKeyPath:   "../../config/jwt/public.pem",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+	})
+	if err != nil {
 		log.Fatalf("failed to load keys: %v", err)
 	}
 
 	// Run the tests
 	os.Exit(m.Run())
 }
-func TestEnvLoaded(t *testing.T) {
-	t.Log("JWT_PRIVATE_KEY_PATH =", os.Getenv("JWT_PRIVATE_KEY_PATH"))
-}
 
 func TestGenerateAndVerifyTokens(t *testing.T) {
 	userID := uint(42)
 
-	access, refresh, err := auth.GenerateTokens(userID)
+	access, refresh, err := tokens.Generate(userID)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, access)
 	assert.NotEmpty(t, refresh)
 
-	claims, err := auth.VerifyToken(refresh)
+	claims, err := tokens.Verify(refresh)
 	assert.NoError(t, err)
 
 	id, err := auth.ExtractUserIDFromClaims(claims)
@@ -43,4 +46,10 @@ func TestGenerateAndVerifyTokens(t *testing.T) {
 
 	assert.Equal(t, "refresh", claims["typ"])
 
+	assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), time.Unix(int64(claims["exp"].(float64)), 0), 2*time.Second)
+}
+
+func TestNewTokens_MissingKey(t *testing.T) {
+	_, err := auth.NewTokens(config.JWTConfig{PrivateKeyPath: "missing.pem", PublicKeyPath: "missing.pem"})
+	assert.ErrorContains(t, err, "could not read private key")
 }
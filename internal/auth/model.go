@@ -8,7 +8,7 @@ import (
 
 type RefreshToken struct {
 	gorm.Model
-	Token     string    `gorm:"unique;not null"`
+	Token     string    `gorm:"type:text;unique;not null"`
 	UserID    uint      `gorm:"not null"`
 	ExpiresAt time.Time `gorm:"not null"`
 }
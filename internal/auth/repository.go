@@ -1,16 +1,22 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"time"
 
+	"github.com/amavis442/til-backend/internal/database"
 	"gorm.io/gorm"
 )
 
 type Repository interface {
-	Create(refreshToken *RefreshToken) error
-	FindRefreshTokenByUserID(userID uint) (*RefreshToken, error)
-	DeleteRefreshToken(token string) error
-	DeleteRefreshTokenByUserID(userID uint) error
+	Create(ctx context.Context, refreshToken *RefreshToken) error
+	FindRefreshTokenByUserID(ctx context.Context, userID uint) (*RefreshToken, error)
+	DeleteRefreshToken(ctx context.Context, token string) error
+	DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error
+	FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]RefreshToken, error)
+	PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+	PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
 }
 
 type repository struct {
@@ -21,26 +27,44 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db: db}
 }
 
-func (r *repository) Create(refreshToken *RefreshToken) error {
-	return r.db.Create(&refreshToken).Error
+func (r *repository) Create(ctx context.Context, refreshToken *RefreshToken) error {
+	return database.Conn(ctx, r.db).Create(&refreshToken).Error
 }
 
-func (r *repository) FindRefreshTokenByUserID(userID uint) (*RefreshToken, error) {
+func (r *repository) FindRefreshTokenByUserID(ctx context.Context, userID uint) (*RefreshToken, error) {
 	var refreshToken RefreshToken
-	result := r.db.Where("user_id = ?", userID).Last(&refreshToken)
+	result := database.Conn(ctx, r.db).Where("user_id = ?", userID).Last(&refreshToken)
 	return &refreshToken, result.Error
 }
 
-func (r *repository) DeleteRefreshToken(token string) error {
+func (r *repository) DeleteRefreshToken(ctx context.Context, token string) error {
 	if token == "" {
 		return errors.New("refresh token must not be empty")
 	}
-	return r.db.Where("token = ?", token).Delete(&RefreshToken{}).Error
+	return database.Conn(ctx, r.db).Where("token = ?", token).Delete(&RefreshToken{}).Error
 }
 
-func (r *repository) DeleteRefreshTokenByUserID(userID uint) error {
+func (r *repository) DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error {
 	if userID == 0 {
 		return errors.New("user id must not be empty cannot remove refresh token")
 	}
-	return r.db.Where("user_id = ?", userID).Delete(&RefreshToken{}).Error
+	return database.Conn(ctx, r.db).Where("user_id = ?", userID).Delete(&RefreshToken{}).Error
+}
+
+func (r *repository) FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]RefreshToken, error) {
+	var refreshTokens []RefreshToken
+	err := database.Conn(ctx, r.db).Where("user_id = ?", userID).Order("created_at desc").Find(&refreshTokens).Error
+	return refreshTokens, err
+}
+
+// PurgeDeletedRefreshTokens permanently removes refresh tokens that were soft-deleted before the given time.
+func (r *repository) PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	result := database.Conn(ctx, r.db).Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&RefreshToken{})
+	return result.RowsAffected, result.Error
+}
+
+// PurgeExpiredRefreshTokens permanently removes refresh tokens, revoked or not, that expired before the given time.
+func (r *repository) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	result := database.Conn(ctx, r.db).Unscoped().Where("expires_at < ?", before).Delete(&RefreshToken{})
+	return result.RowsAffected, result.Error
 }
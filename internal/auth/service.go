@@ -1,14 +1,21 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/tracing"
 )
 
 type Service interface {
-	SaveRefreshToken(userID uint, token string) error
-	FindRefreshTokenByUserID(userID uint) (*RefreshToken, error)
-	DeleteRefreshToken(token string) error
-	DeleteRefreshTokenByUserID(userID uint) error
+	SaveRefreshToken(ctx context.Context, userID uint, token string) error
+	FindRefreshTokenByUserID(ctx context.Context, userID uint) (*RefreshToken, error)
+	DeleteRefreshToken(ctx context.Context, token string) error
+	DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error
+	FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]RefreshToken, error)
+	PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+	PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
 }
 
 type service struct {
@@ -20,7 +27,10 @@ func NewService(r Repository) Service {
 }
 
 // Create implements Service.
-func (s *service) SaveRefreshToken(userID uint, token string) error {
+func (s *service) SaveRefreshToken(ctx context.Context, userID uint, token string) error {
+	ctx, span := tracing.Start(ctx, "auth.SaveRefreshToken")
+	defer span.End()
+
 	refreshTokenExpiresAt, err := TokenExpiresAt(token)
 	if err != nil {
 		return err
@@ -30,20 +40,56 @@ func (s *service) SaveRefreshToken(userID uint, token string) error {
 	refreshToken.UserID = userID
 	refreshToken.Token = token
 	refreshToken.ExpiresAt = *refreshTokenExpiresAt
-	return s.repo.Create(&refreshToken)
+	return s.repo.Create(ctx, &refreshToken)
 }
 
 // GetTokenByUserID implements Service.
-func (s *service) FindRefreshTokenByUserID(userID uint) (*RefreshToken, error) {
+func (s *service) FindRefreshTokenByUserID(ctx context.Context, userID uint) (*RefreshToken, error) {
+	ctx, span := tracing.Start(ctx, "auth.FindRefreshTokenByUserID")
+	defer span.End()
+
 	if userID == 0 {
 		return nil, errors.New("invalid userID")
 	}
-	return s.repo.FindRefreshTokenByUserID(userID)
+	return s.repo.FindRefreshTokenByUserID(ctx, userID)
 }
 
-func (s *service) DeleteRefreshToken(token string) error {
-	return s.repo.DeleteRefreshToken(token)
+func (s *service) DeleteRefreshToken(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "auth.DeleteRefreshToken")
+	defer span.End()
+
+	return s.repo.DeleteRefreshToken(ctx, token)
 }
-func (s *service) DeleteRefreshTokenByUserID(userID uint) error {
-	return s.repo.DeleteRefreshTokenByUserID(userID)
+func (s *service) DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "auth.DeleteRefreshTokenByUserID")
+	defer span.End()
+
+	return s.repo.DeleteRefreshTokenByUserID(ctx, userID)
+}
+
+// FindRefreshTokensByUserID returns the active sessions of a user, newest first.
+func (s *service) FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]RefreshToken, error) {
+	ctx, span := tracing.Start(ctx, "auth.FindRefreshTokensByUserID")
+	defer span.End()
+
+	if userID == 0 {
+		return nil, errors.New("invalid userID")
+	}
+	return s.repo.FindRefreshTokensByUserID(ctx, userID)
+}
+
+// PurgeDeletedRefreshTokens hard-deletes refresh tokens that were revoked before the given time.
+func (s *service) PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "auth.PurgeDeletedRefreshTokens")
+	defer span.End()
+
+	return s.repo.PurgeDeletedRefreshTokens(ctx, before)
+}
+
+// PurgeExpiredRefreshTokens hard-deletes refresh tokens that expired before the given time.
+func (s *service) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "auth.PurgeExpiredRefreshTokens")
+	defer span.End()
+
+	return s.repo.PurgeExpiredRefreshTokens(ctx, before)
 }
@@ -1,6 +1,7 @@
 package auth_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -13,36 +14,60 @@ type mockRepository struct {
 	FindRefreshTokenByUserIDFunc   func(userID uint) (*auth.RefreshToken, error)
 	DeleteRefreshTokenFunc         func(token string) error
 	DeleteRefreshTokenByUserIDFunc func(userID uint) error
+	FindRefreshTokensByUserIDFunc  func(userID uint) ([]auth.RefreshToken, error)
+	PurgeDeletedRefreshTokensFunc  func(before time.Time) (int64, error)
+	PurgeExpiredRefreshTokensFunc  func(before time.Time) (int64, error)
 }
 
-func (m *mockRepository) Create(token *auth.RefreshToken) error {
+func (m *mockRepository) Create(ctx context.Context, token *auth.RefreshToken) error {
 	if m.CreateFunc != nil {
 		return m.CreateFunc(token)
 	}
 	return nil
 }
 
-func (m *mockRepository) FindRefreshTokenByUserID(userID uint) (*auth.RefreshToken, error) {
+func (m *mockRepository) FindRefreshTokenByUserID(ctx context.Context, userID uint) (*auth.RefreshToken, error) {
 	if m.FindRefreshTokenByUserIDFunc != nil {
 		return m.FindRefreshTokenByUserIDFunc(userID)
 	}
 	return nil, nil
 }
 
-func (m *mockRepository) DeleteRefreshToken(token string) error {
+func (m *mockRepository) DeleteRefreshToken(ctx context.Context, token string) error {
 	if m.DeleteRefreshTokenFunc != nil {
 		return m.DeleteRefreshTokenFunc(token)
 	}
 	return nil
 }
 
-func (m *mockRepository) DeleteRefreshTokenByUserID(userID uint) error {
+func (m *mockRepository) DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error {
 	if m.DeleteRefreshTokenByUserIDFunc != nil {
 		return m.DeleteRefreshTokenByUserIDFunc(userID)
 	}
 	return nil
 }
 
+func (m *mockRepository) FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]auth.RefreshToken, error) {
+	if m.FindRefreshTokensByUserIDFunc != nil {
+		return m.FindRefreshTokensByUserIDFunc(userID)
+	}
+	return nil, nil
+}
+
+func (m *mockRepository) PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	if m.PurgeDeletedRefreshTokensFunc != nil {
+		return m.PurgeDeletedRefreshTokensFunc(before)
+	}
+	return 0, nil
+}
+
+func (m *mockRepository) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	if m.PurgeExpiredRefreshTokensFunc != nil {
+		return m.PurgeExpiredRefreshTokensFunc(before)
+	}
+	return 0, nil
+}
+
 func TestSaveRefreshToken(t *testing.T) {
 	mockRepo := &mockRepository{
 		CreateFunc: func(token *auth.RefreshToken) error {
@@ -64,7 +89,7 @@ func TestSaveRefreshToken(t *testing.T) {
 
 	svc := auth.NewService(mockRepo)
 
-	err := svc.SaveRefreshToken(42, "test.token.value")
+	err := svc.SaveRefreshToken(t.Context(), 42, "test.token.value")
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -79,7 +104,7 @@ func TestSaveRefreshToken_TokenExpiresAtError(t *testing.T) {
 
 	svc := auth.NewService(mockRepo)
 
-	err := svc.SaveRefreshToken(1, "bad.token")
+	err := svc.SaveRefreshToken(t.Context(), 1, "bad.token")
 	if err == nil || err.Error() != "parse error" {
 		t.Errorf("expected 'parse error', got %v", err)
 	}
@@ -97,7 +122,7 @@ func TestFindRefreshTokenByUserID(t *testing.T) {
 
 	svc := auth.NewService(mockRepo)
 
-	token, err := svc.FindRefreshTokenByUserID(123)
+	token, err := svc.FindRefreshTokenByUserID(t.Context(), 123)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -118,8 +143,30 @@ func TestDeleteRefreshToken(t *testing.T) {
 
 	svc := auth.NewService(mockRepo)
 
-	err := svc.DeleteRefreshToken("delete-me")
+	err := svc.DeleteRefreshToken(t.Context(), "delete-me")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestPurgeDeletedRefreshTokens(t *testing.T) {
+	cutoff := time.Now().Add(-24 * time.Hour)
+	mockRepo := &mockRepository{
+		PurgeDeletedRefreshTokensFunc: func(before time.Time) (int64, error) {
+			if !before.Equal(cutoff) {
+				t.Errorf("expected cutoff %v, got %v", cutoff, before)
+			}
+			return 3, nil
+		},
+	}
+
+	svc := auth.NewService(mockRepo)
+
+	n, err := svc.PurgeDeletedRefreshTokens(t.Context(), cutoff)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 purged tokens, got %d", n)
+	}
+}
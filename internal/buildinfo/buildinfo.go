@@ -0,0 +1,64 @@
+// Package buildinfo describes the build of the running server. Version, Commit
+// and Date are set by the linker:
+//
+//	go build -ldflags "-X github.com/amavis442/til-backend/internal/buildinfo.Version=v1.2.0" ./cmd/server
+//
+// Without them the commit and date come from the version control information
+// that go build embeds.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev" // Release of the server
+	Commit  = ""    // Git commit the server was built from
+	Date    = ""    // Time of the build or the commit, RFC 3339
+)
+
+// Info is the build information of the running server.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified"` // Built with uncommitted changes
+	GoVersion string `json:"go_version"`
+	Module    string `json:"module,omitempty"`
+}
+
+// Get returns the build information. Values set by the linker win over the
+// embedded version control information.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module = bi.Main.Path
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
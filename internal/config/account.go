@@ -0,0 +1,54 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	TILPolicyDelete   = "delete"   // TILs of a deleted account are deleted as well
+	TILPolicyReassign = "reassign" // TILs of a deleted account are moved to another user
+)
+
+// AccountConfig holds the settings used when users delete their account.
+type AccountConfig struct {
+	TILPolicy               string        `yaml:"til_policy" toml:"til_policy"`                               // What happens with the TILs of a deleted account
+	TILReassignTo           string        `yaml:"til_reassign_to" toml:"til_reassign_to"`                     // Username that receives the TILs when TILPolicy is reassign
+	RefreshTokenGracePeriod time.Duration `yaml:"refresh_token_purge_after" toml:"refresh_token_purge_after"` // How long revoked refresh tokens are kept before they are hard-deleted
+}
+
+func (c AccountConfig) Validate() error {
+	var errs []error
+
+	switch c.TILPolicy {
+	case TILPolicyDelete:
+	case TILPolicyReassign:
+		if c.TILReassignTo == "" {
+			errs = append(errs, errors.New("ACCOUNT_TIL_REASSIGN_TO must be set when ACCOUNT_TIL_POLICY=reassign"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("ACCOUNT_TIL_POLICY must be delete or reassign, got %q", c.TILPolicy))
+	}
+
+	if c.RefreshTokenGracePeriod < 0 {
+		errs = append(errs, errors.New("REFRESH_TOKEN_PURGE_AFTER must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func defaultAccountConfig() AccountConfig {
+	return AccountConfig{
+		TILPolicy:               TILPolicyDelete,
+		RefreshTokenGracePeriod: 30 * 24 * time.Hour,
+	}
+}
+
+func (c *AccountConfig) settings() []setting {
+	return []setting{
+		{"ACCOUNT_TIL_POLICY", "what happens with the TILs of a deleted account: delete or reassign", &c.TILPolicy},
+		{"ACCOUNT_TIL_REASSIGN_TO", "username that receives the TILs of deleted accounts", &c.TILReassignTo},
+		{"REFRESH_TOKEN_PURGE_AFTER", "how long revoked refresh tokens are kept", &c.RefreshTokenGracePeriod},
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// hostPrefix is the cookie name prefix that browsers only accept for
+// cookies that are Secure, have Path=/ and carry no Domain attribute.
+const hostPrefix = "__Host-"
+
+// CookieConfig holds the settings used for the access token cookie.
+type CookieConfig struct {
+	Name       string `yaml:"name" toml:"name"`     // Cookie name without the __Host- prefix
+	Domain     string `yaml:"domain" toml:"domain"` // Empty means a host-only cookie
+	Path       string `yaml:"path" toml:"path"`
+	SameSite   string `yaml:"same_site" toml:"same_site"` // Lax, Strict or None
+	Secure     bool   `yaml:"secure" toml:"secure"`
+	HostPrefix bool   `yaml:"host_prefix" toml:"host_prefix"` // Prefix the name with __Host-
+}
+
+// FullName returns the cookie name as it is sent to the browser.
+func (c CookieConfig) FullName() string {
+	if c.HostPrefix {
+		return hostPrefix + c.Name
+	}
+	return c.Name
+}
+
+// Validate checks that the combination of cookie settings will be accepted by browsers.
+func (c CookieConfig) Validate() error {
+	var errs []error
+
+	if c.Name == "" {
+		errs = append(errs, errors.New("COOKIE_NAME must not be empty"))
+	}
+	if strings.HasPrefix(c.Name, hostPrefix) {
+		errs = append(errs, fmt.Errorf("COOKIE_NAME must not contain the %s prefix, use COOKIE_HOST_PREFIX=true instead", hostPrefix))
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		errs = append(errs, fmt.Errorf("COOKIE_PATH must start with '/', got %q", c.Path))
+	}
+
+	switch c.SameSite {
+	case "Lax", "Strict":
+	case "None":
+		if !c.Secure {
+			errs = append(errs, errors.New("COOKIE_SAMESITE=None requires COOKIE_SECURE=true"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("COOKIE_SAMESITE must be one of Lax, Strict or None, got %q", c.SameSite))
+	}
+
+	if c.HostPrefix {
+		if !c.Secure {
+			errs = append(errs, fmt.Errorf("COOKIE_HOST_PREFIX requires COOKIE_SECURE=true"))
+		}
+		if c.Domain != "" {
+			errs = append(errs, fmt.Errorf("COOKIE_HOST_PREFIX requires COOKIE_DOMAIN to be empty"))
+		}
+		if c.Path != "/" {
+			errs = append(errs, fmt.Errorf("COOKIE_HOST_PREFIX requires COOKIE_PATH=/"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// defaultCookieConfig keeps the old behaviour: SameSite=None and Secure in
+// production, Lax otherwise.
+func defaultCookieConfig(isProduction bool) CookieConfig {
+	cfg := CookieConfig{Name: "access_token", Path: "/", SameSite: "Lax"}
+	if isProduction {
+		cfg.SameSite = "None"
+		cfg.Secure = true
+	}
+	return cfg
+}
+
+func (c *CookieConfig) settings() []setting {
+	return []setting{
+		{"COOKIE_NAME", "name of the access token cookie, without the __Host- prefix", &c.Name},
+		{"COOKIE_DOMAIN", "domain of the access token cookie, empty for a host-only cookie", &c.Domain},
+		{"COOKIE_PATH", "path of the access token cookie", &c.Path},
+		{"COOKIE_SAMESITE", "SameSite of the access token cookie: Lax, Strict or None", &c.SameSite},
+		{"COOKIE_SECURE", "send the cookies over HTTPS only", &c.Secure},
+		{"COOKIE_HOST_PREFIX", "prefix the access token cookie name with __Host-", &c.HostPrefix},
+	}
+}
+
+// normalizeSameSite turns values like "lax" or "NONE" into the canonical form.
+func normalizeSameSite(v string) string {
+	switch strings.ToLower(v) {
+	case "lax":
+		return "Lax"
+	case "strict":
+		return "Strict"
+	case "none":
+		return "None"
+	}
+	return v
+}
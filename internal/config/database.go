@@ -0,0 +1,36 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// DatabaseConfig holds the connection to the database.
+type DatabaseConfig struct {
+	DSN            string        `yaml:"dsn" toml:"dsn"`                           // Postgres DSN, or sqlite://path/to/file.db
+	MigrateOnStart bool          `yaml:"migrate_on_start" toml:"migrate_on_start"` // Apply pending migrations before serving
+	QueryTimeout   time.Duration `yaml:"query_timeout" toml:"query_timeout"`       // Deadline for the database queries of one request, 0 for none
+}
+
+func (c DatabaseConfig) Validate() error {
+	var errs []error
+	if c.DSN == "" {
+		errs = append(errs, errors.New("DB_DSN must be set"))
+	}
+	if c.QueryTimeout < 0 {
+		errs = append(errs, errors.New("DB_QUERY_TIMEOUT must not be negative, use 0 for none"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultDatabaseConfig() DatabaseConfig {
+	return DatabaseConfig{QueryTimeout: 5 * time.Second}
+}
+
+func (c *DatabaseConfig) settings() []setting {
+	return []setting{
+		{"DB_DSN", "Postgres DSN, or sqlite://path/to/file.db", &c.DSN},
+		{"MIGRATE_ON_START", "apply pending migrations before serving", &c.MigrateOnStart},
+		{"DB_QUERY_TIMEOUT", "deadline for the database queries of one request, 0 for none", &c.QueryTimeout},
+	}
+}
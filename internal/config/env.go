@@ -1,101 +1,192 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Config holds all settings of the server. Load builds it from the defaults,
+// an optional YAML or TOML file, the environment and the flags, in that order.
 type Config struct {
-	CORSAllowedOrigin string
-	ENV               string
-	DB_DSN            string
-	PORT              string
-	// Add more vars here: DB_URL, PORT, etc.
+	Env                string          `yaml:"env" toml:"env"`                                   // dev, or anything else for production
+	Port               int             `yaml:"port" toml:"port"`                                 // Port the API listens on
+	CORSAllowedOrigins []string        `yaml:"cors_allowed_origins" toml:"cors_allowed_origins"` // Origins whose pages may call the API with credentials
+	LogLevel           slog.Level      `yaml:"log_level" toml:"log_level"`                       // Records below this level are not logged
+	ReadyTimeout       time.Duration   `yaml:"ready_timeout" toml:"ready_timeout"`               // Deadline for each check of /readyz
+	Database           DatabaseConfig  `yaml:"database" toml:"database"`
+	JWT                JWTConfig       `yaml:"jwt" toml:"jwt"`
+	Cookie             CookieConfig    `yaml:"cookie" toml:"cookie"`
+	OIDC               OIDCConfig      `yaml:"oidc" toml:"oidc"`
+	Account            AccountConfig   `yaml:"account" toml:"account"`
+	Server             ServerConfig    `yaml:"server" toml:"server"`
+	Metrics            MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Tracing            TracingConfig   `yaml:"tracing" toml:"tracing"`
+	RateLimit          RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Scheduler          SchedulerConfig `yaml:"scheduler" toml:"scheduler"`
+	Mail               MailConfig      `yaml:"mail" toml:"mail"`
+	Queue              QueueConfig     `yaml:"queue" toml:"queue"`
 }
 
-var requiredEnvVars = []string{
-	"CORS_ALLOWED_ORIGIN",
-	"ENV",
-	"DB_DSN", "PORT",
+// Default returns the configuration of a development server. Settings without
+// a sensible default, like DB_DSN, are left empty.
+func Default() Config {
+	return Config{
+		Env:          "local",
+		LogLevel:     slog.LevelInfo,
+		ReadyTimeout: 2 * time.Second,
+		Database:     defaultDatabaseConfig(),
+		JWT:          defaultJWTConfig(),
+		Cookie:       defaultCookieConfig(false),
+		OIDC:         defaultOIDCConfig(),
+		Account:      defaultAccountConfig(),
+		Server:       defaultServerConfig(),
+		Tracing:      defaultTracingConfig(),
+		RateLimit:    defaultRateLimitConfig(),
+		Scheduler:    defaultSchedulerConfig(),
+		Queue:        defaultQueueConfig(),
+	}
 }
 
-func validateRequiredEnvVars(keys []string) {
-	for _, key := range keys {
-		if os.Getenv(key) == "" {
-			log.Fatalf("Missing required env var: %s", key)
-		}
-	}
+// IsProduction reports whether the server runs anywhere but on a developer
+// machine, which makes the cookies Secure by default.
+func (c Config) IsProduction() bool {
+	return !strings.EqualFold(c.Env, "dev")
 }
 
-func Load() Config {
-	root := findProjectRoot()
+func (c *Config) settings() []setting {
+	s := []setting{
+		{"ENV", "dev, or anything else for production", &c.Env},
+		{"PORT", "port the API listens on", &c.Port},
+		{"CORS_ALLOWED_ORIGIN", "origins whose pages may call the API, comma separated", &c.CORSAllowedOrigins},
+		{"LOG_LEVEL", "lowest level that is logged: debug, info, warn or error", &c.LogLevel},
+		{"READY_TIMEOUT", "deadline for each check of /readyz", &c.ReadyTimeout},
+	}
+	s = append(s, c.Database.settings()...)
+	s = append(s, c.JWT.settings()...)
+	s = append(s, c.Cookie.settings()...)
+	s = append(s, c.OIDC.settings()...)
+	s = append(s, c.Account.settings()...)
+	s = append(s, c.Server.settings()...)
+	s = append(s, c.Metrics.settings()...)
+	s = append(s, c.Tracing.settings()...)
+	s = append(s, c.RateLimit.settings()...)
+	s = append(s, c.Scheduler.settings()...)
+	s = append(s, c.Mail.settings()...)
+	s = append(s, c.Queue.settings()...)
+	return s
+}
 
-	// Always load .env (default config)
-	_ = godotenv.Load(filepath.Join(root, ".env"))
+// Validate checks every setting and reports all problems at once.
+func (c Config) Validate() error {
+	var errs []error
 
-	// Override with .env.local (if exists)
-	envPath := filepath.Join(root, ".env.local")
-	if err := godotenv.Overload(envPath); err != nil {
-		log.Printf("No .env.local found at %s — relying on system env", envPath)
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be between 1 and 65535, got %d", c.Port))
 	}
-
-	env := os.Getenv("ENV")
-	if env == "" {
-		env = "local"
-		os.Setenv("ENV", env)
+	if len(c.CORSAllowedOrigins) == 0 {
+		errs = append(errs, errors.New("CORS_ALLOWED_ORIGIN must be set"))
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" {
+			errs = append(errs, errors.New("CORS_ALLOWED_ORIGIN must not be *, the API allows credentials"))
+		}
+	}
+	if c.ReadyTimeout <= 0 {
+		errs = append(errs, errors.New("READY_TIMEOUT must be positive"))
 	}
 
-	validateRequiredEnvVars(requiredEnvVars)
-
-	slog.Info(fmt.Sprintf("Loaded environment: %s", env))
+	errs = append(errs,
+		c.Database.Validate(),
+		c.JWT.Validate(),
+		c.Cookie.Validate(),
+		c.OIDC.Validate(),
+		c.Account.Validate(),
+		c.Server.Validate(),
+		c.Tracing.Validate(),
+		c.RateLimit.Validate(),
+		c.Scheduler.Validate(),
+		c.Mail.Validate(),
+		c.Queue.Validate(),
+	)
+	return errors.Join(errs...)
+}
 
-	return Config{
-		CORSAllowedOrigin: os.Getenv("CORS_ALLOWED_ORIGIN"),
-		ENV:               env,
-		DB_DSN:            os.Getenv("DB_DSN"),
-		PORT:              os.Getenv("PORT"),
+// Load builds the configuration from the defaults, the file named by --config
+// or CONFIG_FILE, the environment and the flags in args, and validates it.
+//
+// It returns the arguments after the flags, like a subcommand, and every
+// problem with the configuration at once. The configuration is returned even
+// when it is invalid, so it can be printed.
+func Load(args []string) (Config, []string, error) {
+	cfg, rest, err := Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return cfg, rest, err
 	}
+	return cfg, rest, errors.Join(err, cfg.Validate())
 }
 
-func findProjectRoot() string {
-	//return "."
-	currentDir, err := os.Getwd()
-	if err != nil {
-		panic(err)
+// Parse builds the configuration like Load, but only reports the settings
+// that cannot be read. Commands that need part of the configuration validate
+// that part themselves. A .env and a .env.local in the working directory are
+// read into the environment first.
+func Parse(args []string) (Config, []string, error) {
+	// .env holds the defaults of the project, .env.local those of the developer
+	_ = godotenv.Load(".env")
+	_ = godotenv.Overload(".env.local")
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML file with the configuration")
+	flags := map[string]string{}
+	cfg := Default()
+	for _, s := range cfg.settings() {
+		fs.Func(s.flag(), s.usage, func(v string) error {
+			flags[s.env] = v
+			return nil
+		})
 	}
-
-	for {
-		goModPath := filepath.Join(currentDir, "go.mod")
-		if _, err := os.Stat(goModPath); err == nil {
-			break
-		}
-
-		parent := filepath.Dir(currentDir)
-		if parent == currentDir {
-			panic(fmt.Errorf("go.mod not found"))
-		}
-		currentDir = parent
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
 	}
 
-	return currentDir
+	cfg, err := load(Default(), *file, flags)
+	// The cookie defaults depend on the environment, which every layer can set
+	if cfg.IsProduction() {
+		production := Default()
+		production.Cookie = defaultCookieConfig(true)
+		cfg, err = load(production, *file, flags)
+	}
+	cfg.Cookie.SameSite = normalizeSameSite(cfg.Cookie.SameSite)
+	cfg.Tracing.Exporter = strings.ToLower(cfg.Tracing.Exporter)
+	return cfg, fs.Args(), err
 }
 
-func IsProduction() bool {
-	env := os.Getenv("ENV")
-	if env == "" {
-		log.Fatal("no environment set in .env.local file ENV=dev or ENV=prod")
+// load puts the file, the environment and the flags over cfg.
+func load(cfg Config, file string, flags map[string]string) (Config, error) {
+	var errs []error
+	if file != "" {
+		errs = append(errs, loadFile(file, &cfg))
 	}
-	env = strings.ToLower(env)
-	isProduction := true
-	if env == "dev" {
-		isProduction = false
+	for _, s := range cfg.settings() {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q: %w", s.env, v, err))
+			}
+		}
 	}
-
-	return isProduction
+	for _, s := range cfg.settings() {
+		if v, ok := flags[s.env]; ok {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid --%s %q: %w", s.flag(), v, err))
+			}
+		}
+	}
+	return cfg, errors.Join(errs...)
 }
@@ -1,26 +1,344 @@
 package config_test
 
 import (
+	"log/slog"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/amavis442/til-backend/internal/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestGenerateAndVerifyTokens(t *testing.T) {
-	config.Load()
+// loadIn runs config.Load in an empty directory, so no .env is read.
+func loadIn(t *testing.T, files map[string]string, args ...string) (config.Config, error) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, _, err := config.Load(args)
+	return cfg, err
+}
+
+// setRequired sets the settings that have no default.
+func setRequired(t *testing.T) {
+	t.Setenv("ENV", "dev")
+	t.Setenv("PORT", "3031")
+	t.Setenv("DB_DSN", "sqlite://til.db")
+	t.Setenv("CORS_ALLOWED_ORIGIN", "http://localhost:5173")
+	t.Setenv("JWT_PRIVATE_KEY_PATH", "private.pem")
+	t.Setenv("JWT_PUBLIC_KEY_PATH", "public.pem")
+}
+
+func TestLoad_Layers(t *testing.T) {
+	setRequired(t)
+	t.Setenv("PORT", "")
+	t.Setenv("CORS_ALLOWED_ORIGIN", "")
+	t.Setenv("DB_QUERY_TIMEOUT", "3s")
+
+	cfg, err := loadIn(t, map[string]string{"til.yaml": `
+port: 8000
+cors_allowed_origins: [https://til.example.com, https://admin.example.com]
+database:
+  query_timeout: 1s
+rate_limit:
+  auth: 20/1m
+  export: 0
+`}, "--config", "til.yaml", "--port", "9000", "--log-level", "debug")
+	require.NoError(t, err)
+
+	assert.Equal(t, 9000, cfg.Port, "flags win over the file")
+	assert.Equal(t, 3*time.Second, cfg.Database.QueryTimeout, "the environment wins over the file")
+	assert.Equal(t, []string{"https://til.example.com", "https://admin.example.com"}, cfg.CORSAllowedOrigins)
+	assert.Equal(t, config.RateLimitRule{Limit: 20, Window: time.Minute}, cfg.RateLimit.Auth)
+	assert.False(t, cfg.RateLimit.Export.Enabled())
+	assert.Equal(t, slog.LevelDebug, cfg.LogLevel)
+	assert.Equal(t, 15*time.Minute, cfg.JWT.AccessTokenTTL, "defaults stay")
+}
+
+func TestLoad_TOMLFromEnvironment(t *testing.T) {
+	setRequired(t)
+	t.Setenv("CONFIG_FILE", "til.toml")
+
+	cfg, err := loadIn(t, map[string]string{"til.toml": `
+[server]
+shutdown_timeout = "30s"
+
+[oidc]
+scopes = ["openid", "email"]
+`})
+	require.NoError(t, err)
+
+	assert.Equal(t, 30*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, []string{"openid", "email"}, cfg.OIDC.Scopes)
+}
 
-	assert.NotEmpty(t, os.Getenv("JWT_PRIVATE_KEY_PATH"))
-	assert.NotEmpty(t, os.Getenv("JWT_PUBLIC_KEY_PATH"))
+func TestLoad_UnknownFileSetting(t *testing.T) {
+	setRequired(t)
 
-	/*
-		assert.NoError(t, err)
+	_, err := loadIn(t, map[string]string{"til.yaml": "databse:\n  dsn: x\n"}, "--config", "til.yaml")
+	assert.ErrorContains(t, err, "databse")
+}
+
+func TestLoad_ReportsAllProblems(t *testing.T) {
+	setRequired(t)
+	t.Setenv("DB_DSN", "")
+	t.Setenv("MAX_BODY_SIZE", "1MB")
+	t.Setenv("RATE_LIMIT_API", "lots")
+
+	_, err := loadIn(t, nil, "--account-til-policy", "keep")
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid MAX_BODY_SIZE")
+	assert.ErrorContains(t, err, "invalid RATE_LIMIT_API")
+	assert.ErrorContains(t, err, "DB_DSN must be set")
+	assert.ErrorContains(t, err, "ACCOUNT_TIL_POLICY must be delete or reassign")
+}
+
+func TestLoad_ProductionCookieDefaults(t *testing.T) {
+	setRequired(t)
+	t.Setenv("ENV", "prod")
+
+	cfg, err := loadIn(t, nil)
+	require.NoError(t, err)
+	assert.True(t, cfg.IsProduction())
+	assert.True(t, cfg.Cookie.Secure)
+	assert.Equal(t, "None", cfg.Cookie.SameSite)
+
+	cfg, err = loadIn(t, nil, "--env", "dev", "--cookie-samesite", "strict")
+	require.NoError(t, err)
+	assert.False(t, cfg.Cookie.Secure)
+	assert.Equal(t, "Strict", cfg.Cookie.SameSite)
+}
+
+func TestLoad_Subcommand(t *testing.T) {
+	setRequired(t)
+	t.Chdir(t.TempDir())
+
+	_, args, err := config.Load([]string{"--port", "8080", "migrate", "up"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := config.Default()
+	valid.Port = 3031
+	valid.CORSAllowedOrigins = []string{"http://localhost:5173"}
+	valid.Database.DSN = "sqlite://til.db"
+	valid.JWT.PrivateKeyPath = "private.pem"
+	valid.JWT.PublicKeyPath = "public.pem"
+
+	tests := []struct {
+		name    string
+		modify  func(c *config.Config)
+		wantErr string
+	}{
+		{"valid defaults", func(c *config.Config) {}, ""},
+		{"no port", func(c *config.Config) { c.Port = 0 }, "PORT must be between 1 and 65535"},
+		{"no origins", func(c *config.Config) { c.CORSAllowedOrigins = nil }, "CORS_ALLOWED_ORIGIN must be set"},
+		{"any origin", func(c *config.Config) { c.CORSAllowedOrigins = []string{"*"} }, "must not be *"},
+		{"no ready timeout", func(c *config.Config) { c.ReadyTimeout = 0 }, "READY_TIMEOUT must be positive"},
+		{"negative query timeout", func(c *config.Config) { c.Database.QueryTimeout = -time.Second }, "DB_QUERY_TIMEOUT must not be negative"},
+		{"no private key", func(c *config.Config) { c.JWT.PrivateKeyPath = "" }, "JWT_PRIVATE_KEY_PATH must be set"},
+		{"refresh shorter than access", func(c *config.Config) { c.JWT.RefreshTokenTTL = time.Minute }, "JWT_REFRESH_TOKEN_TTL must be longer"},
+		{"invalid schedule", func(c *config.Config) { c.Scheduler.TrashPurge = "daily" }, `SCHEDULE_TRASH_PURGE "daily" is not a schedule`},
+		{"job off", func(c *config.Config) { c.Scheduler.WeeklyDigest = config.ScheduleOff }, ""},
+		{"smtp without sender", func(c *config.Config) { c.Mail.SMTPAddr = "mail:25" }, "MAIL_FROM must be set"},
+		{"relative verify URL", func(c *config.Config) { c.Mail.VerifyURL = "/verify" }, "MAIL_VERIFY_URL must be an http or https URL"},
+		{"no attempts", func(c *config.Config) { c.Queue.MaxAttempts = 0 }, "QUEUE_MAX_ATTEMPTS must be at least 1"},
+		{"no workers", func(c *config.Config) { c.Queue.Workers = 0 }, ""},
+		{"trusted proxies", func(c *config.Config) { c.Server.TrustedProxies = []string{"10.0.0.1", "172.16.0.0/12", "::1"} }, ""},
+		{"invalid proxy", func(c *config.Config) { c.Server.TrustedProxies = []string{"proxy.local"} }, `TRUSTED_PROXIES "proxy.local" is not an IP address`},
+		{"proxies without header", func(c *config.Config) {
+			c.Server.TrustedProxies = []string{"10.0.0.1"}
+			c.Server.ProxyHeader = ""
+		}, "PROXY_HEADER must be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"host=db user=til password=secret dbname=til", "host=db user=til password=[REDACTED] dbname=til"},
+		{"postgres://til:secret@db:5432/til", "postgres://til:xxxxx@db:5432/til"},
+		{"sqlite://til.db", "sqlite://til.db"},
+	}
+	for _, tt := range tests {
+		cfg := config.Default()
+		cfg.Database.DSN = tt.dsn
+		assert.Equal(t, tt.want, cfg.Redacted().Database.DSN)
+	}
+
+	cfg := config.Default()
+	cfg.OIDC.ClientSecret = "oidc-secret"
+	cfg.Metrics.Token = "metrics-token"
+	cfg.Mail.SMTPPassword = "smtp-password"
+	redacted := cfg.Redacted()
+	assert.Equal(t, "[REDACTED]", redacted.OIDC.ClientSecret)
+	assert.Equal(t, "[REDACTED]", redacted.Metrics.Token)
+	assert.Equal(t, "[REDACTED]", redacted.Mail.SMTPPassword)
+	assert.Equal(t, "oidc-secret", cfg.OIDC.ClientSecret, "the original keeps its secrets")
+}
+
+func TestCookieConfigValidate(t *testing.T) {
+	valid := config.CookieConfig{Name: "access_token", Path: "/", SameSite: "Lax"}
+
+	tests := []struct {
+		name    string
+		modify  func(c *config.CookieConfig)
+		wantErr string
+	}{
+		{"valid defaults", func(c *config.CookieConfig) {}, ""},
+		{"empty name", func(c *config.CookieConfig) { c.Name = "" }, "COOKIE_NAME must not be empty"},
+		{"unknown samesite", func(c *config.CookieConfig) { c.SameSite = "Sometimes" }, "COOKIE_SAMESITE must be one of"},
+		{"samesite none without secure", func(c *config.CookieConfig) { c.SameSite = "None" }, "requires COOKIE_SECURE=true"},
+		{"samesite none with secure", func(c *config.CookieConfig) { c.SameSite = "None"; c.Secure = true }, ""},
+		{"host prefix without secure", func(c *config.CookieConfig) { c.HostPrefix = true }, "COOKIE_HOST_PREFIX requires COOKIE_SECURE=true"},
+		{"host prefix with domain", func(c *config.CookieConfig) {
+			c.HostPrefix = true
+			c.Secure = true
+			c.Domain = "example.com"
+		}, "COOKIE_DOMAIN to be empty"},
+		{"host prefix with path", func(c *config.CookieConfig) {
+			c.HostPrefix = true
+			c.Secure = true
+			c.Path = "/api"
+		}, "COOKIE_PATH=/"},
+		{"relative path", func(c *config.CookieConfig) { c.Path = "api" }, "must start with '/'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	valid := config.ServerConfig{
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		BodyLimit:       1024,
+		Concurrency:     10,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *config.ServerConfig)
+		wantErr string
+	}{
+		{"valid defaults", func(c *config.ServerConfig) {}, ""},
+		{"no timeouts", func(c *config.ServerConfig) { c.ReadTimeout, c.WriteTimeout = 0, 0 }, ""},
+		{"negative read timeout", func(c *config.ServerConfig) { c.ReadTimeout = -time.Second }, "SERVER_READ_TIMEOUT must not be negative"},
+		{"no shutdown timeout", func(c *config.ServerConfig) { c.ShutdownTimeout = 0 }, "SHUTDOWN_TIMEOUT must be positive"},
+		{"no body limit", func(c *config.ServerConfig) { c.BodyLimit = 0 }, "MAX_BODY_SIZE must be a positive number of bytes"},
+		{"no concurrency", func(c *config.ServerConfig) { c.Concurrency = 0 }, "MAX_CONCURRENCY must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTracingConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.TracingConfig
+		wantErr string
+	}{
+		{"none", config.TracingConfig{Exporter: config.TracingNone, SampleRatio: 1}, ""},
+		{"otlp sampled", config.TracingConfig{Exporter: config.TracingOTLP, SampleRatio: 0.1}, ""},
+		{"unknown exporter", config.TracingConfig{Exporter: "jaeger", SampleRatio: 1}, "TRACING_EXPORTER must be none, stdout or otlp"},
+		{"ratio above one", config.TracingConfig{Exporter: config.TracingStdout, SampleRatio: 2}, "TRACING_SAMPLE_RATIO must be between 0 and 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	valid := config.RateLimitConfig{
+		Store:  config.RateLimitStoreMemory,
+		Auth:   config.RateLimitRule{Limit: 10, Window: time.Minute},
+		API:    config.RateLimitRule{Limit: 300, Window: time.Minute},
+		Search: config.RateLimitRule{Limit: 30, Window: time.Minute},
+		Export: config.RateLimitRule{Limit: 5, Window: time.Hour},
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *config.RateLimitConfig)
+		wantErr string
+	}{
+		{"valid defaults", func(c *config.RateLimitConfig) {}, ""},
+		{"database store", func(c *config.RateLimitConfig) { c.Store = config.RateLimitStoreDatabase }, ""},
+		{"rule turned off", func(c *config.RateLimitConfig) { c.Search = config.RateLimitRule{} }, ""},
+		{"unknown store", func(c *config.RateLimitConfig) { c.Store = "redis" }, "RATE_LIMIT_STORE must be memory or database"},
+		{"negative limit", func(c *config.RateLimitConfig) { c.API.Limit = -1 }, "RATE_LIMIT_API must not be negative"},
+		{"no window", func(c *config.RateLimitConfig) { c.Auth.Window = 0 }, "RATE_LIMIT_AUTH needs a positive window"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
 
-		id, err := auth.ExtractUserIDFromClaims(claims)
-		assert.NoError(t, err)
-		assert.Equal(t, userID, id)
+func TestCookieConfigFullName(t *testing.T) {
+	cfg := config.CookieConfig{Name: "access_token"}
+	assert.Equal(t, "access_token", cfg.FullName())
 
-		assert.Equal(t, "refresh", claims["typ"])
-	*/
+	cfg.HostPrefix = true
+	assert.Equal(t, "__Host-access_token", cfg.FullName())
 }
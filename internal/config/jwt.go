@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// JWTConfig holds the keys and lifetimes of the access and refresh tokens.
+type JWTConfig struct {
+	PrivateKeyPath  string        `yaml:"private_key_path" toml:"private_key_path"`   // PEM file with the RSA key that signs the tokens
+	PublicKeyPath   string        `yaml:"public_key_path" toml:"public_key_path"`     // PEM file with the RSA key that verifies the tokens
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`   // Lifetime of access tokens and their cookie
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"` // Lifetime of refresh tokens
+}
+
+func (c JWTConfig) Validate() error {
+	var errs []error
+	if c.PrivateKeyPath == "" {
+		errs = append(errs, errors.New("JWT_PRIVATE_KEY_PATH must be set"))
+	}
+	if c.PublicKeyPath == "" {
+		errs = append(errs, errors.New("JWT_PUBLIC_KEY_PATH must be set"))
+	}
+	if c.AccessTokenTTL <= 0 {
+		errs = append(errs, errors.New("JWT_ACCESS_TOKEN_TTL must be positive"))
+	}
+	if c.RefreshTokenTTL <= c.AccessTokenTTL {
+		errs = append(errs, errors.New("JWT_REFRESH_TOKEN_TTL must be longer than JWT_ACCESS_TOKEN_TTL"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultJWTConfig() JWTConfig {
+	return JWTConfig{
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+	}
+}
+
+func (c *JWTConfig) settings() []setting {
+	return []setting{
+		{"JWT_PRIVATE_KEY_PATH", "PEM file with the RSA key that signs the tokens", &c.PrivateKeyPath},
+		{"JWT_PUBLIC_KEY_PATH", "PEM file with the RSA key that verifies the tokens", &c.PublicKeyPath},
+		{"JWT_ACCESS_TOKEN_TTL", "lifetime of access tokens", &c.AccessTokenTTL},
+		{"JWT_REFRESH_TOKEN_TTL", "lifetime of refresh tokens", &c.RefreshTokenTTL},
+	}
+}
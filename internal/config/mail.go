@@ -0,0 +1,42 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+)
+
+// MailConfig holds how email is sent. Without an SMTP server the messages are
+// only logged, which is enough for development.
+type MailConfig struct {
+	SMTPAddr     string `yaml:"smtp_addr" toml:"smtp_addr"`         // host:port of the SMTP server; empty logs the messages
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username"` // Empty for a server without authentication
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password"`
+	From         string `yaml:"from" toml:"from"`             // Sender address of every message
+	VerifyURL    string `yaml:"verify_url" toml:"verify_url"` // Page of the frontend that verifies an email address with ?token=
+}
+
+func (c MailConfig) Validate() error {
+	var errs []error
+
+	if c.SMTPAddr != "" && c.From == "" {
+		errs = append(errs, errors.New("MAIL_FROM must be set when MAIL_SMTP_ADDR is"))
+	}
+	if c.VerifyURL != "" {
+		u, err := url.Parse(c.VerifyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("MAIL_VERIFY_URL must be an http or https URL"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *MailConfig) settings() []setting {
+	return []setting{
+		{"MAIL_SMTP_ADDR", "host:port of the SMTP server, empty to only log the messages", &c.SMTPAddr},
+		{"MAIL_SMTP_USERNAME", "username of the SMTP server, empty for none", &c.SMTPUsername},
+		{"MAIL_SMTP_PASSWORD", "password of the SMTP server", &c.SMTPPassword},
+		{"MAIL_FROM", "sender address of the email", &c.From},
+		{"MAIL_VERIFY_URL", "page of the frontend that verifies an email address, the token is added as ?token=", &c.VerifyURL},
+	}
+}
@@ -0,0 +1,14 @@
+package config
+
+// MetricsConfig holds where the Prometheus metrics are served.
+type MetricsConfig struct {
+	Addr  string `yaml:"addr" toml:"addr"`   // Separate listen address like :9090; empty serves /metrics on the API port
+	Token string `yaml:"token" toml:"token"` // Bearer token /metrics requires; empty for none
+}
+
+func (c *MetricsConfig) settings() []setting {
+	return []setting{
+		{"METRICS_ADDR", "separate listen address for /metrics like :9090, empty for the API port", &c.Addr},
+		{"METRICS_TOKEN", "bearer token /metrics requires, empty for none", &c.Token},
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import "errors"
+
+// OIDCConfig holds the settings for logging in through an external OpenID Connect provider.
+// OIDC login is disabled when IssuerURL is empty.
+type OIDCConfig struct {
+	IssuerURL    string   `yaml:"issuer_url" toml:"issuer_url"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"` // Must point to /auth/oidc/callback
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Enabled reports whether OIDC login is configured.
+func (c OIDCConfig) Enabled() bool {
+	return c.IssuerURL != ""
+}
+
+// Validate checks that all settings needed for the authorization-code flow are present.
+func (c OIDCConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	var errs []error
+	if c.ClientID == "" {
+		errs = append(errs, errors.New("OIDC_CLIENT_ID must be set when OIDC_ISSUER_URL is set"))
+	}
+	if c.RedirectURL == "" {
+		errs = append(errs, errors.New("OIDC_REDIRECT_URL must be set when OIDC_ISSUER_URL is set"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{Scopes: []string{"openid", "email", "profile"}}
+}
+
+func (c *OIDCConfig) settings() []setting {
+	return []setting{
+		{"OIDC_ISSUER_URL", "OpenID Connect provider, empty to turn OIDC login off", &c.IssuerURL},
+		{"OIDC_CLIENT_ID", "client ID registered at the provider", &c.ClientID},
+		{"OIDC_CLIENT_SECRET", "client secret registered at the provider", &c.ClientSecret},
+		{"OIDC_REDIRECT_URL", "URL of /auth/oidc/callback as the provider redirects to it", &c.RedirectURL},
+		{"OIDC_SCOPES", "scopes to request, comma separated", &c.Scopes},
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// QueueConfig holds how the workers run the jobs in the queue.
+type QueueConfig struct {
+	Workers      int           `yaml:"workers" toml:"workers"`             // Jobs this replica runs at the same time, 0 runs none
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"` // How long an idle worker waits before it looks for jobs again
+	MaxAttempts  int           `yaml:"max_attempts" toml:"max_attempts"`   // Attempts before a job is dead-lettered
+	Lease        time.Duration `yaml:"lease" toml:"lease"`                 // How long a job may run before another worker takes it over
+	Retention    time.Duration `yaml:"retention" toml:"retention"`         // How long finished jobs are kept
+}
+
+func (c QueueConfig) Validate() error {
+	var errs []error
+
+	if c.Workers < 0 {
+		errs = append(errs, errors.New("QUEUE_WORKERS must not be negative"))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, errors.New("QUEUE_POLL_INTERVAL must be positive"))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, errors.New("QUEUE_MAX_ATTEMPTS must be at least 1"))
+	}
+	if c.Lease <= 0 {
+		errs = append(errs, errors.New("QUEUE_LEASE must be positive"))
+	}
+	if c.Retention <= 0 {
+		errs = append(errs, errors.New("QUEUE_RETENTION must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func defaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Workers:      4,
+		PollInterval: time.Second,
+		MaxAttempts:  10,
+		Lease:        5 * time.Minute,
+		Retention:    7 * 24 * time.Hour,
+	}
+}
+
+func (c *QueueConfig) settings() []setting {
+	return []setting{
+		{"QUEUE_WORKERS", "jobs this replica runs at the same time, 0 runs none", &c.Workers},
+		{"QUEUE_POLL_INTERVAL", "how long an idle worker waits before it looks for jobs again", &c.PollInterval},
+		{"QUEUE_MAX_ATTEMPTS", "attempts before a job is dead-lettered", &c.MaxAttempts},
+		{"QUEUE_LEASE", "how long a job may run before another worker takes it over", &c.Lease},
+		{"QUEUE_RETENTION", "how long finished jobs are kept", &c.Retention},
+	}
+}
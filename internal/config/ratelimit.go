@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RateLimitStoreMemory   = "memory"   // Every replica counts on its own
+	RateLimitStoreDatabase = "database" // The replicas share the counts in the database
+)
+
+// RateLimitRule allows Limit requests per Window. A Limit of 0 turns it off.
+// It is written as requests/window like 10/1m, or 0.
+type RateLimitRule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Enabled reports whether the rule limits anything.
+func (r RateLimitRule) Enabled() bool {
+	return r.Limit > 0
+}
+
+// RateLimitConfig holds the rate limits of the API.
+type RateLimitConfig struct {
+	Store  string        `yaml:"store" toml:"store"`   // memory or database
+	Auth   RateLimitRule `yaml:"auth" toml:"auth"`     // Per IP address on /auth
+	API    RateLimitRule `yaml:"api" toml:"api"`       // Per user on /api
+	Search RateLimitRule `yaml:"search" toml:"search"` // Per user on /api/tils/search, on top of API
+	Export RateLimitRule `yaml:"export" toml:"export"` // Per user on /api/me/export, on top of API
+}
+
+func (c RateLimitConfig) Validate() error {
+	var errs []error
+
+	if c.Store != RateLimitStoreMemory && c.Store != RateLimitStoreDatabase {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_STORE must be memory or database, got %q", c.Store))
+	}
+	rules := []struct {
+		env  string
+		rule RateLimitRule
+	}{
+		{"RATE_LIMIT_AUTH", c.Auth},
+		{"RATE_LIMIT_API", c.API},
+		{"RATE_LIMIT_SEARCH", c.Search},
+		{"RATE_LIMIT_EXPORT", c.Export},
+	}
+	for _, r := range rules {
+		if r.rule.Limit < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", r.env))
+		}
+		if r.rule.Enabled() && r.rule.Window <= 0 {
+			errs = append(errs, fmt.Errorf("%s needs a positive window", r.env))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// UnmarshalText parses a rule like 10/1m, or 0 for none.
+func (r *RateLimitRule) UnmarshalText(text []byte) error {
+	s := string(text)
+	if s == "0" {
+		*r = RateLimitRule{}
+		return nil
+	}
+	limit, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return errors.New("must be requests/window like 10/1m, or 0 for none")
+	}
+
+	var rule RateLimitRule
+	var err error
+	if rule.Limit, err = strconv.Atoi(limit); err != nil {
+		return fmt.Errorf("invalid number of requests: %w", err)
+	}
+	if rule.Window, err = time.ParseDuration(window); err != nil {
+		return fmt.Errorf("invalid window: %w", err)
+	}
+	*r = rule
+	return nil
+}
+
+// MarshalText writes the rule the way UnmarshalText reads it.
+func (r RateLimitRule) MarshalText() ([]byte, error) {
+	if !r.Enabled() {
+		return []byte("0"), nil
+	}
+	return []byte(strconv.Itoa(r.Limit) + "/" + r.Window.String()), nil
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Store:  RateLimitStoreMemory,
+		Auth:   RateLimitRule{Limit: 10, Window: time.Minute},
+		API:    RateLimitRule{Limit: 300, Window: time.Minute},
+		Search: RateLimitRule{Limit: 30, Window: time.Minute},
+		Export: RateLimitRule{Limit: 5, Window: time.Hour},
+	}
+}
+
+func (c *RateLimitConfig) settings() []setting {
+	return []setting{
+		{"RATE_LIMIT_STORE", "where requests are counted: memory or database", &c.Store},
+		{"RATE_LIMIT_AUTH", "requests/window per IP address on /auth, 0 for none", &c.Auth},
+		{"RATE_LIMIT_API", "requests/window per user on /api, 0 for none", &c.API},
+		{"RATE_LIMIT_SEARCH", "requests/window per user on /api/tils/search, 0 for none", &c.Search},
+		{"RATE_LIMIT_EXPORT", "requests/window per user on /api/me/export, 0 for none", &c.Export},
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"net/url"
+	"regexp"
+)
+
+const redacted = "[REDACTED]"
+
+// dsnPassword matches the password of a key=value DSN.
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// Redacted returns a copy of the configuration without its secrets, so it can
+// be shown or logged.
+func (c Config) Redacted() Config {
+	c.Database.DSN = redactDSN(c.Database.DSN)
+	if c.OIDC.ClientSecret != "" {
+		c.OIDC.ClientSecret = redacted
+	}
+	if c.Metrics.Token != "" {
+		c.Metrics.Token = redacted
+	}
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = redacted
+	}
+	return c
+}
+
+// redactDSN hides the password of a URL or a key=value DSN.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/cron"
+)
+
+// ScheduleOff turns a background job off.
+const ScheduleOff = "off"
+
+// SchedulerConfig holds when the background jobs run. The schedules are in
+// UTC, see the cron package for their syntax.
+type SchedulerConfig struct {
+	Enabled          bool          `yaml:"enabled" toml:"enabled"`                     // Run the background jobs in this replica at all
+	Jitter           time.Duration `yaml:"jitter" toml:"jitter"`                       // Longest random delay before a job starts
+	HistoryRetention time.Duration `yaml:"history_retention" toml:"history_retention"` // How long the runs of a job are kept
+	TokenCleanup     string        `yaml:"token_cleanup" toml:"token_cleanup"`         // Schedule of the purge of expired and revoked refresh tokens
+	TrashPurge       string        `yaml:"trash_purge" toml:"trash_purge"`             // Schedule of the purge of deleted TILs and accounts
+	TrashRetention   time.Duration `yaml:"trash_retention" toml:"trash_retention"`     // How long deleted TILs and accounts are kept
+	WeeklyDigest     string        `yaml:"weekly_digest" toml:"weekly_digest"`         // Schedule of the weekly digest email
+	QueueCleanup     string        `yaml:"queue_cleanup" toml:"queue_cleanup"`         // Schedule of the purge of finished queued jobs
+}
+
+func (c SchedulerConfig) Validate() error {
+	var errs []error
+
+	if c.Jitter < 0 {
+		errs = append(errs, errors.New("SCHEDULER_JITTER must not be negative"))
+	}
+	if c.HistoryRetention <= 0 {
+		errs = append(errs, errors.New("SCHEDULER_HISTORY_RETENTION must be positive"))
+	}
+	if c.TrashRetention <= 0 {
+		errs = append(errs, errors.New("TRASH_RETENTION must be positive"))
+	}
+	schedules := []struct {
+		env  string
+		spec string
+	}{
+		{"SCHEDULE_TOKEN_CLEANUP", c.TokenCleanup},
+		{"SCHEDULE_TRASH_PURGE", c.TrashPurge},
+		{"SCHEDULE_WEEKLY_DIGEST", c.WeeklyDigest},
+		{"SCHEDULE_QUEUE_CLEANUP", c.QueueCleanup},
+	}
+	for _, s := range schedules {
+		if s.spec == ScheduleOff {
+			continue
+		}
+		if _, err := cron.Parse(s.spec); err != nil {
+			errs = append(errs, fmt.Errorf("%s %q is not a schedule: %w", s.env, s.spec, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func defaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		Enabled:          true,
+		Jitter:           30 * time.Second,
+		HistoryRetention: 30 * 24 * time.Hour,
+		TokenCleanup:     "@hourly",
+		TrashPurge:       "30 3 * * *",
+		TrashRetention:   30 * 24 * time.Hour,
+		WeeklyDigest:     "0 8 * * 1",
+		QueueCleanup:     "45 * * * *",
+	}
+}
+
+func (c *SchedulerConfig) settings() []setting {
+	return []setting{
+		{"SCHEDULER_ENABLED", "run the background jobs in this replica", &c.Enabled},
+		{"SCHEDULER_JITTER", "longest random delay before a job starts", &c.Jitter},
+		{"SCHEDULER_HISTORY_RETENTION", "how long the runs of a job are kept", &c.HistoryRetention},
+		{"SCHEDULE_TOKEN_CLEANUP", "when expired and revoked refresh tokens are purged, or off", &c.TokenCleanup},
+		{"SCHEDULE_TRASH_PURGE", "when deleted TILs and accounts are purged, or off", &c.TrashPurge},
+		{"TRASH_RETENTION", "how long deleted TILs and accounts are kept", &c.TrashRetention},
+		{"SCHEDULE_WEEKLY_DIGEST", "when the weekly digest email is sent, or off", &c.WeeklyDigest},
+		{"SCHEDULE_QUEUE_CLEANUP", "when finished queued jobs are purged, or off", &c.QueueCleanup},
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ServerConfig holds the limits of the HTTP server.
+type ServerConfig struct {
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout"`         // Deadline to read a request, 0 for none
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout"`       // Deadline to write a response, 0 for none
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`         // How long a keep-alive connection may wait for the next request, 0 for ReadTimeout
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"` // How long running requests may take after SIGINT or SIGTERM
+	BodyLimit       int           `yaml:"body_limit" toml:"body_limit"`             // Largest request body in bytes
+	Concurrency     int           `yaml:"concurrency" toml:"concurrency"`           // Largest number of open connections
+	TrustedProxies  []string      `yaml:"trusted_proxies" toml:"trusted_proxies"`   // Addresses or CIDR ranges of the proxies whose ProxyHeader is believed
+	ProxyHeader     string        `yaml:"proxy_header" toml:"proxy_header"`         // Header in which a trusted proxy passes the address of the client
+}
+
+// ClientIPHeader returns the header that holds the address of the client, or
+// "" when no proxy is trusted and the address of the connection counts.
+func (c ServerConfig) ClientIPHeader() string {
+	if len(c.TrustedProxies) == 0 {
+		return ""
+	}
+	return c.ProxyHeader
+}
+
+func (c ServerConfig) Validate() error {
+	var errs []error
+
+	if c.ReadTimeout < 0 {
+		errs = append(errs, errors.New("SERVER_READ_TIMEOUT must not be negative"))
+	}
+	if c.WriteTimeout < 0 {
+		errs = append(errs, errors.New("SERVER_WRITE_TIMEOUT must not be negative"))
+	}
+	if c.IdleTimeout < 0 {
+		errs = append(errs, errors.New("SERVER_IDLE_TIMEOUT must not be negative"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if c.BodyLimit <= 0 {
+		errs = append(errs, errors.New("MAX_BODY_SIZE must be a positive number of bytes"))
+	}
+	if c.Concurrency <= 0 {
+		errs = append(errs, errors.New("MAX_CONCURRENCY must be positive"))
+	}
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES %q is not an IP address or CIDR range", proxy))
+		}
+	}
+	if len(c.TrustedProxies) > 0 && strings.TrimSpace(c.ProxyHeader) == "" {
+		errs = append(errs, errors.New("PROXY_HEADER must be set when TRUSTED_PROXIES is"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     2 * time.Minute,
+		ShutdownTimeout: 15 * time.Second,
+		BodyLimit:       1024 * 1024,
+		Concurrency:     256 * 1024,
+		ProxyHeader:     "X-Forwarded-For",
+	}
+}
+
+func (c *ServerConfig) settings() []setting {
+	return []setting{
+		{"SERVER_READ_TIMEOUT", "deadline to read a request, 0 for none", &c.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", "deadline to write a response, 0 for none", &c.WriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", "how long a keep-alive connection may wait for the next request", &c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "how long running requests may take after SIGINT or SIGTERM", &c.ShutdownTimeout},
+		{"MAX_BODY_SIZE", "largest request body in bytes", &c.BodyLimit},
+		{"MAX_CONCURRENCY", "largest number of open connections", &c.Concurrency},
+		{"TRUSTED_PROXIES", "addresses or CIDR ranges of the proxies in front of the server, comma separated", &c.TrustedProxies},
+		{"PROXY_HEADER", "header in which a trusted proxy passes the address of the client", &c.ProxyHeader},
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting ties a configuration value to its environment variable. Its flag is
+// the variable in lower case with dashes, so DB_DSN is set with --db-dsn.
+type setting struct {
+	env   string
+	usage string
+	value any // Pointer into the Config
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// set parses v into the value s points to, which keeps its value when v is
+// invalid.
+func (s setting) set(v string) error {
+	switch p := s.value.(type) {
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText([]byte(v))
+	case *string:
+		*p = v
+	case *bool:
+		return parse(p, v, strconv.ParseBool)
+	case *int:
+		return parse(p, v, strconv.Atoi)
+	case *float64:
+		return parse(p, v, func(v string) (float64, error) { return strconv.ParseFloat(v, 64) })
+	case *time.Duration:
+		return parse(p, v, time.ParseDuration)
+	case *[]string:
+		*p = splitList(v)
+	default:
+		panic(fmt.Sprintf("config: %s has unsupported type %T", s.env, s.value))
+	}
+	return nil
+}
+
+func parse[T any](p *T, v string, parse func(string) (T, error)) error {
+	parsed, err := parse(v)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// splitList splits a list separated by commas, spaces or both.
+func splitList(v string) []string {
+	return strings.Fields(strings.ReplaceAll(v, ",", " "))
+}
@@ -0,0 +1,39 @@
+package config
+
+import "fmt"
+
+const (
+	TracingNone   = "none"   // Spans are not recorded
+	TracingStdout = "stdout" // Spans are written to stdout as JSON
+	TracingOTLP   = "otlp"   // Spans are sent over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT
+)
+
+// TracingConfig holds where the OpenTelemetry spans go. The OTLP exporter and
+// the service name are further set with the standard OTEL_* variables.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`         // none, stdout or otlp
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"` // Part of the traces that is recorded, from 0 to 1
+}
+
+func (c TracingConfig) Validate() error {
+	switch c.Exporter {
+	case TracingNone, TracingStdout, TracingOTLP:
+	default:
+		return fmt.Errorf("TRACING_EXPORTER must be none, stdout or otlp, got %q", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.SampleRatio)
+	}
+	return nil
+}
+
+func defaultTracingConfig() TracingConfig {
+	return TracingConfig{Exporter: TracingNone, SampleRatio: 1}
+}
+
+func (c *TracingConfig) settings() []setting {
+	return []setting{
+		{"TRACING_EXPORTER", "where spans go: none, stdout or otlp", &c.Exporter},
+		{"TRACING_SAMPLE_RATIO", "part of the traces that is recorded, from 0 to 1", &c.SampleRatio},
+	}
+}
@@ -0,0 +1,64 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_Next(t *testing.T) {
+	// Wednesday
+	from := time.Date(2026, 3, 11, 10, 15, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 11, 10, 16, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2026, 3, 12, 3, 30, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2026, 3, 11, 10, 20, 0, 0, time.UTC)},
+		{"5,45 10-11 * * *", time.Date(2026, 3, 11, 10, 45, 0, 0, time.UTC)},
+		{"0 8 * * 1", time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2026, 3, 15, 8, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC)}, // Friday or the 13th
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 11, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2026, 3, 11, 11, 0, 0, 0, time.UTC)},
+		{"@every 10m", time.Date(2026, 3, 11, 10, 20, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.Next(from))
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"":               "need 5 fields",
+		"* * * *":        "need 5 fields",
+		"60 * * * *":     "minute",
+		"* 24 * * *":     "hour",
+		"* * 0 * *":      "day of month",
+		"* * * 13 *":     "month",
+		"* * * * 8":      "day of week",
+		"*/0 * * * *":    "invalid step",
+		"5-1 * * * *":    "not within",
+		"a * * * *":      "invalid value",
+		"0 0 30 2 *":     "never runs",
+		"@every soon":    "invalid interval",
+		"@every -1m":     "must be positive",
+		"@fortnightly":   "need 5 fields",
+		"* * * * * * * ": "need 5 fields",
+	}
+	for spec, want := range tests {
+		_, err := Parse(spec)
+		assert.ErrorContains(t, err, want, spec)
+	}
+}
@@ -0,0 +1,122 @@
+// Package database opens the database that DB_DSN points to. The driver is
+// chosen from the scheme of the DSN: sqlite://path/to/file.db opens a SQLite
+// database, anything else is handed to the Postgres driver.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the pgx database/sql driver
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	Postgres = "postgres" // Postgres is the dialect for postgres:// and key=value DSNs.
+	SQLite   = "sqlite"   // SQLite is the dialect for sqlite:// DSNs.
+)
+
+const sqliteScheme = "sqlite://"
+
+// sqlitePragmas are set on every SQLite connection. Foreign keys are off by
+// default in SQLite, and the busy timeout makes writers wait for each other
+// instead of failing with "database is locked".
+var sqlitePragmas = []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"}
+
+// Dialect returns the dialect of the DSN.
+func Dialect(dsn string) string {
+	if strings.HasPrefix(dsn, sqliteScheme) {
+		return SQLite
+	}
+	return Postgres
+}
+
+// Open opens the database with GORM.
+func Open(dsn string, cfg *gorm.Config) (*gorm.DB, error) {
+	if Dialect(dsn) == SQLite {
+		sqlDB, err := OpenSQL(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return gorm.Open(sqlite.Dialector{Conn: sqlDB}, cfg)
+	}
+	return gorm.Open(postgres.Open(dsn), cfg)
+}
+
+// slowQuery is how long a query may take before it is logged.
+const slowQuery = 200 * time.Millisecond
+
+// Logger returns the GORM logger that writes failed and slow queries to l. The
+// queries are logged without the values of their parameters, which can be
+// tokens, hashes or email addresses.
+func Logger(l *slog.Logger) logger.Interface {
+	return queryLogger{logger.NewSlogLogger(l, logger.Config{
+		SlowThreshold:             slowQuery,
+		IgnoreRecordNotFoundError: true,
+		ParameterizedQueries:      true,
+		LogLevel:                  logger.Warn,
+	})}
+}
+
+// queryLogger leaves the parameters out of the logged queries, which the slog
+// logger of GORM does not do by itself despite ParameterizedQueries.
+type queryLogger struct {
+	logger.Interface
+}
+
+func (l queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return queryLogger{l.Interface.LogMode(level)}
+}
+
+var _ gorm.ParamsFilter = queryLogger{}
+
+// ParamsFilter implements gorm.ParamsFilter.
+func (queryLogger) ParamsFilter(_ context.Context, sql string, _ ...any) (string, []any) {
+	return sql, nil
+}
+
+// OpenSQL opens the database with database/sql, for the migrations.
+func OpenSQL(dsn string) (*sql.DB, error) {
+	if Dialect(dsn) == Postgres {
+		return sql.Open("pgx", dsn)
+	}
+
+	path, err := sqlitePath(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and every connection to :memory: is a
+	// new database, so all queries share one connection.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// sqlitePath turns sqlite://file.db?opts into the file.db?opts DSN of the driver,
+// with the pragmas added. sqlite:///var/lib/til.db is an absolute path.
+func sqlitePath(dsn string) (string, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, sqliteScheme), "?")
+	if path == "" {
+		return "", errors.New("sqlite DSN needs a file name, for example sqlite://til.db")
+	}
+
+	params := make([]string, 0, len(sqlitePragmas)+1)
+	if query != "" {
+		params = append(params, query)
+	}
+	for _, p := range sqlitePragmas {
+		params = append(params, "_pragma="+p)
+	}
+	return fmt.Sprintf("%s?%s", path, strings.Join(params, "&")), nil
+}
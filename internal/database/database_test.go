@@ -0,0 +1,85 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDialect(t *testing.T) {
+	assert.Equal(t, database.SQLite, database.Dialect("sqlite://til.db"))
+	assert.Equal(t, database.SQLite, database.Dialect("sqlite:///var/lib/til/til.db"))
+	assert.Equal(t, database.Postgres, database.Dialect("postgres://user:pass@db:5432/til"))
+	assert.Equal(t, database.Postgres, database.Dialect("host=db user=til dbname=til"))
+}
+
+func TestOpen_SQLite(t *testing.T) {
+	db, err := database.Open("sqlite://"+filepath.Join(t.TempDir(), "til.db"), &gorm.Config{})
+	require.NoError(t, err)
+
+	var foreignKeys int
+	require.NoError(t, db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error)
+	assert.Equal(t, 1, foreignKeys, "foreign keys must be enforced")
+}
+
+func TestOpen_SQLiteWithoutFile(t *testing.T) {
+	_, err := database.Open("sqlite://", &gorm.Config{})
+	assert.Error(t, err)
+}
+
+func TestLogger_LeavesOutParameters(t *testing.T) {
+	var buf bytes.Buffer
+	db, err := database.Open("sqlite://"+filepath.Join(t.TempDir(), "til.db"), &gorm.Config{
+		Logger: database.Logger(slog.New(slog.NewJSONHandler(&buf, nil))),
+	})
+	require.NoError(t, err)
+
+	require.Error(t, db.Exec("UPDATE missing SET token = ?", "secret-token-hash").Error)
+	assert.Contains(t, buf.String(), `"level":"ERROR"`)
+	assert.Contains(t, buf.String(), "UPDATE missing SET token = ?")
+	assert.NotContains(t, buf.String(), "secret-token-hash")
+
+	buf.Reset()
+	require.NoError(t, db.Exec("CREATE TABLE notes (body text)").Error)
+	assert.Empty(t, buf.String(), "queries that succeed in time are not logged")
+}
+
+func TestTransaction(t *testing.T) {
+	db, err := database.Open("sqlite://"+filepath.Join(t.TempDir(), "til.db"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE notes (body text)").Error)
+	insert := func(ctx context.Context, body string) error {
+		return database.Conn(ctx, db).Exec("INSERT INTO notes (body) VALUES (?)", body).Error
+	}
+	count := func() int64 {
+		var n int64
+		require.NoError(t, db.Table("notes").Count(&n).Error)
+		return n
+	}
+
+	err = database.Transaction(t.Context(), db, func(ctx context.Context) error {
+		require.NoError(t, insert(ctx, "first"))
+		// A nested transaction joins the outer one
+		return database.Transaction(ctx, db, func(ctx context.Context) error {
+			return insert(ctx, "second")
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count())
+
+	failed := errors.New("failed")
+	err = database.Transaction(t.Context(), db, func(ctx context.Context) error {
+		require.NoError(t, insert(ctx, "third"))
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+	assert.Equal(t, int64(2), count(), "rolled back")
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+// Transaction runs fn in a database transaction, which is committed when fn
+// returns nil and rolled back otherwise. Repositories that get their
+// connection from Conn join the transaction through the context fn is called
+// with. Within a transaction fn runs in that same transaction.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// Conn returns the transaction that ctx runs in, or db when it runs in none.
+// SQLite has a single connection, so within a transaction every query must use
+// Conn or it waits for the transaction forever.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
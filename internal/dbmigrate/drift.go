@@ -0,0 +1,191 @@
+package dbmigrate
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/amavis442/til-backend/internal/scheduler"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Drift is a difference between a GORM model and its table in the database.
+type Drift struct {
+	Table  string
+	Column string // Empty when the drift is about the table or an index
+	Reason string
+}
+
+func (d Drift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("%s: %s", d.Table, d.Reason)
+	}
+	return fmt.Sprintf("%s.%s: %s", d.Table, d.Column, d.Reason)
+}
+
+// Models returns the GORM models whose tables are created by the migrations.
+func Models() []any {
+	return []any{&user.User{}, &til.TIL{}, &auth.RefreshToken{}, &preferences.UserPreferences{}, &ratelimit.Counter{}, &scheduler.Run{}, &queue.Job{}}
+}
+
+// CheckDrift compares the models with the live schema of the database. It
+// reports missing tables, columns and indexes, columns that are not in a model,
+// column types that differ, and columns that are nullable or not unique while
+// the model says otherwise. The database may be stricter than a model, for
+// example a NOT NULL created_at, so that is not reported.
+func CheckDrift(db *gorm.DB, models ...any) ([]Drift, error) {
+	var drifts []Drift
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		d, err := checkModel(db, stmt.Schema, model)
+		if err != nil {
+			return nil, fmt.Errorf("could not check table %s: %w", stmt.Schema.Table, err)
+		}
+		drifts = append(drifts, d...)
+	}
+	return drifts, nil
+}
+
+func checkModel(db *gorm.DB, s *schema.Schema, model any) ([]Drift, error) {
+	m := db.Migrator()
+	if !m.HasTable(model) {
+		return []Drift{{Table: s.Table, Reason: "table does not exist"}}, nil
+	}
+
+	columnTypes, err := m.ColumnTypes(model)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := m.GetIndexes(model)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]gorm.ColumnType{}
+	for _, ct := range columnTypes {
+		columns[ct.Name()] = ct
+	}
+
+	var drifts []Drift
+	add := func(column, format string, args ...any) {
+		drifts = append(drifts, Drift{Table: s.Table, Column: column, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	for _, f := range s.Fields {
+		if f.DBName == "" || f.IgnoreMigration {
+			continue
+		}
+		ct, ok := columns[f.DBName]
+		if !ok {
+			add(f.DBName, "column does not exist")
+			continue
+		}
+		delete(columns, f.DBName)
+
+		want := db.Dialector.DataTypeOf(f)
+		wantKind, wantLength := normalizeType(want)
+		gotKind, _ := normalizeType(ct.DatabaseTypeName())
+		gotLength, _ := ct.Length()
+		if wantKind != gotKind || (wantKind == "varchar" && wantLength != gotLength) {
+			got := gotKind
+			if gotKind == "varchar" {
+				got = fmt.Sprintf("varchar(%d)", gotLength)
+			}
+			add(f.DBName, "type is %s, the model expects %s", got, strings.ToLower(strings.Fields(want)[0]))
+		}
+
+		if nullable, ok := ct.Nullable(); ok && nullable && f.NotNull && !f.PrimaryKey {
+			add(f.DBName, "column is nullable, the model expects NOT NULL")
+		}
+
+		if f.Unique && !isUnique(ct, indexes) {
+			add(f.DBName, "column is not unique, the model expects a unique constraint")
+		}
+	}
+
+	extra := make([]string, 0, len(columns))
+	for name := range columns {
+		extra = append(extra, name)
+	}
+	slices.Sort(extra)
+	for _, name := range extra {
+		add(name, "column is not in the model")
+	}
+
+	for _, idx := range s.ParseIndexes() {
+		cols := make([]string, 0, len(idx.Fields))
+		for _, opt := range idx.Fields {
+			cols = append(cols, opt.DBName)
+		}
+		unique := idx.Class == "UNIQUE"
+		if !hasIndex(indexes, cols, unique) {
+			kind := "index"
+			if unique {
+				kind = "unique index"
+			}
+			add("", "%s on (%s) does not exist", kind, strings.Join(cols, ", "))
+		}
+	}
+
+	return drifts, nil
+}
+
+// normalizeType maps the type names of the models and of the Postgres and
+// SQLite catalogs to one name per kind, so "int4", "bigserial" and "integer
+// PRIMARY KEY AUTOINCREMENT" all compare as integer. Only varchar keeps its length.
+func normalizeType(t string) (string, int64) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	var length int64
+	if open := strings.Index(t, "("); open >= 0 {
+		if end := strings.Index(t[open:], ")"); end > 0 {
+			length, _ = strconv.ParseInt(t[open+1:open+end], 10, 64)
+		}
+		t = t[:open]
+	}
+	if fields := strings.Fields(t); len(fields) > 0 {
+		t = fields[0]
+	}
+
+	switch t {
+	case "int", "int2", "int4", "int8", "integer", "smallint", "bigint", "serial", "smallserial", "bigserial":
+		return "integer", 0
+	case "bool", "boolean":
+		return "boolean", 0
+	case "timestamptz", "datetime":
+		return "timestamptz", 0
+	case "varchar":
+		return "varchar", length
+	}
+	return t, 0
+}
+
+func isUnique(ct gorm.ColumnType, indexes []gorm.Index) bool {
+	if unique, ok := ct.Unique(); ok && unique {
+		return true
+	}
+	return hasIndex(indexes, []string{ct.Name()}, true)
+}
+
+func hasIndex(indexes []gorm.Index, columns []string, unique bool) bool {
+	for _, idx := range indexes {
+		if !slices.Equal(idx.Columns(), columns) {
+			continue
+		}
+		if isUnique, _ := idx.Unique(); unique && !isUnique {
+			continue
+		}
+		return true
+	}
+	return false
+}
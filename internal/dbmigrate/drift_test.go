@@ -0,0 +1,115 @@
+package dbmigrate_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/dbmigrate"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openSQLite(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	return db
+}
+
+func hasDrift(drifts []dbmigrate.Drift, table, column, reason string) bool {
+	for _, d := range drifts {
+		if d.Table == table && d.Column == column && strings.Contains(d.Reason, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckDrift_NoDriftAfterAutoMigrate(t *testing.T) {
+	db := openSQLite(t)
+	require.NoError(t, db.AutoMigrate(dbmigrate.Models()...))
+
+	drifts, err := dbmigrate.CheckDrift(db, dbmigrate.Models()...)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestCheckDrift_ReportsDifferences(t *testing.T) {
+	db := openSQLite(t)
+	require.NoError(t, db.Exec(`CREATE TABLE tils (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		created_at datetime,
+		updated_at datetime,
+		deleted_at datetime,
+		title varchar(255) NOT NULL,
+		content text NOT NULL,
+		category text,
+		user_id integer NOT NULL,
+		legacy text
+	)`).Error)
+
+	drifts, err := dbmigrate.CheckDrift(db, &til.TIL{})
+	require.NoError(t, err)
+
+	assert.True(t, hasDrift(drifts, "tils", "title", "type is varchar(255), the model expects text"), drifts)
+	assert.True(t, hasDrift(drifts, "tils", "category", "nullable"), drifts)
+	assert.True(t, hasDrift(drifts, "tils", "html", "does not exist"), drifts)
+	assert.True(t, hasDrift(drifts, "tils", "legacy", "not in the model"), drifts)
+	assert.True(t, hasDrift(drifts, "tils", "", "index on (user_id) does not exist"), drifts)
+	assert.False(t, hasDrift(drifts, "tils", "content", ""), "content matches the model")
+}
+
+func TestCheckDrift_MissingTable(t *testing.T) {
+	db := openSQLite(t)
+
+	drifts, err := dbmigrate.CheckDrift(db, &til.TIL{})
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, "tils: table does not exist", drifts[0].String())
+}
+
+func TestMigrationsMatchModels_SQLite(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "til.db")
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Up())
+
+	db, err := database.Open(dsn, &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	drifts, err := dbmigrate.CheckDrift(db, dbmigrate.Models()...)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+// TestMigrationsMatchModels migrates a real Postgres database and checks that
+// the schema matches the GORM models. Set TEST_POSTGRES_DSN to a database that
+// may be wiped to run it.
+func TestMigrationsMatchModels(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Up())
+
+	db, err := database.Open(dsn, &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	drifts, err := dbmigrate.CheckDrift(db, dbmigrate.Models()...)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
@@ -0,0 +1,237 @@
+// Package dbmigrate applies the embedded SQL migrations to the database. It uses
+// golang-migrate and its schema_migrations table, so databases that were migrated
+// with the migrate CLI before keep working. Postgres and SQLite are supported.
+package dbmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	migratedb "github.com/golang-migrate/migrate/v4/database"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// startupLockID is the key of the Postgres advisory lock that is held while the
+// server migrates on start, so only one replica runs the migrations at a time.
+const startupLockID int64 = 0x71_6c_6d_69_67 // "tilmig"
+
+// Status describes which migrations are applied to the database.
+type Status struct {
+	Version uint   // Last applied migration, 0 when none are applied
+	Dirty   bool   // A migration failed halfway and needs manual attention
+	Latest  uint   // Last embedded migration
+	Pending []uint // Embedded migrations newer than Version
+}
+
+type Migrator struct {
+	db      *sql.DB
+	m       *migrate.Migrate
+	dialect string
+}
+
+// Open connects to the database with its own connection pool. Close releases it.
+func Open(dsn string) (*Migrator, error) {
+	dialect := database.Dialect(dsn)
+	db, err := database.OpenSQL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to the database: %w", err)
+	}
+
+	files, err := migrations.For(dialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var driver migratedb.Driver
+	if dialect == database.SQLite {
+		driver, err = newSQLiteDriver(db)
+	} else {
+		driver, err = pgxmigrate.WithInstance(db, &pgxmigrate.Config{})
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, dialect, driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Migrator{db: db, m: m, dialect: dialect}, nil
+}
+
+// Close closes the migration source and the database connection pool.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down reverts the given number of applied migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	return ignoreNoChange(m.m.Steps(-steps))
+}
+
+// Goto migrates up or down to the given version.
+func (m *Migrator) Goto(version uint) error {
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Force records version as applied and clears the dirty flag, without running
+// a migration. After a migration failed halfway, undo or finish its changes by
+// hand and force the version the schema is now at; 0 for no migration.
+func (m *Migrator) Force(version uint) error {
+	if version == 0 {
+		return m.m.Force(-1) // NilVersion of golang-migrate
+	}
+	return m.m.Force(int(version))
+}
+
+// UpWithLock applies all pending migrations while holding a Postgres advisory
+// lock. Other replicas that start at the same time wait for the lock, and then
+// find nothing left to do. SQLite has a single server process, so it only
+// applies the migrations.
+func (m *Migrator) UpWithLock(ctx context.Context) error {
+	if m.dialect == database.SQLite {
+		return m.Up()
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", startupLockID); err != nil {
+		return fmt.Errorf("could not acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", startupLockID)
+
+	return m.Up()
+}
+
+// Status reports the applied version and the migrations that are still pending.
+func (m *Migrator) Status() (Status, error) {
+	available, err := Available(m.dialect)
+	if err != nil {
+		return Status{}, err
+	}
+
+	var status Status
+	version, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return Status{}, err
+	}
+	status.Version = version
+	status.Dirty = dirty
+
+	if len(available) > 0 {
+		status.Latest = available[len(available)-1]
+	}
+	for _, v := range available {
+		if v > status.Version {
+			status.Pending = append(status.Pending, v)
+		}
+	}
+	return status, nil
+}
+
+// Available returns the versions of the embedded migrations of the dialect in
+// ascending order.
+func Available(dialect string) ([]uint, error) {
+	files, err := migrations.For(dialect)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []uint
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".up.sql")
+		if !ok {
+			continue
+		}
+		prefix, _, _ := strings.Cut(name, "_")
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s: %w", e.Name(), err)
+		}
+		versions = append(versions, uint(v))
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+// Latest returns the version of the newest embedded migration of the dialect.
+func Latest(dialect string) (uint, error) {
+	versions, err := Available(dialect)
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// CheckVersion returns an error when the database is dirty or behind the
+// embedded migrations of the dialect. It reads schema_migrations through db, so
+// it can run on the connection pool of the server. A database that is ahead is
+// fine, as servers of the previous release keep running during a deploy.
+func CheckVersion(ctx context.Context, db *sql.DB, dialect string) error {
+	latest, err := Latest(dialect)
+	if err != nil {
+		return err
+	}
+
+	var (
+		version uint
+		dirty   bool
+	)
+	err = db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("could not read the migration version: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("migration %d failed halfway and needs manual attention, see server migrate force", version)
+	}
+	if version < latest {
+		return fmt.Errorf("database is at version %d, the server needs %d", version, latest)
+	}
+	return nil
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package dbmigrate_test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/dbmigrate"
+	"github.com/amavis442/til-backend/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvailable(t *testing.T) {
+	versions, err := dbmigrate.Available(database.Postgres)
+	require.NoError(t, err)
+	require.NotEmpty(t, versions)
+
+	for i, v := range versions {
+		assert.Equal(t, uint(i+1), v, "migration versions must be contiguous")
+	}
+
+	latest, err := dbmigrate.Latest(database.Postgres)
+	require.NoError(t, err)
+	assert.Equal(t, versions[len(versions)-1], latest)
+}
+
+func TestDialectsHaveTheSameVersions(t *testing.T) {
+	postgresVersions, err := dbmigrate.Available(database.Postgres)
+	require.NoError(t, err)
+	sqliteVersions, err := dbmigrate.Available(database.SQLite)
+	require.NoError(t, err)
+	require.NotEmpty(t, sqliteVersions)
+
+	// SQLite starts with the full schema at the version where it was added
+	first := slices.Index(postgresVersions, sqliteVersions[0])
+	require.GreaterOrEqual(t, first, 0, "SQLite must start at a Postgres version")
+	assert.Equal(t, postgresVersions[first:], sqliteVersions)
+}
+
+func TestEveryUpMigrationHasADownMigration(t *testing.T) {
+	for _, dialect := range []string{database.Postgres, database.SQLite} {
+		dir, err := migrations.For(dialect)
+		require.NoError(t, err)
+		entries, err := fs.ReadDir(dir, ".")
+		require.NoError(t, err)
+
+		files := map[string]bool{}
+		for _, e := range entries {
+			files[e.Name()] = true
+		}
+		for name := range files {
+			if base, ok := strings.CutSuffix(name, ".up.sql"); ok {
+				assert.True(t, files[base+".down.sql"], "missing down migration for %s/%s", dialect, name)
+			}
+		}
+	}
+}
+
+func TestMigrateUpAndDown_SQLite(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "til.db")
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+
+	latest, err := dbmigrate.Latest(database.SQLite)
+	require.NoError(t, err)
+
+	require.NoError(t, m.UpWithLock(t.Context()))
+	status, err := m.Status()
+	require.NoError(t, err)
+	assert.Equal(t, latest, status.Version)
+	assert.Empty(t, status.Pending)
+
+	versions, err := dbmigrate.Available(database.SQLite)
+	require.NoError(t, err)
+	require.NoError(t, m.Down(len(versions)))
+	status, err = m.Status()
+	require.NoError(t, err)
+	assert.Zero(t, status.Version)
+
+	require.NoError(t, m.Up())
+}
+
+func TestCheckVersion_SQLite(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "til.db")
+	db, err := database.OpenSQL(dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = dbmigrate.CheckVersion(t.Context(), db, database.SQLite)
+	assert.ErrorContains(t, err, "could not read the migration version", "schema_migrations does not exist yet")
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Up())
+	assert.NoError(t, dbmigrate.CheckVersion(t.Context(), db, database.SQLite))
+
+	require.NoError(t, m.Down(1))
+	latest, err := dbmigrate.Latest(database.SQLite)
+	require.NoError(t, err)
+	assert.EqualError(t, dbmigrate.CheckVersion(t.Context(), db, database.SQLite),
+		fmt.Sprintf("database is at version %d, the server needs %d", latest-1, latest))
+
+	_, err = db.Exec("UPDATE schema_migrations SET dirty = true")
+	require.NoError(t, err)
+	assert.ErrorContains(t, dbmigrate.CheckVersion(t.Context(), db, database.SQLite), "needs manual attention")
+}
+
+func TestForce_SQLite(t *testing.T) {
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "til.db")
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Up())
+	latest, err := dbmigrate.Latest(database.SQLite)
+	require.NoError(t, err)
+
+	// The last migration failed halfway, and was finished by hand
+	db, err := database.OpenSQL(dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("UPDATE schema_migrations SET dirty = true")
+	require.NoError(t, err)
+	assert.Error(t, m.Goto(latest-1), "a dirty database does not migrate")
+
+	require.NoError(t, m.Force(latest))
+	status, err := m.Status()
+	require.NoError(t, err)
+	assert.Equal(t, latest, status.Version)
+	assert.False(t, status.Dirty)
+	require.NoError(t, m.Down(1))
+	require.NoError(t, m.Up())
+}
+
+// TestMigrateUpAndDown runs all migrations up and down against a real Postgres
+// database. Set TEST_POSTGRES_DSN to a database that may be wiped to run it.
+func TestMigrateUpAndDown(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	defer m.Close()
+
+	latest, err := dbmigrate.Latest(database.Postgres)
+	require.NoError(t, err)
+
+	require.NoError(t, m.UpWithLock(t.Context()))
+	status, err := m.Status()
+	require.NoError(t, err)
+	assert.Equal(t, latest, status.Version)
+	assert.Empty(t, status.Pending)
+
+	require.NoError(t, m.Down(int(latest)))
+	status, err = m.Status()
+	require.NoError(t, err)
+	assert.Zero(t, status.Version)
+	assert.Len(t, status.Pending, int(latest))
+
+	require.NoError(t, m.Up())
+}
@@ -0,0 +1,135 @@
+package dbmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// sqliteDriver is a golang-migrate database driver for the SQLite connection of
+// the server. The sqlite driver of golang-migrate registers its own database/sql
+// driver under the same name as glebarez/sqlite, so the two cannot be linked
+// into one binary.
+type sqliteDriver struct {
+	db     *sql.DB
+	locked atomic.Bool
+}
+
+func newSQLiteDriver(db *sql.DB) (database.Driver, error) {
+	d := &sqliteDriver{db: db}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL, dirty boolean NOT NULL);
+		CREATE UNIQUE INDEX IF NOT EXISTS schema_migrations_version ON schema_migrations (version);`)
+	if err != nil {
+		return nil, fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+	return d, nil
+}
+
+func (d *sqliteDriver) Open(string) (database.Driver, error) {
+	return nil, errors.New("open the SQLite database with dbmigrate.Open")
+}
+
+func (d *sqliteDriver) Close() error {
+	return d.db.Close()
+}
+
+// Lock only guards against concurrent use within the process. Several
+// processes that migrate one SQLite file are serialized by SQLite itself.
+func (d *sqliteDriver) Lock() error {
+	if !d.locked.CompareAndSwap(false, true) {
+		return database.ErrLocked
+	}
+	return nil
+}
+
+func (d *sqliteDriver) Unlock() error {
+	if !d.locked.CompareAndSwap(true, false) {
+		return database.ErrNotLocked
+	}
+	return nil
+}
+
+// Run executes the migration in a transaction, so a failed migration leaves
+// no half-applied changes behind.
+func (d *sqliteDriver) Run(migration io.Reader) error {
+	query, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(string(query)); err != nil {
+		tx.Rollback()
+		return database.Error{OrigErr: err, Query: query}
+	}
+	return tx.Commit()
+}
+
+func (d *sqliteDriver) SetVersion(version int, dirty bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM schema_migrations"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Version -1 means no migration is applied, and an empty table says just that
+	if version >= 0 {
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)", version, dirty); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *sqliteDriver) Version() (int, bool, error) {
+	var (
+		version int
+		dirty   bool
+	)
+	err := d.db.QueryRow("SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.NilVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return version, dirty, nil
+}
+
+// Drop removes all tables, including schema_migrations.
+func (d *sqliteDriver) Drop() error {
+	rows, err := d.db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		if _, err := d.db.Exec(fmt.Sprintf("DROP TABLE %q", t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
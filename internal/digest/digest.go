@@ -0,0 +1,118 @@
+// Package digest mails users a summary of the TILs they wrote.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/mail"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/user"
+)
+
+// week is the period the weekly digest looks back.
+const week = 7 * 24 * time.Hour
+
+// Service queues the digest emails.
+type Service interface {
+	EnqueueWeekly(ctx context.Context, now time.Time) (int, error)
+}
+
+type service struct {
+	users user.Service
+	prefs preferences.Service
+	tils  til.Service
+	jobs  queue.Queue
+}
+
+func NewService(users user.Service, prefs preferences.Service, tils til.Service, jobs queue.Queue) Service {
+	return &service{users: users, prefs: prefs, tils: tils, jobs: jobs}
+}
+
+// EnqueueWeekly queues an email for every user that opted in to the weekly
+// digest with the TILs they wrote in the week before now. Users without a
+// verified email address, disabled users and users that wrote nothing get no
+// email, and nobody gets a second email for the same ISO week. A failure for
+// one user does not stop the others; all failures are returned together with
+// the number of emails that were queued.
+func (s *service) EnqueueWeekly(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := tracing.Start(ctx, "digest.EnqueueWeekly")
+	defer span.End()
+
+	ids, err := s.prefs.WeeklyDigestUserIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	var errs []error
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return queued, err
+		}
+
+		u, err := s.users.GetByID(ctx, id)
+		if errors.Is(err, apperr.ErrNotFound) {
+			continue // Deleted since
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !u.EmailVerified || u.Disabled {
+			continue
+		}
+
+		tils, err := s.tils.ListByUserSince(ctx, u.ID, now.Add(-week))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(tils) == 0 {
+			continue
+		}
+
+		year, weekNumber := now.ISOWeek()
+		job, err := mail.NewSendJob(fmt.Sprintf("digest.weekly:%d:%d-W%02d", u.ID, year, weekNumber), weeklyMessage(u, tils))
+		if err == nil {
+			err = s.jobs.Enqueue(ctx, job)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", u.ID, err))
+			continue
+		}
+		queued++
+	}
+	return queued, errors.Join(errs...)
+}
+
+func weeklyMessage(u *user.User, tils []til.TIL) mail.Message {
+	name := u.DisplayName
+	if name == "" {
+		name = u.Username
+	}
+	things := "things"
+	if len(tils) == 1 {
+		things = "thing"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nThis week you learned %d %s:\n\n", name, len(tils), things)
+	for _, t := range tils {
+		fmt.Fprintf(&b, "- %s (%s)\n", t.Title, t.Category)
+	}
+	b.WriteString("\nYou get this email because the weekly digest is on in your preferences.\n")
+
+	return mail.Message{
+		To:      u.Email,
+		Subject: fmt.Sprintf("This week you learned %d %s", len(tils), things),
+		Body:    b.String(),
+	}
+}
@@ -0,0 +1,99 @@
+package digest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/digest"
+	"github.com/amavis442/til-backend/internal/mail"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingQueue struct {
+	err error
+}
+
+func (q failingQueue) Enqueue(ctx context.Context, jobs ...*queue.Job) error {
+	return q.err
+}
+
+func TestEnqueueWeekly(t *testing.T) {
+	db := storagetest.OpenSQLite(t)
+	now := time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)
+	prefs := preferences.NewService(preferences.NewRepository(db))
+
+	// addUser creates a verified user with a TIL written days ago, that
+	// wants the digest when digest is set.
+	addUser := func(name string, digest bool, days int, modify func(u *user.User)) uint {
+		u := user.User{Username: name, Email: name + "@example.com", PasswordHash: "irrelevant", EmailVerified: true}
+		if modify != nil {
+			modify(&u)
+		}
+		require.NoError(t, db.Create(&u).Error)
+		require.NoError(t, db.Create(&til.TIL{Title: name + " learned", Content: "x", Category: "go", UserID: u.ID, CreatedAt: now.AddDate(0, 0, -days)}).Error)
+		p := preferences.Defaults()
+		p.WeeklyDigest = digest
+		_, err := prefs.Save(t.Context(), u.ID, p)
+		require.NoError(t, err)
+		return u.ID
+	}
+	addUser("alice", true, 2, func(u *user.User) { u.DisplayName = "Alice" })
+	addUser("bob", false, 2, nil)
+	addUser("carol", true, 9, nil)
+	addUser("dave", true, 2, func(u *user.User) { u.EmailVerified = false })
+	addUser("erin", true, 2, func(u *user.User) { u.Disabled = true })
+	frank := addUser("frank", true, 2, nil)
+	require.NoError(t, db.Delete(&user.User{}, frank).Error)
+
+	jobs := queue.NewMemoryQueue()
+	svc := digest.NewService(user.NewService(user.NewRepository(db), queue.NewMemoryQueue()), prefs, til.NewService(til.NewRepository(db), queue.NewMemoryQueue()), jobs)
+
+	queued, err := svc.EnqueueWeekly(t.Context(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+	sent := jobs.Jobs(mail.SendJob)
+	require.Len(t, sent, 1)
+	var msg mail.Message
+	require.NoError(t, sent[0].Decode(&msg))
+	assert.Equal(t, "alice@example.com", msg.To)
+	assert.Equal(t, "This week you learned 1 thing", msg.Subject)
+	assert.Contains(t, msg.Body, "Hi Alice,")
+	assert.Contains(t, msg.Body, "- alice learned (go)\n")
+
+	// A second run in the same week, after a retry of the scheduled job, is
+	// dropped by the key of the email
+	_, err = svc.EnqueueWeekly(t.Context(), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, jobs.Jobs(mail.SendJob), 1)
+}
+
+func TestEnqueueWeekly_ReportsFailures(t *testing.T) {
+	db := storagetest.OpenSQLite(t)
+	ids := storagetest.CreateUsers(t, db, 2)
+	prefs := preferences.NewService(preferences.NewRepository(db))
+	for _, id := range ids {
+		require.NoError(t, db.Model(&user.User{}).Where("id = ?", id).Update("email_verified", true).Error)
+		require.NoError(t, db.Create(&til.TIL{Title: "learned", Content: "x", Category: "go", UserID: id}).Error)
+		p := preferences.Defaults()
+		p.WeeklyDigest = true
+		_, err := prefs.Save(t.Context(), id, p)
+		require.NoError(t, err)
+	}
+
+	jobs := failingQueue{err: errors.New("database is locked")}
+	svc := digest.NewService(user.NewService(user.NewRepository(db), queue.NewMemoryQueue()), prefs, til.NewService(til.NewRepository(db), queue.NewMemoryQueue()), jobs)
+
+	queued, err := svc.EnqueueWeekly(t.Context(), time.Now().Add(time.Minute))
+	assert.Zero(t, queued)
+	assert.ErrorContains(t, err, "database is locked")
+	assert.ErrorContains(t, err, "user 1:")
+	assert.ErrorContains(t, err, "user 2:", "every user is tried")
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/account"
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AccountHandler struct {
+	service account.Service
+	cookies *CookieBuilder
+	logger  *slog.Logger
+}
+
+func NewAccountHandler(s account.Service, cookies *CookieBuilder, slogger *slog.Logger) *AccountHandler {
+	return &AccountHandler{
+		service: s,
+		cookies: cookies,
+		logger:  slogger,
+	}
+}
+
+// Export sends a zip archive with all personal data of the logged in user.
+func (h *AccountHandler) Export(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	var buf bytes.Buffer
+	if err := h.service.Export(c.UserContext(), userID, &buf); err != nil {
+		return fmt.Errorf("could not export data for userID %v: %w", userID, err)
+	}
+
+	filename := fmt.Sprintf("til-export-%s.zip", time.Now().Format("2006-01-02"))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	return c.Send(buf.Bytes())
+}
+
+// RequestDeletionCode mails the logged in user a code that confirms the
+// deletion of the account instead of the password.
+func (h *AccountHandler) RequestDeletionCode(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	if err := h.service.RequestDeletionCode(c.UserContext(), userID); err != nil {
+		return fmt.Errorf("could not send a deletion code to userID %v: %w", userID, err)
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// Delete removes the account of the logged in user after the password, or a
+// deletion code, is confirmed.
+func (h *AccountHandler) Delete(c *fiber.Ctx) error {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := bind(c, &req); err != nil {
+		return err
+	}
+	if req.Password == "" && req.Code == "" {
+		return apperr.Validation(nil, apperr.FieldError{Field: "password", Code: "required", Message: "is required, unless a deletion code is sent"})
+	}
+
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	confirm := account.Confirmation{Password: req.Password, Code: req.Code}
+	if err := h.service.Delete(c.UserContext(), userID, confirm); err != nil {
+		if errors.Is(err, account.ErrInvalidPassword) || errors.Is(err, account.ErrInvalidCode) {
+			h.logger.WarnContext(c.UserContext(), "Account deletion with invalid confirmation", "user_id", userID)
+		}
+		return fmt.Errorf("could not delete account for userID %v: %w", userID, err)
+	}
+
+	c.Cookie(h.cookies.ClearAccessToken())
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,12 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/amavis442/til-backend/internal/auth"
-	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/metrics"
 	"github.com/amavis442/til-backend/internal/user"
 	"github.com/gofiber/fiber/v2"
 )
@@ -14,81 +15,77 @@ import (
 type AuthHandler struct {
 	userService         user.Service // interface for user validation, etc.
 	refreshTokenService auth.Service
+	tokens              *auth.Tokens
+	cookies             *CookieBuilder
 	logger              *slog.Logger // Assume Logger interface is defined elsewhere
 }
 
-func NewAuthHandler(userSvc user.Service, refreshTokenSvc auth.Service, slogger *slog.Logger) *AuthHandler {
+func NewAuthHandler(userSvc user.Service, refreshTokenSvc auth.Service, tokens *auth.Tokens, cookies *CookieBuilder, slogger *slog.Logger) *AuthHandler {
 	return &AuthHandler{
 		userService:         userSvc,
 		refreshTokenService: refreshTokenSvc,
+		tokens:              tokens,
+		cookies:             cookies,
 		logger:              slogger,
 	}
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	type LoginRequest struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}
-
-	isProduction := config.IsProduction()
-	if !isProduction {
-		h.logger.Info("Login request")
+		Username string `json:"username" validate:"required"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var req LoginRequest
-	if err := c.BodyParser(&req); err != nil {
-		h.logger.Warn(fmt.Sprintf("Failed to parse login request: %v", err))
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	if err := bind(c, &req); err != nil {
+		h.logger.WarnContext(c.UserContext(), "Invalid login request", "error", err)
+		return err
 	}
 
 	// Validate user via service (not hardcoded)
-	valid, userID, _ := h.userService.ValidateCredentials(req.Username, req.Password)
-	if !valid {
-		h.logger.Warn(fmt.Sprintf("Invalid credentials for user: %s", req.Username))
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
-	}
+	valid, userID, err := h.userService.ValidateCredentials(c.UserContext(), req.Username, req.Password)
+	switch {
+	case errors.Is(err, user.ErrDisabled):
+		h.logger.WarnContext(c.UserContext(), "Disabled user tried to log in", "username", req.Username)
+		metrics.Logins.WithLabelValues("password", "failure").Inc()
+		return err
+	case err != nil:
+		// Not a failed login: the credentials could not be checked
+		return err
+	case !valid:
+		h.logger.WarnContext(c.UserContext(), "Invalid credentials", "username", req.Username)
+		metrics.Logins.WithLabelValues("password", "failure").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	if err := h.login(c, userID); err != nil {
+		return err
+	}
+	metrics.Logins.WithLabelValues("password", "success").Inc()
+	return nil
+}
 
+// login invalidates the old refresh token of the user, issues a new access and
+// refresh token and sends them back as JSON and as access token cookie.
+func (h *AuthHandler) login(c *fiber.Ctx, userID uint) error {
 	// Login should invalidate the old refresh token if it exists
 	// and give new tokens
-	err := h.refreshTokenService.DeleteRefreshTokenByUserID(userID)
+	err := h.refreshTokenService.DeleteRefreshTokenByUserID(c.UserContext(), userID)
 	if err != nil {
-		h.logger.Error(fmt.Sprintf("Failed to remove old refresh token from database for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+		return fmt.Errorf("failed to remove old refresh token from database for userID %v: %w", userID, err)
 	}
 
-	access, refresh, err := auth.GenerateTokens(userID)
+	access, refresh, err := h.tokens.Generate(userID)
 	if err != nil {
-		h.logger.Error(fmt.Sprintf("Failed to generate tokens for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
+		return fmt.Errorf("failed to generate tokens for userID %v: %w", userID, err)
 	}
-	err = h.refreshTokenService.SaveRefreshToken(userID, refresh)
+	err = h.refreshTokenService.SaveRefreshToken(c.UserContext(), userID, refresh)
 	if err != nil {
-		h.logger.Error(err.Error())
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate token"})
-	}
-
-	sameSite := "None"
-	domain := "localhost"
-	if !isProduction {
-		sameSite = "Lax"
-	}
-
-	cookie := fiber.Cookie{
-		Name:     "access_token",
-		Value:    access,
-		Expires:  time.Now().Add(time.Minute * 15),
-		HTTPOnly: true,
-		Secure:   isProduction, // Only set to true when using https://
-		Domain:   domain,
-		SameSite: sameSite,
+		return fmt.Errorf("failed to save refresh token for userID %v: %w", userID, err)
 	}
 
-	c.Cookie(&cookie)
-
-	if !isProduction {
-		h.logger.Info(fmt.Sprintf("Access token is: %v", access))
-	}
+	c.Cookie(h.cookies.AccessToken(access, time.Now().Add(h.tokens.AccessTTL())))
+	h.logger.InfoContext(c.UserContext(), "Logged in", "user_id", userID)
 
 	return c.JSON(fiber.Map{
 		"access_token":  access,
@@ -98,93 +95,70 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 	type RefreshRequest struct {
-		RefreshToken string `json:"refresh_token"`
+		RefreshToken string `json:"refresh_token" validate:"required"`
 	}
 
-	isProduction := config.IsProduction()
-
 	var req RefreshRequest
-	if err := c.BodyParser(&req); err != nil {
-		h.logger.Warn(fmt.Sprintf("Failed to parse refresh token request: %v", err))
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
-	}
-
-	if req.RefreshToken == "" {
-		h.logger.Warn("Missing refresh token in request")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing refresh token"})
+	if err := bind(c, &req); err != nil {
+		h.logger.WarnContext(c.UserContext(), "Invalid refresh token request", "error", err)
+		return err
 	}
 
-	claims, err := auth.VerifyToken(req.RefreshToken)
+	claims, err := h.tokens.Verify(req.RefreshToken)
 	if err != nil {
-		h.logger.Warn(fmt.Sprintf("Invalid refresh token: %v", err))
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+		h.logger.WarnContext(c.UserContext(), "Invalid refresh token", "error", err)
+		metrics.RefreshTokens.WithLabelValues("invalid").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid refresh token")
 	}
 
 	// Check token type
 	if typ, ok := claims["typ"].(string); !ok || typ != "refresh" {
-		h.logger.Warn("Invalid token type in refresh token")
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token type"})
+		h.logger.WarnContext(c.UserContext(), "Invalid token type in refresh token")
+		metrics.RefreshTokens.WithLabelValues("invalid").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid token type")
 	}
 
 	userID, err := auth.ExtractUserIDFromClaims(claims)
 	if err != nil {
-		h.logger.Warn(fmt.Sprintf("Invalid user ID in token: %v", err))
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+		h.logger.WarnContext(c.UserContext(), "Invalid user ID in refresh token", "error", err)
+		metrics.RefreshTokens.WithLabelValues("invalid").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID in token")
 	}
 
-	refreshToken, err := h.refreshTokenService.FindRefreshTokenByUserID(userID)
+	refreshToken, err := h.refreshTokenService.FindRefreshTokenByUserID(c.UserContext(), userID)
 	// Check if refresh token is not expired and if so, create a new one. But if it is not expired, check if it is valid.
 	if err != nil {
-		h.logger.Warn(fmt.Sprintf("No valid refresh token found for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "No valid refresh token found"})
+		h.logger.WarnContext(c.UserContext(), "No valid refresh token found", "user_id", userID, "error", err)
+		metrics.RefreshTokens.WithLabelValues("invalid").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "No valid refresh token found")
 	}
 
 	// Check if the refresh token send and that stored in the database are the same
 	if refreshToken.Token != req.RefreshToken {
-		h.logger.Warn(fmt.Sprintf("Refresh token mismatch for userID %v with token: [ %v ]", userID, req.RefreshToken))
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token found"})
+		h.logger.WarnContext(c.UserContext(), "Refresh token was used before", "user_id", userID)
+		// A validly signed token that is not the current one was rotated before
+		metrics.RefreshTokens.WithLabelValues("reused").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid refresh token found")
 	}
 
 	// Invalidate the used refresh token to prevent replay attacks
-	if err := h.refreshTokenService.DeleteRefreshToken(refreshToken.Token); err != nil {
-		h.logger.Error(fmt.Sprintf("Could not invalidate refresh token for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not invalidate refresh token"})
+	if err := h.refreshTokenService.DeleteRefreshToken(c.UserContext(), refreshToken.Token); err != nil {
+		return fmt.Errorf("could not invalidate refresh token for userID %v: %w", userID, err)
 	}
 
 	// Generate tokens
-	newAccess, newRefresh, err := auth.GenerateTokens(userID)
+	newAccess, newRefresh, err := h.tokens.Generate(userID)
 	if err != nil {
-		h.logger.Error(fmt.Sprintf("Could not refresh token for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not refresh token"})
+		return fmt.Errorf("could not refresh token for userID %v: %w", userID, err)
 	}
 
 	// Persist new refesh token
-	if err := h.refreshTokenService.SaveRefreshToken(userID, newRefresh); err != nil {
-		h.logger.Error(fmt.Sprintf("Could not persist new refresh token for userID %v: %v", userID, err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not persist refresh token"})
-	}
-
-	sameSite := "None"
-	domain := "localhost"
-	if !isProduction {
-		sameSite = "Lax"
+	if err := h.refreshTokenService.SaveRefreshToken(c.UserContext(), userID, newRefresh); err != nil {
+		return fmt.Errorf("could not persist new refresh token for userID %v: %w", userID, err)
 	}
 
-	cookie := fiber.Cookie{
-		Name:     "access_token",
-		Value:    newAccess,
-		Expires:  time.Now().Add(time.Minute * 15),
-		HTTPOnly: true,
-		Secure:   isProduction,
-		Domain:   domain,
-		SameSite: sameSite,
-	}
-
-	c.Cookie(&cookie)
-
-	if !isProduction {
-		h.logger.Info(fmt.Sprintf("New Access token is: %v", newAccess))
-	}
+	c.Cookie(h.cookies.AccessToken(newAccess, time.Now().Add(h.tokens.AccessTTL())))
+	metrics.RefreshTokens.WithLabelValues("rotated").Inc()
 
 	return c.JSON(fiber.Map{
 		"access_token":  newAccess,
@@ -195,23 +169,18 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-	if err := c.BodyParser(&req); err != nil {
-		h.logger.Warn(fmt.Sprintf("Failed to parse register request: %v", err))
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+		Username string `json:"username" validate:"required,username"`
+		Email    string `json:"email" validate:"required,email,max=255"`
+		Password string `json:"password" validate:"required,min=8,max_bytes=72"` // bcrypt refuses passwords of more than 72 bytes
 	}
-	if req.Username == "" || req.Email == "" || req.Password == "" {
-		h.logger.Warn("Missing fields in register request: username=%v, email=%v", req.Username, req.Email)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing fields"})
+	if err := bind(c, &req); err != nil {
+		h.logger.WarnContext(c.UserContext(), "Invalid register request", "error", err)
+		return err
 	}
 
-	err := h.userService.Register(req.Username, req.Email, req.Password)
+	err := h.userService.Register(c.UserContext(), req.Username, req.Email, req.Password)
 	if err != nil {
-		h.logger.Error("Could not register user %v: %v", req.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not register"})
+		return fmt.Errorf("could not register user %v: %w", req.Username, err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"succes": "User registered"})
@@ -219,19 +188,19 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 
 func (h *AuthHandler) UpdatePassword(c *fiber.Ctx) error {
 	var req struct {
-		Password string `json:"password"`
+		Password string `json:"password" validate:"required,min=8,max_bytes=72"` // bcrypt refuses passwords of more than 72 bytes
 	}
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	if err := bind(c, &req); err != nil {
+		return err
 	}
 	userIDVal := c.Locals("userID")
 	userID, ok := userIDVal.(uint)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID"})
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid user ID")
 	}
 
-	if err := h.userService.UpdatePassword(userID, req.Password); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	if err := h.userService.UpdatePassword(c.UserContext(), userID, req.Password); err != nil {
+		return fmt.Errorf("could not update password for userID %v: %w", userID, err)
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"succes": "User password has been updated"})
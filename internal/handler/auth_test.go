@@ -2,6 +2,7 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -14,14 +15,19 @@ import (
 	"testing"
 	"time"
 
+	"github.com/amavis442/til-backend/internal/apperr"
 	"github.com/amavis442/til-backend/internal/auth"
 	"github.com/amavis442/til-backend/internal/config"
 	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/metrics"
 	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/queue"
 	"github.com/amavis442/til-backend/internal/user"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -31,79 +37,182 @@ type mockUserService struct {
 	RegisterFunc            func(username, email, password string) error
 	UserExistsFunc          func(userID uint) (bool, error)
 	UpdatePasswordFunc      func(userID uint, password string) error
+	FindOrCreateByEmailFunc func(email, username string) (*user.User, error)
+	GetByIDFunc             func(userID uint) (*user.User, error)
+	DeleteAccountFunc       func(userID uint) error
+	UpdateProfileFunc       func(userID uint, update user.ProfileUpdate) (*user.User, error)
+	VerifyEmailFunc         func(token string) error
+	SetRoleFunc             func(userID uint, role string) error
+	SetDisabledFunc         func(userID uint, disabled bool) error
+	PurgeDeletedFunc        func(before time.Time) (int64, error)
+	RequestDeletionCodeFunc func(userID uint) error
+	CheckDeletionCodeFunc   func(userID uint, code string) (bool, error)
 }
 
-func (m *mockUserService) GetByUsername(username string) (*user.User, error) {
+func (m *mockUserService) GetByUsername(ctx context.Context, username string) (*user.User, error) {
 	return m.GetByUsernameFunc(username)
 }
 
-func (m *mockUserService) ValidateCredentials(username, password string) (bool, uint, error) {
+func (m *mockUserService) ValidateCredentials(ctx context.Context, username, password string) (bool, uint, error) {
 	return m.ValidateCredentialsFunc(username, password)
 }
 
-func (m *mockUserService) Register(username, email, password string) error {
+func (m *mockUserService) Register(ctx context.Context, username, email, password string) error {
 	return m.RegisterFunc(username, email, password)
 }
 
-func (m *mockUserService) UserExists(userID uint) (bool, error) {
+func (m *mockUserService) UserExists(ctx context.Context, userID uint) (bool, error) {
 	return m.UserExistsFunc(userID)
 }
 
-func (m *mockUserService) UpdatePassword(userID uint, password string) error {
+func (m *mockUserService) UpdatePassword(ctx context.Context, userID uint, password string) error {
 	return m.UpdatePasswordFunc(userID, password)
 }
 
+func (m *mockUserService) FindOrCreateByEmail(ctx context.Context, email, username string) (*user.User, error) {
+	return m.FindOrCreateByEmailFunc(email, username)
+}
+
+func (m *mockUserService) GetByID(ctx context.Context, userID uint) (*user.User, error) {
+	return m.GetByIDFunc(userID)
+}
+
+func (m *mockUserService) DeleteAccount(ctx context.Context, userID uint) error {
+	return m.DeleteAccountFunc(userID)
+}
+
+func (m *mockUserService) UpdateProfile(ctx context.Context, userID uint, update user.ProfileUpdate) (*user.User, error) {
+	return m.UpdateProfileFunc(userID, update)
+}
+
+func (m *mockUserService) VerifyEmail(ctx context.Context, token string) error {
+	return m.VerifyEmailFunc(token)
+}
+
+func (m *mockUserService) SetRole(ctx context.Context, userID uint, role string) error {
+	return m.SetRoleFunc(userID, role)
+}
+
+func (m *mockUserService) SetDisabled(ctx context.Context, userID uint, disabled bool) error {
+	return m.SetDisabledFunc(userID, disabled)
+}
+
+func (m *mockUserService) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return m.PurgeDeletedFunc(before)
+}
+
+func (m *mockUserService) RequestDeletionCode(ctx context.Context, userID uint) error {
+	return m.RequestDeletionCodeFunc(userID)
+}
+
+func (m *mockUserService) CheckDeletionCode(ctx context.Context, userID uint, code string) (bool, error) {
+	return m.CheckDeletionCodeFunc(userID, code)
+}
+
 type mockUserRepository struct {
 	GetByIDFunc       func(id uint) (user.User, error)
 	UpdateFunc        func(user *user.User) error
 	CreateFunc        func(user *user.User) error
 	GetByUsernameFunc func(username string) (*user.User, error)
+	GetByEmailFunc    func(email string) (*user.User, error)
+	DeleteFunc        func(id uint) error
+	GetByTokenFunc    func(token string) (*user.User, error)
+	PurgeDeletedFunc  func(before time.Time) (int64, error)
 }
 
-func (m *mockUserRepository) GetByID(id uint) (user.User, error) {
+func (m *mockUserRepository) GetByID(ctx context.Context, id uint) (user.User, error) {
 	return m.GetByIDFunc(id)
 }
 
-func (m *mockUserRepository) Update(user *user.User) error {
+func (m *mockUserRepository) Update(ctx context.Context, user *user.User) error {
 	return m.UpdateFunc(user)
 }
 
-func (m *mockUserRepository) Create(user *user.User) error {
+func (m *mockUserRepository) Create(ctx context.Context, user *user.User) error {
 	return m.CreateFunc(user)
 }
 
-func (m *mockUserRepository) GetByUsername(username string) (*user.User, error) {
+func (m *mockUserRepository) GetByUsername(ctx context.Context, username string) (*user.User, error) {
 	return m.GetByUsernameFunc(username)
 }
 
+func (m *mockUserRepository) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	return m.GetByEmailFunc(email)
+}
+
+func (m *mockUserRepository) Delete(ctx context.Context, id uint) error {
+	return m.DeleteFunc(id)
+}
+
+func (m *mockUserRepository) GetByEmailVerificationToken(ctx context.Context, token string) (*user.User, error) {
+	return m.GetByTokenFunc(token)
+}
+
+func (m *mockUserRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return m.PurgeDeletedFunc(before)
+}
+
+func (m *mockUserRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 type mockRefreshTokenService struct {
 	CreateFunc                     func(userID uint, token string) error
 	FindRefreshTokenByUserIDFunc   func(userID uint) (*auth.RefreshToken, error)
 	DeleteRefreshTokenFunc         func(token string) error
 	DeleteRefreshTokenByUserIDFunc func(userID uint) error
+	FindRefreshTokensByUserIDFunc  func(userID uint) ([]auth.RefreshToken, error)
+	PurgeDeletedRefreshTokensFunc  func(before time.Time) (int64, error)
+	PurgeExpiredRefreshTokensFunc  func(before time.Time) (int64, error)
 }
 
-func (m *mockRefreshTokenService) SaveRefreshToken(userID uint, token string) error {
+func (m *mockRefreshTokenService) SaveRefreshToken(ctx context.Context, userID uint, token string) error {
 	return m.CreateFunc(userID, token)
 }
 
-func (m *mockRefreshTokenService) FindRefreshTokenByUserID(userID uint) (*auth.RefreshToken, error) {
+func (m *mockRefreshTokenService) FindRefreshTokenByUserID(ctx context.Context, userID uint) (*auth.RefreshToken, error) {
 	return m.FindRefreshTokenByUserIDFunc(userID)
 }
 
-func (m *mockRefreshTokenService) DeleteRefreshToken(token string) error {
+func (m *mockRefreshTokenService) DeleteRefreshToken(ctx context.Context, token string) error {
 	return m.DeleteRefreshTokenFunc(token)
 }
 
-func (m *mockRefreshTokenService) DeleteRefreshTokenByUserID(userID uint) error {
+func (m *mockRefreshTokenService) DeleteRefreshTokenByUserID(ctx context.Context, userID uint) error {
 	return m.DeleteRefreshTokenByUserIDFunc(userID)
 }
 
-func TestMain(m *testing.M) {
-	root := "../../"
-	config.Load()
+func (m *mockRefreshTokenService) FindRefreshTokensByUserID(ctx context.Context, userID uint) ([]auth.RefreshToken, error) {
+	return m.FindRefreshTokensByUserIDFunc(userID)
+}
+
+func (m *mockRefreshTokenService) PurgeDeletedRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	return m.PurgeDeletedRefreshTokensFunc(before)
+}
 
-	if err := auth.InitJWTKeys(root); err != nil {
+func (m *mockRefreshTokenService) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	return m.PurgeExpiredRefreshTokensFunc(before)
+}
+
+var testCookies = handler.NewCookieBuilder(config.CookieConfig{
+	Name:     "access_token",
+	Domain:   "til.example.com",
+	Path:     "/",
+	SameSite: "Strict",
+	Secure:   true,
+})
+
+var testTokens *auth.Tokens
+
+func TestMain(m *testing.M) {
+	var err error
+	testTokens, err = auth.NewTokens(config.JWTConfig{
+		PrivateKeyPath:  "../../config/jwt/private.pem",
+		PublicKeyPath:   "../../config/jwt/public.pem",
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+	})
+	if err != nil {
 		log.Fatalf("failed to load keys: %v", err)
 	}
 
@@ -111,10 +220,6 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestEnvLoaded(t *testing.T) {
-	t.Log("JWT_PRIVATE_KEY_PATH =", os.Getenv("JWT_PRIVATE_KEY_PATH"))
-}
-
 func TestLoginHandler(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -147,11 +252,20 @@ func TestLoginHandler(t *testing.T) {
 			expectAccessToken:  false,
 			expectRefreshToken: false,
 		},
+		{
+			name:               "disabled account",
+			username:           "admin",
+			password:           "secret",
+			mockReturnErr:      apperr.Forbidden("This account is disabled", user.ErrDisabled),
+			expectedStatus:     http.StatusForbidden,
+			expectAccessToken:  false,
+			expectRefreshToken: false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			app := fiber.New()
+			app := newTestApp(t)
 
 			mockSvc := &mockUserService{
 				ValidateCredentialsFunc: func(username, password string) (bool, uint, error) {
@@ -172,14 +286,14 @@ func TestLoginHandler(t *testing.T) {
 			}
 
 			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-			h := handler.NewAuthHandler(mockSvc, mockRefreshTokenSvc, logger)
-			app.Post("/login", h.Login)
+			h := handler.NewAuthHandler(mockSvc, mockRefreshTokenSvc, testTokens, testCookies, logger)
+			app.Post("/auth/login", h.Login)
 
 			body, _ := json.Marshal(map[string]string{
 				"username": tt.username,
 				"password": tt.password,
 			})
-			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
 			req.Header.Set("Content-Type", "application/json")
 
 			resp, err := app.Test(req)
@@ -202,12 +316,50 @@ func TestLoginHandler(t *testing.T) {
 			if !tt.expectAccessToken && respBody["access_token"] != "" {
 				t.Error("Did not expect access_token in response")
 			}
+			if tt.expectAccessToken {
+				var cookie *http.Cookie
+				for _, c := range resp.Cookies() {
+					if c.Name == "access_token" {
+						cookie = c
+					}
+				}
+				if assert.NotNil(t, cookie, "Expected access_token cookie") {
+					assert.Equal(t, respBody["access_token"], cookie.Value)
+					assert.Equal(t, "til.example.com", cookie.Domain)
+					assert.Equal(t, "/", cookie.Path)
+					assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+					assert.True(t, cookie.Secure)
+					assert.True(t, cookie.HttpOnly)
+				}
+			}
 		})
 	}
 }
 
+func TestLoginHandler_ErrorIsNotAFailedLogin(t *testing.T) {
+	app := newTestApp(t)
+	mockSvc := &mockUserService{
+		ValidateCredentialsFunc: func(username, password string) (bool, uint, error) {
+			return false, 0, errors.New("connection refused")
+		},
+	}
+	h := handler.NewAuthHandler(mockSvc, &mockRefreshTokenService{}, testTokens, testCookies, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	app.Post("/auth/login", h.Login)
+	failures := metrics.Logins.WithLabelValues("password", "failure")
+	before := testutil.ToFloat64(failures)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"admin","password":"secret"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get(fiber.HeaderContentType))
+	assert.Equal(t, before, testutil.ToFloat64(failures), "the credentials were not wrong")
+}
+
 func TestRefreshTokenHandler(t *testing.T) {
-	app := fiber.New()
+	app := newTestApp(t)
 
 	// Prepare mock service (not used in refresh handler, but required by constructor)
 	mockSvc := &mockUserService{
@@ -219,7 +371,7 @@ func TestRefreshTokenHandler(t *testing.T) {
 
 	mockRefreshTokenSvc := &mockRefreshTokenService{
 		FindRefreshTokenByUserIDFunc: func(userID uint) (*auth.RefreshToken, error) {
-			token, err := auth.GenerateRefreshToken(userID)
+			token, err := testTokens.GenerateRefreshToken(userID)
 			if err != nil {
 				t.Fatalf("Failed to generate refresh token: %v", err)
 			}
@@ -241,12 +393,12 @@ func TestRefreshTokenHandler(t *testing.T) {
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
-	h := handler.NewAuthHandler(mockSvc, mockRefreshTokenSvc, logger)
-	app.Post("/refresh", h.RefreshToken)
+	h := handler.NewAuthHandler(mockSvc, mockRefreshTokenSvc, testTokens, testCookies, logger)
+	app.Post("/auth/refresh-token", h.RefreshToken)
 
 	// Generate valid refresh token
 	//userID := uint(42)
-	_, refreshToken, err := auth.GenerateTokens(userID)
+	_, refreshToken, err := testTokens.Generate(userID)
 	if err != nil {
 		t.Fatalf("Failed to generate refresh token: %v", err)
 	}
@@ -273,12 +425,12 @@ func TestRefreshTokenHandler(t *testing.T) {
 		{
 			name:           "empty token",
 			refreshToken:   "",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusUnprocessableEntity,
 		},
 		{
 			name: "non-refresh type token",
 			refreshToken: func() string {
-				access, _, _ := auth.GenerateTokens(userID)
+				access, _, _ := testTokens.Generate(userID)
 				return access // returns an access token, not refresh
 			}(),
 			expectedStatus: http.StatusUnauthorized,
@@ -290,7 +442,7 @@ func TestRefreshTokenHandler(t *testing.T) {
 			body, _ := json.Marshal(map[string]string{
 				"refresh_token": tt.refreshToken,
 			})
-			req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body))
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh-token", bytes.NewReader(body))
 			req.Header.Set("Content-Type", "application/json")
 
 			resp, err := app.Test(req)
@@ -346,8 +498,70 @@ func TestRegisterHandler(t *testing.T) {
 					return nil
 				}
 			},
+			expectedStatusCode: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name: "invalid email and username",
+			body: `{"username":"no spaces", "email":"not-an-email", "password":"password123"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error {
+					t.Error("Register should not be called on invalid input")
+					return nil
+				}
+			},
+			expectedStatusCode: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name: "short password",
+			body: `{"username":"newuser", "email":"new@example.com", "password":"short"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error {
+					t.Error("Register should not be called on invalid input")
+					return nil
+				}
+			},
+			expectedStatusCode: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name: "multibyte password within 72 bytes",
+			body: `{"username":"newuser", "email":"new@example.com", "password":"` + strings.Repeat("é", 36) + `"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error { return nil }
+			},
+			expectedStatusCode: fiber.StatusCreated,
+		},
+		{
+			name: "multibyte password over 72 bytes",
+			body: `{"username":"newuser", "email":"new@example.com", "password":"` + strings.Repeat("é", 37) + `"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error {
+					t.Error("Register should not be called with a password bcrypt refuses")
+					return nil
+				}
+			},
+			expectedStatusCode: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name: "unknown field",
+			body: `{"username":"newuser", "email":"new@example.com", "password":"password123", "role":"ROLE_ADMIN"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error {
+					t.Error("Register should not be called on invalid input")
+					return nil
+				}
+			},
 			expectedStatusCode: fiber.StatusBadRequest,
 		},
+		{
+			name: "username taken",
+			body: `{"username":"taken", "email":"taken@example.com", "password":"password123"}`,
+			setupMock: func(svc *mockUserService) {
+				svc.RegisterFunc = func(username, email, password string) error {
+					return apperr.Conflict("Username is already taken", user.ErrUsernameTaken)
+				}
+			},
+			expectedStatusCode: fiber.StatusConflict,
+		},
 		{
 			name: "internal error",
 			body: `{"username":"failuser", "email":"fail@example.com", "password":"password123"}`,
@@ -362,7 +576,7 @@ func TestRegisterHandler(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			app := fiber.New()
+			app := newTestApp(t)
 			mockSvc := &mockUserService{}
 			tc.setupMock(mockSvc)
 
@@ -370,11 +584,11 @@ func TestRegisterHandler(t *testing.T) {
 
 			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
-			handler := handler.NewAuthHandler(mockSvc, mockRefeshTokenSvc, logger)
+			handler := handler.NewAuthHandler(mockSvc, mockRefeshTokenSvc, testTokens, testCookies, logger)
 
-			app.Post("/register", handler.Register)
+			app.Post("/auth/register", handler.Register)
 
-			req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(tc.body))
+			req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(tc.body))
 			req.Header.Set("Content-Type", "application/json")
 
 			resp, err := app.Test(req)
@@ -399,7 +613,7 @@ func (m *mockTokenVerifier) ExtractUserID(claims jwt.MapClaims) (uint, error) {
 }
 
 func TestUpdatePasswordHandler_WithAuth(t *testing.T) {
-	app := fiber.New()
+	app := newTestApp(t)
 
 	mockRepo := &mockUserRepository{
 		GetByIDFunc: func(id uint) (user.User, error) {
@@ -412,20 +626,30 @@ func TestUpdatePasswordHandler_WithAuth(t *testing.T) {
 		},
 	}
 
-	svc := user.NewService(mockRepo)
+	svc := user.NewService(mockRepo, queue.NewMemoryQueue())
 	mockRefreshTokenSvc := &mockRefreshTokenService{}
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	handler := handler.NewAuthHandler(svc, mockRefreshTokenSvc, logger)
+	handler := handler.NewAuthHandler(svc, mockRefreshTokenSvc, testTokens, testCookies, logger)
 
 	verifier := &mockTokenVerifier{}
-	app.Post("/api/change-password", middleware.AuthMiddleware(verifier), handler.UpdatePassword)
+	app.Post("/api/change-password", middleware.AuthMiddleware(verifier, "access_token"), handler.UpdatePassword)
+
+	send := func(body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/api/change-password", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
 
-	body := `{"password":"newpassword123"}`
-	req := httptest.NewRequest(http.MethodPost, "/api/change-password", strings.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer dummy-token")
+	assert.Equal(t, fiber.StatusCreated, send(`{"password":"newpassword123"}`).StatusCode)
+	assert.Equal(t, fiber.StatusCreated, send(`{"password":"`+strings.Repeat("ü", 36)+`"}`).StatusCode)
 
-	resp, err := app.Test(req)
-	assert.NoError(t, err)
-	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	// 40 characters, but 80 bytes: more than bcrypt takes
+	resp := send(`{"password":"` + strings.Repeat("ü", 40) + `"}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+	var problem map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, "max_bytes", problem["errors"].([]any)[0].(map[string]any)["code"])
 }
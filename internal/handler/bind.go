@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// decode reads the JSON body into v. Unknown fields and anything after the
+// JSON value are rejected, so typos in field names do not go unnoticed.
+func decode(c *fiber.Ctx, v any) error {
+	return decodeJSON(c.Body(), v)
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fiber.NewError(fiber.StatusBadRequest, "Request body is required")
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body: "+err.Error())
+	}
+	if dec.More() {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body: only one JSON value is allowed")
+	}
+	return nil
+}
+
+// bind decodes the JSON body into v and checks it against its `validate` tags.
+func bind(c *fiber.Ctx, v any) error {
+	if err := decode(c, v); err != nil {
+		return err
+	}
+	return check(v)
+}
+
+// check checks v against its `validate` tags.
+func check(v any) error {
+	if fields := validate.Struct(v); len(fields) > 0 {
+		return apperr.Validation(nil, fields...)
+	}
+	return nil
+}
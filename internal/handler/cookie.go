@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CookieBuilder creates the auth cookies from the configured cookie settings,
+// so every handler that sets or clears them uses the same attributes.
+type CookieBuilder struct {
+	cfg config.CookieConfig
+}
+
+func NewCookieBuilder(cfg config.CookieConfig) *CookieBuilder {
+	return &CookieBuilder{cfg: cfg}
+}
+
+// AccessToken returns the cookie that carries the access token until expires.
+func (b *CookieBuilder) AccessToken(token string, expires time.Time) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     b.cfg.FullName(),
+		Value:    token,
+		Path:     b.cfg.Path,
+		Domain:   b.cfg.Domain,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   b.cfg.Secure,
+		SameSite: b.cfg.SameSite,
+	}
+}
+
+// ClearAccessToken returns a cookie that removes the access token from the browser.
+func (b *CookieBuilder) ClearAccessToken() *fiber.Cookie {
+	return b.AccessToken("", time.Unix(0, 0))
+}
+
+// OIDCRequest returns the short-lived cookie that keeps the state, nonce and
+// PKCE verifier of an OIDC login while the browser visits the identity provider.
+// SameSite=Strict would drop it on the redirect back, so Lax is used instead.
+func (b *CookieBuilder) OIDCRequest(value string, expires time.Time) *fiber.Cookie {
+	sameSite := b.cfg.SameSite
+	if sameSite == "Strict" {
+		sameSite = "Lax"
+	}
+
+	name := "oidc_request"
+	if b.cfg.HostPrefix {
+		name = "__Host-" + name
+	}
+
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     b.cfg.Path,
+		Domain:   b.cfg.Domain,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   b.cfg.Secure,
+		SameSite: sameSite,
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProblemContentType is the content type of error responses, see RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of every error response, see RFC 7807.
+type Problem struct {
+	Type      string              `json:"type"`                 // Always about:blank, the status says it all
+	Title     string              `json:"title"`                // Text of the status code
+	Status    int                 `json:"status"`               // HTTP status code
+	Detail    string              `json:"detail,omitempty"`     // Message for the user, never internals
+	Instance  string              `json:"instance,omitempty"`   // Path of the request
+	RequestID string              `json:"request_id,omitempty"` // Id to find the request in the logs
+	Errors    []apperr.FieldError `json:"errors,omitempty"`     // Invalid fields
+}
+
+var statusByKind = []struct {
+	kind   error
+	status int
+}{
+	{apperr.ErrBadRequest, fiber.StatusBadRequest},
+	{apperr.ErrUnauthorized, fiber.StatusUnauthorized},
+	{apperr.ErrForbidden, fiber.StatusForbidden},
+	{apperr.ErrNotFound, fiber.StatusNotFound},
+	{apperr.ErrConflict, fiber.StatusConflict},
+	{apperr.ErrValidation, fiber.StatusUnprocessableEntity},
+	{apperr.ErrPreconditionFailed, fiber.StatusPreconditionFailed},
+}
+
+// ErrorHandler renders the errors returned by handlers as problem details.
+// apperr errors and fiber errors keep their message; anything else is an
+// internal error that is logged with the request id and hidden from the client.
+func ErrorHandler(logger *slog.Logger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		p := problemFor(err)
+		p.Instance = c.Path()
+		p.RequestID, _ = c.Locals("requestid").(string)
+
+		if p.Status >= fiber.StatusInternalServerError {
+			logger.ErrorContext(c.UserContext(), "Request failed", "method", c.Method(), "path", c.Path(), "status", p.Status, "error", err, "request_id", p.RequestID)
+		}
+
+		return c.Status(p.Status).JSON(p, ProblemContentType)
+	}
+}
+
+func problemFor(err error) Problem {
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		for _, s := range statusByKind {
+			if errors.Is(appErr, s.kind) {
+				p := newProblem(s.status, appErr.Detail)
+				p.Errors = appErr.Fields
+				return p
+			}
+		}
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		if fiberErr.Code >= fiber.StatusInternalServerError {
+			return newProblem(fiberErr.Code, "")
+		}
+		return newProblem(fiberErr.Code, fiberErr.Message)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newProblem(fiber.StatusServiceUnavailable, "The request took too long, please try again")
+	}
+
+	return newProblem(fiber.StatusInternalServerError, "")
+}
+
+func newProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
@@ -0,0 +1,164 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestApp returns an app with the error handler and metrics of the server,
+// which checks every request and response against the OpenAPI document.
+func newTestApp(t *testing.T) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: handler.ErrorHandler(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	})
+	app.Use(checkSpec(t, app))
+	app.Use(metrics.Middleware())
+	return app
+}
+
+func TestErrorHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantDetail string
+		wantFields []apperr.FieldError
+	}{
+		{
+			name:       "not found",
+			err:        apperr.NotFound("TIL not found", errors.New("record not found")),
+			wantStatus: http.StatusNotFound,
+			wantDetail: "TIL not found",
+		},
+		{
+			name:       "conflict",
+			err:        apperr.Conflict("Username is already taken", nil),
+			wantStatus: http.StatusConflict,
+			wantDetail: "Username is already taken",
+		},
+		{
+			name:       "forbidden",
+			err:        apperr.Forbidden("You can only change your own TILs", nil),
+			wantStatus: http.StatusForbidden,
+			wantDetail: "You can only change your own TILs",
+		},
+		{
+			name:       "wrapped unauthorized",
+			err:        fmt.Errorf("could not delete account: %w", apperr.Unauthorized("Invalid password", nil)),
+			wantStatus: http.StatusUnauthorized,
+			wantDetail: "Invalid password",
+		},
+		{
+			name:       "validation",
+			err:        apperr.Validation(nil, apperr.FieldError{Field: "title", Message: "is required"}),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantDetail: "Validation failed: title is required",
+			wantFields: []apperr.FieldError{{Field: "title", Message: "is required"}},
+		},
+		{
+			name:       "fiber error",
+			err:        fiber.NewError(fiber.StatusBadRequest, "Invalid ID"),
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "Invalid ID",
+		},
+		{
+			name:       "deadline",
+			err:        fmt.Errorf("could not list TILs: %w", context.DeadlineExceeded),
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "The request took too long, please try again",
+		},
+		{
+			name:       "internal error",
+			err:        errors.New(`pq: relation "tils" does not exist`),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{
+				ErrorHandler: handler.ErrorHandler(slog.New(slog.NewTextHandler(io.Discard, nil))),
+			})
+			app.Get("/fail", func(c *fiber.Ctx) error { return tt.err })
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/fail", nil))
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, handler.ProblemContentType, resp.Header.Get(fiber.HeaderContentType))
+
+			var p handler.Problem
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+			assert.Equal(t, "about:blank", p.Type)
+			assert.Equal(t, http.StatusText(tt.wantStatus), p.Title)
+			assert.Equal(t, tt.wantStatus, p.Status)
+			assert.Equal(t, tt.wantDetail, p.Detail)
+			assert.Equal(t, "/fail", p.Instance)
+			assert.Equal(t, tt.wantFields, p.Errors)
+		})
+	}
+}
+
+func TestErrorHandler_BodyTooLarge(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          handler.ErrorHandler(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		BodyLimit:             16,
+		DisableStartupMessage: true,
+	})
+	app.Post("/echo", func(c *fiber.Ctx) error { return c.Send(c.Body()) })
+
+	// app.Test does not go through the server, which enforces the limit
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	resp, err := http.Post("http://"+ln.Addr().String()+"/echo", "application/json", strings.NewReader(`{"title":"far too long"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, handler.ProblemContentType, resp.Header.Get(fiber.HeaderContentType))
+}
+
+func TestErrorHandler_LogsInternalErrorsWithRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	app := fiber.New(fiber.Config{
+		ErrorHandler: handler.ErrorHandler(slog.New(slog.NewTextHandler(&logs, nil))),
+	})
+	app.Use(requestid.New())
+	app.Get("/fail", func(c *fiber.Ctx) error { return errors.New("secret connection string") })
+	app.Get("/missing", func(c *fiber.Ctx) error { return apperr.NotFound("TIL not found", nil) })
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/fail", nil))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+
+	requestID := resp.Header.Get(fiber.HeaderXRequestID)
+	require.NotEmpty(t, requestID)
+	assert.NotContains(t, string(body), "secret", "internal errors must not leak")
+	assert.Contains(t, string(body), requestID)
+	assert.Contains(t, logs.String(), "secret connection string")
+	assert.Contains(t, logs.String(), requestID)
+
+	logs.Reset()
+	_, err = app.Test(httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.NoError(t, err)
+	assert.Empty(t, logs.String(), "client errors are not logged")
+}
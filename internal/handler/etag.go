@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/gofiber/fiber/v2"
+)
+
+// etag returns the strong entity tag of a record with version.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// notModified reports whether If-None-Match of the request matches version,
+// in which case the client can use its cached copy. Weak tags also match, see
+// RFC 9110 section 13.1.2.
+func notModified(c *fiber.Ctx, version uint) bool {
+	header := c.Get(fiber.HeaderIfNoneMatch)
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition holds the versions in If-Match of a request, nil for "*".
+type precondition []uint
+
+// ifMatch returns the precondition in If-Match of the request. Changes without
+// If-Match are refused, so clients can not overwrite changes they have not
+// seen. Weak and unknown tags never match, see RFC 9110 section 13.1.1.
+func ifMatch(c *fiber.Ctx) (precondition, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" {
+		return nil, fiber.NewError(fiber.StatusPreconditionRequired, "If-Match header with the ETag of the TIL is required")
+	}
+	if header == "*" {
+		return nil, nil
+	}
+	versions := precondition{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 0)
+		if err == nil && version > 0 {
+			versions = append(versions, uint(version))
+		}
+	}
+	return versions, nil
+}
+
+// version returns current when any tag of p matches it, which the change must
+// then still apply to.
+func (p precondition) version(current uint) (uint, error) {
+	if p != nil && !slices.Contains(p, current) {
+		return 0, apperr.PreconditionFailed("The TIL was changed since you read it", nil)
+	}
+	return current, nil
+}
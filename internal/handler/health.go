@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"log/slog"
+
+	"github.com/amavis442/til-backend/internal/buildinfo"
+	"github.com/amavis442/til-backend/internal/health"
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+	logger  *slog.Logger
+}
+
+func NewHealthHandler(checker *health.Checker, slogger *slog.Logger) *HealthHandler {
+	return &HealthHandler{checker: checker, logger: slogger}
+}
+
+// Live tells the orchestrator the process is running. It checks nothing else,
+// so a broken database does not get the server restarted.
+func (h *HealthHandler) Live(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": health.StatusOK})
+}
+
+// Ready runs the checks of the dependencies and answers 503 when one of them
+// fails, so the orchestrator sends no traffic until they pass. Why a check
+// failed is logged, not sent, as anyone can call it.
+func (h *HealthHandler) Ready(c *fiber.Ctx) error {
+	report := h.checker.Run(c.UserContext())
+	if !report.OK() {
+		for name, result := range report.Checks {
+			if result.Status != health.StatusOK {
+				h.logger.ErrorContext(c.UserContext(), "Readiness check failed", "check", name, "error", result.Error)
+			}
+		}
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(report)
+}
+
+// Version sends the build information of the server.
+func (h *HealthHandler) Version(c *fiber.Ctx) error {
+	return c.JSON(buildinfo.Get())
+}
@@ -0,0 +1,67 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	var failing error
+	checker := health.NewChecker(time.Second,
+		health.Check{Name: "database", Run: func(ctx context.Context) error { return failing }},
+	)
+	var logs bytes.Buffer
+	h := handler.NewHealthHandler(checker, slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	app := newTestApp(t)
+	app.Get("/healthz", h.Live)
+	app.Get("/readyz", h.Ready)
+	app.Get("/version", h.Version)
+
+	get := func(path string) (*http.Response, map[string]any) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		var data map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+		return resp, data
+	}
+
+	resp, data := get("/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", data["status"])
+
+	resp, data = get("/readyz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", data["status"])
+
+	failing = dbErr
+	resp, data = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "fail", data["status"])
+	database := data["checks"].(map[string]any)["database"].(map[string]any)
+	assert.Equal(t, "fail", database["status"])
+	assert.NotContains(t, database, "error", "the cause can name hosts and users")
+	assert.Contains(t, logs.String(), `"check":"database","error":"connection refused"`)
+
+	failing = nil
+	resp, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "liveness does not depend on the database")
+
+	resp, data = get("/version")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "dev", data["version"])
+	assert.NotEmpty(t, data["go_version"])
+}
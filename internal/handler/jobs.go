@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/scheduler"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxJobRuns is the most runs GET /api/admin/jobs/:name/runs returns.
+const maxJobRuns = 100
+
+// JobHandler shows administrators the background jobs and their runs.
+type JobHandler struct {
+	scheduler *scheduler.Scheduler
+}
+
+func NewJobHandler(s *scheduler.Scheduler) *JobHandler {
+	return &JobHandler{scheduler: s}
+}
+
+// JobResponse is a background job as sent to the frontend.
+type JobResponse struct {
+	Name      string          `json:"name"`
+	Schedule  string          `json:"schedule"`
+	NextRunAt *time.Time      `json:"next_run_at"`
+	LastRun   *JobRunResponse `json:"last_run"`
+}
+
+// JobRunResponse is one run of a background job as sent to the frontend.
+type JobRunResponse struct {
+	ID          uint       `json:"id"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error"`
+	Instance    string     `json:"instance"`
+}
+
+func newJobRunResponse(r *scheduler.Run) *JobRunResponse {
+	return &JobRunResponse{
+		ID:          r.ID,
+		ScheduledAt: r.ScheduledAt,
+		StartedAt:   r.StartedAt,
+		FinishedAt:  r.FinishedAt,
+		Status:      r.Status,
+		Error:       r.Error,
+		Instance:    r.Instance,
+	}
+}
+
+// List returns every job with its next and last run.
+func (h *JobHandler) List(c *fiber.Ctx) error {
+	jobs, err := h.scheduler.Jobs(c.UserContext())
+	if err != nil {
+		return fmt.Errorf("could not list the jobs: %w", err)
+	}
+
+	out := make([]JobResponse, 0, len(jobs))
+	for _, j := range jobs {
+		resp := JobResponse{Name: j.Name, Schedule: j.Schedule}
+		if !j.NextRunAt.IsZero() {
+			resp.NextRunAt = &j.NextRunAt
+		}
+		if j.LastRun != nil {
+			resp.LastRun = newJobRunResponse(j.LastRun)
+		}
+		out = append(out, resp)
+	}
+	return c.JSON(out)
+}
+
+// Runs returns the last runs of a job, the last scheduled first. The limit
+// query parameter defaults to 20.
+func (h *JobHandler) Runs(c *fiber.Ctx) error {
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	limit = min(limit, maxJobRuns)
+
+	runs, err := h.scheduler.Runs(c.UserContext(), c.Params("name"), limit)
+	if err != nil {
+		return fmt.Errorf("could not list the runs of job %s: %w", c.Params("name"), err)
+	}
+
+	out := make([]*JobRunResponse, 0, len(runs))
+	for i := range runs {
+		out = append(out, newJobRunResponse(&runs[i]))
+	}
+	return c.JSON(out)
+}
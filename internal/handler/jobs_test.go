@@ -0,0 +1,83 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/scheduler"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandler(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	s := scheduler.New(runs, scheduler.NewMemoryLocker(), config.SchedulerConfig{HistoryRetention: time.Hour}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, s.Add("trash_purge", "30 3 * * *", func(ctx context.Context) error {
+		return errors.New("database is gone")
+	}))
+	require.NoError(t, s.Add("weekly_digest", config.ScheduleOff, func(ctx context.Context) error { return nil }))
+	started, err := runs.Start(t.Context(), &scheduler.Run{Job: "trash_purge", ScheduledAt: time.Now().Add(-time.Minute), StartedAt: time.Now(), Status: scheduler.StatusRunning})
+	require.NoError(t, err)
+	require.True(t, started)
+
+	role := user.RoleAdmin
+	users := &mockUserService{GetByIDFunc: func(userID uint) (*user.User, error) {
+		return &user.User{Role: role}, nil
+	}}
+	h := handler.NewJobHandler(s)
+	app := newTestApp(t)
+	admin := app.Group("/api/admin",
+		middleware.AuthMiddleware(&mockTokenVerifier{}, "access_token"),
+		middleware.RequireRole(users, user.RoleAdmin),
+	)
+	admin.Get("/jobs", h.List)
+	admin.Get("/jobs/:name/runs", h.Runs)
+
+	get := func(path string, out any) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		if out != nil {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp
+	}
+
+	var jobs []handler.JobResponse
+	resp := get("/api/admin/jobs", &jobs)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "trash_purge", jobs[0].Name)
+	assert.NotNil(t, jobs[0].NextRunAt)
+	require.NotNil(t, jobs[0].LastRun)
+	assert.Equal(t, scheduler.StatusRunning, jobs[0].LastRun.Status)
+	assert.Nil(t, jobs[0].LastRun.FinishedAt)
+	assert.Equal(t, "off", jobs[1].Schedule)
+	assert.Nil(t, jobs[1].NextRunAt)
+	assert.Nil(t, jobs[1].LastRun)
+
+	var history []handler.JobRunResponse
+	resp = get("/api/admin/jobs/trash_purge/runs?limit=5", &history)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, history, 1)
+
+	resp = get("/api/admin/jobs/unknown/runs", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	role = user.RoleUser
+	resp = get("/api/admin/jobs", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+package handler_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_ScrapeAfterHandlers(t *testing.T) {
+	app, _ := setupTestApp(t, &mockTokenVerifier{})
+
+	_, rotated, err := testTokens.Generate(1)
+	require.NoError(t, err)
+	mockSvc := &mockUserService{
+		ValidateCredentialsFunc: func(username, password string) (bool, uint, error) {
+			return password == "secret", 1, nil
+		},
+	}
+	mockRefreshTokenSvc := &mockRefreshTokenService{
+		CreateFunc:                     func(userID uint, token string) error { return nil },
+		DeleteRefreshTokenByUserIDFunc: func(userID uint) error { return nil },
+		FindRefreshTokenByUserIDFunc: func(userID uint) (*auth.RefreshToken, error) {
+			return &auth.RefreshToken{UserID: userID, Token: "the current token"}, nil
+		},
+	}
+	h := handler.NewAuthHandler(mockSvc, mockRefreshTokenSvc, testTokens, testCookies, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	app.Post("/auth/login", h.Login)
+	app.Post("/auth/refresh-token", h.RefreshToken)
+	app.Get("/metrics", metrics.Handler())
+
+	counters := map[string]prometheus.Counter{
+		"login success":  metrics.Logins.WithLabelValues("password", "success"),
+		"login failure":  metrics.Logins.WithLabelValues("password", "failure"),
+		"refresh reused": metrics.RefreshTokens.WithLabelValues("reused"),
+		"til create":     metrics.TILs.WithLabelValues("create"),
+		"til update":     metrics.TILs.WithLabelValues("update"),
+	}
+	before := map[string]float64{}
+	for name, c := range counters {
+		before[name] = testutil.ToFloat64(c)
+	}
+
+	send := func(method, path, body string, headers ...string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/auth/login", `{"username":"admin","password":"secret"}`))
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/auth/login", `{"username":"admin","password":"wrong"}`))
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/auth/refresh-token", `{"refresh_token":"`+rotated+`"}`))
+	assert.Equal(t, http.StatusCreated, send(http.MethodPost, "/api/tils", `{"title":"Metrics","content":"content","category":"go"}`))
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/api/tils/1", `{"title":"Metrics","content":"more","category":"go"}`, "If-Match", `"1"`))
+
+	for name, c := range counters {
+		assert.Equal(t, 1.0, testutil.ToFloat64(c)-before[name], name)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	scraped := string(body)
+
+	for _, series := range []string{
+		`til_http_requests_total{method="POST",route="/api/tils",status="201"}`,
+		`til_http_requests_total{method="PUT",route="/api/tils/:id",status="200"}`,
+		`til_http_requests_total{method="POST",route="/auth/login",status="401"}`,
+		`til_http_request_duration_seconds_bucket{method="POST",route="/api/tils",status="201",le="0.005"}`,
+		`til_logins_total{method="password",result="success"}`,
+		`til_logins_total{method="password",result="failure"}`,
+		`til_refresh_tokens_total{result="reused"}`,
+		`til_tils_total{operation="create"}`,
+		`til_tils_total{operation="update"}`,
+	} {
+		assert.Contains(t, scraped, series)
+	}
+}
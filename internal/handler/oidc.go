@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/amavis442/til-backend/internal/oidc"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// oidcRequestTTL is how long the user has to log in at the identity provider.
+const oidcRequestTTL = 10 * time.Minute
+
+// OIDCHandler logs users in through an external OpenID Connect provider and then
+// issues our own access and refresh tokens, just like AuthHandler.Login.
+type OIDCHandler struct {
+	provider *oidc.Provider
+	auth     *AuthHandler
+}
+
+func NewOIDCHandler(provider *oidc.Provider, authHandler *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{
+		provider: provider,
+		auth:     authHandler,
+	}
+}
+
+// Login redirects the browser to the identity provider.
+func (h *OIDCHandler) Login(c *fiber.Ctx) error {
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		return fmt.Errorf("failed to create OIDC auth request: %w", err)
+	}
+
+	value, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode OIDC auth request: %w", err)
+	}
+
+	c.Cookie(h.auth.cookies.OIDCRequest(base64.RawURLEncoding.EncodeToString(value), time.Now().Add(oidcRequestTTL)))
+
+	return c.Redirect(h.provider.AuthCodeURL(req), fiber.StatusFound)
+}
+
+// Callback handles the redirect back from the identity provider. It checks the
+// state, exchanges the code, then links or provisions the user by email.
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	cookie := h.auth.cookies.OIDCRequest("", time.Unix(0, 0))
+	raw := c.Cookies(cookie.Name)
+	c.Cookie(cookie) // The auth request can only be used once
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		h.auth.logger.WarnContext(c.UserContext(), "OIDC login failed at identity provider", "error", idpErr)
+		return fiber.NewError(fiber.StatusUnauthorized, "Login failed at identity provider")
+	}
+
+	req, err := decodeOIDCRequest(raw)
+	if err != nil {
+		h.auth.logger.WarnContext(c.UserContext(), "Invalid OIDC auth request cookie", "error", err)
+		return fiber.NewError(fiber.StatusBadRequest, "Login expired, please try again")
+	}
+
+	state := c.Query("state")
+	if subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		h.auth.logger.WarnContext(c.UserContext(), "OIDC state mismatch")
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid state")
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Missing code")
+	}
+
+	identity, err := h.provider.Exchange(c.UserContext(), code, req)
+	if err != nil {
+		h.auth.logger.WarnContext(c.UserContext(), "OIDC code exchange failed", "error", err)
+		metrics.Logins.WithLabelValues("oidc", "failure").Inc()
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		h.auth.logger.WarnContext(c.UserContext(), "OIDC login without verified email", "subject", identity.Subject)
+		metrics.Logins.WithLabelValues("oidc", "failure").Inc()
+		return fiber.NewError(fiber.StatusForbidden, "A verified email address is required")
+	}
+
+	u, err := h.auth.userService.FindOrCreateByEmail(c.UserContext(), identity.Email, identity.PreferredUsername)
+	if errors.Is(err, user.ErrEmailNotVerified) {
+		h.auth.logger.WarnContext(c.UserContext(), "OIDC login matches a user with an unverified email", "subject", identity.Subject)
+		metrics.Logins.WithLabelValues("oidc", "failure").Inc()
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("could not link OIDC subject %s to a user: %w", identity.Subject, err)
+	}
+	if u.Disabled {
+		h.auth.logger.WarnContext(c.UserContext(), "Disabled user tried to log in", "user_id", u.ID)
+		metrics.Logins.WithLabelValues("oidc", "failure").Inc()
+		return apperr.Forbidden("This account is disabled", user.ErrDisabled)
+	}
+
+	if err := h.auth.login(c, u.ID); err != nil {
+		return err
+	}
+	metrics.Logins.WithLabelValues("oidc", "success").Inc()
+	return nil
+}
+
+func decodeOIDCRequest(raw string) (oidc.AuthRequest, error) {
+	var req oidc.AuthRequest
+	if raw == "" {
+		return req, fmt.Errorf("cookie is missing")
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(value, &req); err != nil {
+		return req, err
+	}
+	if req.State == "" || req.Nonce == "" || req.Verifier == "" {
+		return req, fmt.Errorf("cookie is incomplete")
+	}
+	return req, nil
+}
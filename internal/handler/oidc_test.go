@@ -0,0 +1,374 @@
+package handler_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/oidc"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const (
+	fakeIdPClientID    = "til-backend"
+	fakeIdPRedirectURL = "http://til.example.com/auth/oidc/callback"
+)
+
+// fakeIdP is a minimal OpenID Connect provider: discovery, JWKS, an authorize
+// endpoint that logs in the configured user right away and a token endpoint that
+// checks the PKCE verifier.
+type fakeIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeIdPCode
+
+	email         string
+	emailVerified bool
+	username      string
+	nonceOverride string
+}
+
+type fakeIdPCode struct {
+	nonce     string
+	challenge string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &fakeIdP{
+		key:           key,
+		codes:         map[string]fakeIdPCode{},
+		email:         "alice@example.com",
+		emailVerified: true,
+		username:      "alice",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (f *fakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := f.server.URL
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (f *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := f.key.PublicKey
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeIdPClientID || q.Get("redirect_uri") != fakeIdPRedirectURL ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	f.mu.Lock()
+	f.codes[code] = fakeIdPCode{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	f.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	f.mu.Lock()
+	code, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	nonce := code.nonce
+	if f.nonceOverride != "" {
+		nonce = f.nonceOverride
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                f.server.URL,
+		"sub":                "subject-" + f.username,
+		"aud":                fakeIdPClientID,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              f.email,
+		"email_verified":     f.emailVerified,
+		"preferred_username": f.username,
+	})
+	idToken.Header["kid"] = "test-key"
+	signed, _ := idToken.SignedString(f.key)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "idp-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+type oidcTestSetup struct {
+	app *fiber.App
+	idp *fakeIdP
+	db  *gorm.DB
+}
+
+func setupOIDCTest(t *testing.T) *oidcTestSetup {
+	idp := newFakeIdP(t)
+
+	provider, err := oidc.NewProvider(t.Context(), config.OIDCConfig{
+		IssuerURL:   idp.server.URL,
+		ClientID:    fakeIdPClientID,
+		RedirectURL: fakeIdPRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	})
+	require.NoError(t, err)
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&user.User{}))
+
+	refreshTokenSvc := &mockRefreshTokenService{
+		CreateFunc:                     func(userID uint, token string) error { return nil },
+		DeleteRefreshTokenByUserIDFunc: func(userID uint) error { return nil },
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	authHandler := handler.NewAuthHandler(user.NewService(user.NewRepository(db), queue.NewMemoryQueue()), refreshTokenSvc, testTokens, testCookies, logger)
+	h := handler.NewOIDCHandler(provider, authHandler)
+
+	app := newTestApp(t)
+	app.Get("/auth/oidc/login", h.Login)
+	app.Get("/auth/oidc/callback", h.Callback)
+
+	return &oidcTestSetup{app: app, idp: idp, db: db}
+}
+
+// startLogin calls /auth/oidc/login and returns the auth request cookie and the
+// callback URL the identity provider redirects back to.
+func (s *oidcTestSetup) startLogin(t *testing.T) (*http.Cookie, *url.URL) {
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	var requestCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "oidc_request" {
+			requestCookie = c
+		}
+	}
+	require.NotNil(t, requestCookie)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	idpResp, err := client.Get(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, idpResp.StatusCode)
+
+	callback, err := url.Parse(idpResp.Header.Get("Location"))
+	require.NoError(t, err)
+	return requestCookie, callback
+}
+
+func (s *oidcTestSetup) callback(t *testing.T, cookie *http.Cookie, query string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := s.app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestOIDCLogin_ProvisionsAndLinksUser(t *testing.T) {
+	s := setupOIDCTest(t)
+
+	cookie, callback := s.startLogin(t)
+	resp := s.callback(t, cookie, callback.RawQuery)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEmpty(t, body["refresh_token"])
+
+	var users []user.User
+	require.NoError(t, s.db.Find(&users).Error)
+	require.Len(t, users, 1)
+	assert.Equal(t, "alice", users[0].Username)
+	assert.Equal(t, "alice@example.com", users[0].Email)
+	assert.Equal(t, "ROLE_USER", users[0].Role)
+
+	// A second login with the same email in another case links to the same user
+	s.idp.email = "Alice@Example.com"
+	cookie, callback = s.startLogin(t)
+	resp = s.callback(t, cookie, callback.RawQuery)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, s.db.Find(&users).Error)
+	assert.Len(t, users, 1)
+}
+
+func TestOIDCLogin_UsernameTaken(t *testing.T) {
+	s := setupOIDCTest(t)
+	require.NoError(t, s.db.Create(&user.User{Username: "alice", Email: "other@example.com", PasswordHash: "x"}).Error)
+
+	cookie, callback := s.startLogin(t)
+	resp := s.callback(t, cookie, callback.RawQuery)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var u user.User
+	require.NoError(t, s.db.Where("email = ?", "alice@example.com").First(&u).Error)
+	assert.Equal(t, "alice2", u.Username)
+}
+
+func TestOIDCLogin_UnverifiedEmailNotLinked(t *testing.T) {
+	s := setupOIDCTest(t)
+	// Someone registered with alice's address before she logged in
+	require.NoError(t, s.db.Create(&user.User{Username: "mallory", Email: "alice@example.com", PasswordHash: "x"}).Error)
+
+	cookie, callback := s.startLogin(t)
+	resp := s.callback(t, cookie, callback.RawQuery)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "no login into the unverified account")
+
+	var users []user.User
+	require.NoError(t, s.db.Find(&users).Error)
+	assert.Len(t, users, 1, "no second account with the same email")
+}
+
+func TestOIDCCallback_Rejected(t *testing.T) {
+	tests := []struct {
+		name           string
+		setup          func(s *oidcTestSetup)
+		tamper         func(cookie *http.Cookie, query url.Values) (*http.Cookie, url.Values)
+		expectedStatus int
+	}{
+		{
+			name: "state mismatch",
+			tamper: func(cookie *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				query.Set("state", "forged")
+				return cookie, query
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "missing auth request cookie",
+			tamper: func(cookie *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				return nil, query
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "wrong PKCE verifier",
+			tamper: func(cookie *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				raw, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+				var req oidc.AuthRequest
+				_ = json.Unmarshal(raw, &req)
+				req.Verifier = strings.Repeat("x", 43)
+				raw, _ = json.Marshal(req)
+				cookie.Value = base64.RawURLEncoding.EncodeToString(raw)
+				return cookie, query
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "nonce mismatch",
+			setup:          func(s *oidcTestSetup) { s.idp.nonceOverride = "replayed" },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "email not verified",
+			setup:          func(s *oidcTestSetup) { s.idp.emailVerified = false },
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "error from identity provider",
+			tamper: func(cookie *http.Cookie, query url.Values) (*http.Cookie, url.Values) {
+				return cookie, url.Values{"error": {"access_denied"}}
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := setupOIDCTest(t)
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+
+			cookie, callback := s.startLogin(t)
+			query := callback.Query()
+			if tt.tamper != nil {
+				cookie, query = tt.tamper(cookie, query)
+			}
+
+			resp := s.callback(t, cookie, query.Encode())
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			var count int64
+			s.db.Model(&user.User{}).Count(&count)
+			assert.Zero(t, count, "no user should be provisioned")
+		})
+	}
+}
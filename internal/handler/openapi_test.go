@@ -0,0 +1,84 @@
+package handler_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/openapi"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pb33f/libopenapi"
+	validator "github.com/pb33f/libopenapi-validator"
+	validationerrors "github.com/pb33f/libopenapi-validator/errors"
+)
+
+// specValidator is created once, building it for every test is slow.
+var specValidator = sync.OnceValues(func() (validator.Validator, error) {
+	doc, err := libopenapi.NewDocument(openapi.Document)
+	if err != nil {
+		return nil, err
+	}
+	v, errs := validator.NewValidator(doc)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return v, nil
+})
+
+// checkSpec returns a middleware that fails t when a request or response does
+// not match the OpenAPI document, so the document can not drift from the
+// handlers. Requests are only checked when they succeed, as the tests send
+// invalid requests on purpose.
+func checkSpec(t *testing.T, app *fiber.App) fiber.Handler {
+	v, err := specValidator()
+	if err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+
+	return func(c *fiber.Ctx) error {
+		req := httptest.NewRequest(c.Method(), c.OriginalURL(), bytes.NewReader(bytes.Clone(c.Body())))
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			req.Header.Add(string(key), string(value))
+		})
+
+		// Errors are rendered here, the response is not complete before
+		if err := c.Next(); err != nil {
+			if err := app.Config().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		resp := &http.Response{
+			StatusCode: c.Response().StatusCode(),
+			Header:     http.Header{},
+			Body:       io.NopCloser(bytes.NewReader(c.Response().Body())),
+		}
+		c.Response().Header.VisitAll(func(key, value []byte) {
+			resp.Header.Add(string(key), string(value))
+		})
+
+		if resp.StatusCode < http.StatusBadRequest {
+			if _, errs := v.ValidateHttpRequest(req); len(errs) > 0 {
+				reportSpecErrors(t, fmt.Sprintf("%s %s", c.Method(), c.OriginalURL()), errs)
+			}
+		}
+		if _, errs := v.ValidateHttpResponse(req, resp); len(errs) > 0 {
+			reportSpecErrors(t, fmt.Sprintf("response %d of %s %s", resp.StatusCode, c.Method(), c.OriginalURL()), errs)
+		}
+		return nil
+	}
+}
+
+func reportSpecErrors(t *testing.T, subject string, errs []*validationerrors.ValidationError) {
+	t.Helper()
+	for _, e := range errs {
+		t.Errorf("%s does not match the OpenAPI document: %s: %s", subject, e.Message, e.Reason)
+		for _, f := range e.SchemaValidationErrors {
+			t.Errorf("\t%s: %s", f.FieldPath, f.Reason)
+		}
+	}
+}
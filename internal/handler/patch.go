@@ -0,0 +1,22 @@
+package handler
+
+// mergePatch applies patch to target as a JSON Merge Patch, see RFC 7396: a
+// null removes the member, an object is merged and anything else replaces the
+// member.
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for name, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, name)
+		case map[string]any:
+			current, _ := target[name].(map[string]any)
+			target[name] = mergePatch(current, value)
+		default:
+			target[name] = value
+		}
+	}
+	return target
+}
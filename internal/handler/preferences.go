@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PreferencesHandler struct {
+	service preferences.Service
+	logger  *slog.Logger
+}
+
+func NewPreferencesHandler(s preferences.Service, slogger *slog.Logger) *PreferencesHandler {
+	return &PreferencesHandler{
+		service: s,
+		logger:  slogger,
+	}
+}
+
+// Get returns the preferences of the logged in user, with defaults for anything not saved yet.
+func (h *PreferencesHandler) Get(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	prefs, err := h.service.Get(c.UserContext(), userID)
+	if err != nil {
+		return fmt.Errorf("could not get preferences for userID %v: %w", userID, err)
+	}
+
+	return c.JSON(prefs)
+}
+
+// Put replaces the preferences of the logged in user. Fields that are left out
+// get their default value, unknown fields are rejected.
+func (h *PreferencesHandler) Put(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	prefs := preferences.Defaults()
+	if err := decode(c, &prefs); err != nil {
+		return err
+	}
+
+	saved, err := h.service.Save(c.UserContext(), userID, prefs)
+	if err != nil {
+		return fmt.Errorf("could not save preferences for userID %v: %w", userID, err)
+	}
+
+	return c.JSON(saved)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProfileResponse is the profile of the logged in user as sent to the frontend.
+type ProfileResponse struct {
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	DisplayName   string    `json:"display_name"`
+	AvatarURL     string    `json:"avatar_url"`
+	Timezone      string    `json:"timezone"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func newProfileResponse(u *user.User) ProfileResponse {
+	return ProfileResponse{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		DisplayName:   u.DisplayName,
+		AvatarURL:     u.AvatarURL,
+		Timezone:      u.Timezone,
+		Role:          u.Role,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+	}
+}
+
+type ProfileHandler struct {
+	userService user.Service
+	logger      *slog.Logger
+}
+
+func NewProfileHandler(u user.Service, slogger *slog.Logger) *ProfileHandler {
+	return &ProfileHandler{
+		userService: u,
+		logger:      slogger,
+	}
+}
+
+// Get returns the profile of the logged in user.
+func (h *ProfileHandler) Get(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	u, err := h.userService.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return fmt.Errorf("could not get profile for userID %v: %w", userID, err)
+	}
+
+	return c.JSON(newProfileResponse(u))
+}
+
+// Update changes the fields of the profile that are present in the request.
+func (h *ProfileHandler) Update(c *fiber.Ctx) error {
+	// Validated by the service, which skips the fields that do not change
+	var update user.ProfileUpdate
+	if err := decode(c, &update); err != nil {
+		return err
+	}
+
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	u, err := h.userService.UpdateProfile(c.UserContext(), userID, update)
+	if err != nil {
+		return fmt.Errorf("could not update profile for userID %v: %w", userID, err)
+	}
+
+	return c.JSON(newProfileResponse(u))
+}
+
+// VerifyEmail confirms an email address with the token that was sent to it.
+func (h *ProfileHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := bind(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.userService.VerifyEmail(c.UserContext(), req.Token); err != nil {
+		return fmt.Errorf("could not verify email: %w", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
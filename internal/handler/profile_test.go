@@ -0,0 +1,139 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/handler"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupProfileTest(t *testing.T) (*fiber.App, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&user.User{}))
+	require.NoError(t, db.Create(&user.User{
+		Model:         gorm.Model{ID: 1},
+		Username:      "testuser",
+		Email:         "test@example.com",
+		EmailVerified: true,
+		PasswordHash:  "irrelevant",
+	}).Error)
+	require.NoError(t, db.Create(&user.User{
+		Model:        gorm.Model{ID: 2},
+		Username:     "bob",
+		Email:        "bob@example.com",
+		PasswordHash: "irrelevant",
+	}).Error)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := handler.NewProfileHandler(user.NewService(user.NewRepository(db), queue.NewMemoryQueue()), logger)
+
+	app := newTestApp(t)
+	app.Post("/auth/verify-email", h.VerifyEmail)
+	api := app.Group("/api", middleware.AuthMiddleware(&mockTokenVerifier{}, "access_token"))
+	api.Get("/me", h.Get)
+	api.Patch("/me", h.Update)
+
+	return app, db
+}
+
+func doProfileRequest(t *testing.T, app *fiber.App, method, path, body string) (*http.Response, map[string]any) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer dummy-token")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	var data map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&data)
+	return resp, data
+}
+
+func TestProfileHandler_Get(t *testing.T) {
+	app, _ := setupProfileTest(t)
+
+	resp, data := doProfileRequest(t, app, http.MethodGet, "/api/me", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "testuser", data["username"])
+	assert.Equal(t, "test@example.com", data["email"])
+	assert.Equal(t, "UTC", data["timezone"])
+	assert.NotContains(t, data, "PasswordHash")
+	assert.NotContains(t, data, "password_hash")
+}
+
+func TestProfileHandler_Update(t *testing.T) {
+	app, db := setupProfileTest(t)
+
+	resp, data := doProfileRequest(t, app, http.MethodPatch, "/api/me",
+		`{"display_name":"Test User","timezone":"Europe/Amsterdam","avatar_url":"https://example.com/me.png"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Test User", data["display_name"])
+	assert.Equal(t, "Europe/Amsterdam", data["timezone"])
+	assert.Equal(t, "testuser", data["username"], "fields that are not sent must not change")
+	assert.Equal(t, true, data["email_verified"])
+
+	var u user.User
+	require.NoError(t, db.First(&u, 1).Error)
+	assert.Equal(t, "Test User", u.DisplayName)
+	assert.Equal(t, "https://example.com/me.png", u.AvatarURL)
+}
+
+func TestProfileHandler_UpdateErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"username taken", `{"username":"bob"}`, http.StatusConflict},
+		{"email taken", `{"email":"BOB@example.com"}`, http.StatusConflict},
+		{"invalid username", `{"username":"no spaces allowed"}`, http.StatusUnprocessableEntity},
+		{"invalid email", `{"email":"not-an-email"}`, http.StatusUnprocessableEntity},
+		{"invalid timezone", `{"timezone":"Mars/Olympus_Mons"}`, http.StatusUnprocessableEntity},
+		{"invalid avatar url", `{"avatar_url":"javascript:alert(1)"}`, http.StatusUnprocessableEntity},
+		{"display name too long", `{"display_name":"` + strings.Repeat("é", 101) + `"}`, http.StatusUnprocessableEntity},
+		{"invalid json", `{"username":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := setupProfileTest(t)
+			resp, _ := doProfileRequest(t, app, http.MethodPatch, "/api/me", tt.body)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestProfileHandler_ChangeEmailRequiresVerification(t *testing.T) {
+	app, db := setupProfileTest(t)
+
+	resp, data := doProfileRequest(t, app, http.MethodPatch, "/api/me", `{"email":"new@example.com"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "new@example.com", data["email"])
+	assert.Equal(t, false, data["email_verified"])
+
+	var u user.User
+	require.NoError(t, db.First(&u, 1).Error)
+	require.NotNil(t, u.EmailVerificationToken)
+
+	resp, _ = doProfileRequest(t, app, http.MethodPost, "/auth/verify-email", `{"token":"wrong"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = doProfileRequest(t, app, http.MethodPost, "/auth/verify-email", `{"token":"`+*u.EmailVerificationToken+`"}`)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	_, data = doProfileRequest(t, app, http.MethodGet, "/api/me", "")
+	assert.Equal(t, true, data["email_verified"])
+}
@@ -1,92 +1,162 @@
 package handler
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strconv"
+	"time"
 
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/amavis442/til-backend/internal/preferences"
 	"github.com/amavis442/til-backend/internal/til"
 	"github.com/amavis442/til-backend/internal/user"
 	"github.com/gofiber/fiber/v2"
 )
 
+// TILRequest is the body of POST /api/tils and PUT /api/tils/:id. A PUT
+// replaces every field, so the required fields must always be sent. PATCH
+// /api/tils/:id takes a JSON Merge Patch of a TILRequest instead. The HTML is
+// always rendered from the content by the server, as it is shown to every user.
+type TILRequest struct {
+	Title    string `json:"title" validate:"required,max=255"`
+	Content  string `json:"content" validate:"required"`
+	Category string `json:"category" validate:"required,max=100"`
+}
+
+// toTIL returns the TIL entry of userID with the fields of the request. The
+// id, owner, HTML and timestamps can never be set by the client.
+func (r TILRequest) toTIL(id, userID uint) til.TIL {
+	return til.TIL{
+		ID:       id,
+		Title:    r.Title,
+		Content:  r.Content,
+		Category: r.Category,
+		UserID:   userID,
+	}
+}
+
+// TILResponse is a TIL entry as sent to the frontend.
+type TILResponse struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	HTML      string    `json:"html"`
+	Category  string    `json:"category"`
+	UserID    uint      `json:"user_id"`
+	Version   uint      `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newTILResponse(t *til.TIL) TILResponse {
+	return TILResponse{
+		ID:        t.ID,
+		Title:     t.Title,
+		Content:   t.Content,
+		HTML:      t.HTML,
+		Category:  t.Category,
+		UserID:    t.UserID,
+		Version:   t.Version,
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+	}
+}
+
+func newTILResponses[T til.TIL | *til.TIL](tils []T) []TILResponse {
+	out := make([]TILResponse, 0, len(tils))
+	for i := range tils {
+		switch t := any(tils[i]).(type) {
+		case til.TIL:
+			out = append(out, newTILResponse(&t))
+		case *til.TIL:
+			out = append(out, newTILResponse(t))
+		}
+	}
+	return out
+}
+
 type TilHandler struct {
-	service     til.Service
-	userService user.Service
+	service            til.Service
+	userService        user.Service
+	preferencesService preferences.Service
 }
 
-func NewTilHandler(s til.Service, u user.Service) *TilHandler {
+func NewTilHandler(s til.Service, u user.Service, p preferences.Service) *TilHandler {
 	return &TilHandler{
-		service:     s,
-		userService: u,
+		service:            s,
+		userService:        u,
+		preferencesService: p,
 	}
 }
 
 func (h *TilHandler) List(c *fiber.Ctx) error {
 
-	fmt.Println("Get the list")
-
-	limitParam := c.Query("limit", "10")
+	defaultLimit := h.pageSize(c)
+	limitParam := c.Query("limit", strconv.Itoa(defaultLimit))
 	offsetParam := c.Query("offset", "0")
 
 	limit, err := strconv.Atoi(limitParam)
 	if err != nil || limit <= 0 {
-		limit = 10
+		limit = defaultLimit
 	}
 	offset, err := strconv.Atoi(offsetParam)
 	if err != nil || offset < 0 {
 		offset = 0
 	}
 
-	tils, total, err := h.service.ListWithCount(limit, offset)
+	tils, total, err := h.service.ListWithCount(c.UserContext(), limit, offset)
 	if err != nil {
-		return c.Status(500).JSON(Response[any]{Error: "Failed to fetch TILs"})
+		return fmt.Errorf("could not list TILs: %w", err)
 	}
 
-	slog.Info(fmt.Sprintf("Found %d TILs", len(tils)))
-
 	return c.JSON(
-		Response[[]til.TIL]{
-			Items:  tils,
+		Response[[]TILResponse]{
+			Items:  newTILResponses(tils),
 			Total:  total,
 			Limit:  limit,
 			Offset: offset,
 		})
 }
 
+// pageSize returns the page size from the preferences of the logged in user,
+// or 10 when they can not be loaded.
+func (h *TilHandler) pageSize(c *fiber.Ctx) int {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return 10
+	}
+	prefs, err := h.preferencesService.Get(c.UserContext(), userID)
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "Could not load preferences", "user_id", userID, "error", err)
+		return 10
+	}
+	return prefs.PageSize
+}
+
 // For create function use a JWT cookie with user_id like in the middleware.
 // Extract user_id and verify a user with this user_id exists before
 // adding it to TIL. The middleware stores the userID in c.Locals
 func (h *TilHandler) Create(c *fiber.Ctx) error {
-	var t til.TIL
-	if err := c.BodyParser(&t); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	var req TILRequest
+	if err := bind(c, &req); err != nil {
+		return err
 	}
 	userID, ok := c.Locals("userID").(uint)
 	if !ok {
-		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+		return fiber.ErrUnauthorized
 	}
-	exists, err := h.userService.UserExists(userID)
+	exists, err := h.userService.UserExists(c.UserContext(), userID)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		return fmt.Errorf("could not look up userID %v: %w", userID, err)
 	}
 	if !exists {
-		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
 	}
-	t.UserID = userID
-	if err := t.Validate(); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
-	}
-	if err := h.service.Create(t); err != nil {
-		if errors.Is(err, til.ErrValidation) {
-			return c.Status(422).JSON(fiber.Map{"error": "Validation failed", "details": err.Error()})
-		}
-		if errors.Is(err, til.ErrDuplicate) {
-			return c.Status(409).JSON(fiber.Map{"error": "Duplicate entry"})
-		}
-		return c.Status(500).JSON(fiber.Map{"error": "Database error", "details": err.Error()})
+	if err := h.service.Create(c.UserContext(), req.toTIL(0, userID)); err != nil {
+		return err
 	}
+	metrics.TILs.WithLabelValues("create").Inc()
 	return c.SendStatus(201)
 }
 
@@ -94,55 +164,153 @@ func (h *TilHandler) GetByID(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 	}
 
-	til, err := h.service.GetByID(uint(id))
+	til, err := h.service.GetByID(c.UserContext(), uint(id))
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "TIL not found"})
+		return err
 	}
 
-	return c.JSON(til)
+	c.Set(fiber.HeaderETag, etag(til.Version))
+	if notModified(c, til.Version) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.JSON(newTILResponse(&til))
 }
 
 func (h *TilHandler) Update(c *fiber.Ctx) error {
 	idParam := c.Params("id")
 	id, err := strconv.Atoi(idParam)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid ID"})
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+	}
+
+	var req TILRequest
+	if err := bind(c, &req); err != nil {
+		return err
+	}
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	precondition, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	existing, err := h.service.GetByID(c.UserContext(), uint(id))
+	if err != nil {
+		return err
+	}
+	version, err := precondition.version(existing.Version)
+	if err != nil {
+		return err
+	}
+
+	// The ID comes from the URL and only the owner can update
+	t := req.toTIL(uint(id), userID)
+	t.Version = version
+	updatedTIL, err := h.service.Update(c.UserContext(), t)
+	if err != nil {
+		return err
+	}
+
+	metrics.TILs.WithLabelValues("update").Inc()
+	c.Set(fiber.HeaderETag, etag(updatedTIL.Version))
+	return c.JSON(newTILResponse(&updatedTIL))
+}
+
+// Patch changes only the fields in the JSON Merge Patch of the body, see RFC
+// 7396. A null clears the field, which fails for the required fields.
+func (h *TilHandler) Patch(c *fiber.Ctx) error {
+	idParam := c.Params("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 	}
 
-	var til til.TIL
-	if err := c.BodyParser(&til); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	var patch map[string]any
+	if err := decode(c, &patch); err != nil {
+		return err
+	}
+	if patch == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body: a JSON object is required")
+	}
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	precondition, err := ifMatch(c)
+	if err != nil {
+		return err
 	}
 
-	til.ID = uint(id) // ensure ID matches URL
+	existing, err := h.service.GetByID(c.UserContext(), uint(id))
+	if err != nil {
+		return err
+	}
+	// Even If-Match: * must not overwrite changes made after this read
+	version, err := precondition.version(existing.Version)
+	if err != nil {
+		return err
+	}
 
-	updatedTIL, err := h.service.Update(til)
+	req, err := applyTILPatch(existing, patch)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return err
+	}
+	t := req.toTIL(uint(id), userID)
+	t.Version = version
+	updatedTIL, err := h.service.Update(c.UserContext(), t)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(updatedTIL)
+	metrics.TILs.WithLabelValues("update").Inc()
+	c.Set(fiber.HeaderETag, etag(updatedTIL.Version))
+	return c.JSON(newTILResponse(&updatedTIL))
+}
+
+// applyTILPatch returns the TILRequest of existing with patch applied.
+func applyTILPatch(existing til.TIL, patch map[string]any) (TILRequest, error) {
+	current := TILRequest{
+		Title:    existing.Title,
+		Content:  existing.Content,
+		Category: existing.Category,
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return TILRequest{}, fmt.Errorf("could not encode TIL %v: %w", existing.ID, err)
+	}
+	var target map[string]any
+	if err := json.Unmarshal(data, &target); err != nil {
+		return TILRequest{}, fmt.Errorf("could not decode TIL %v: %w", existing.ID, err)
+	}
+	if data, err = json.Marshal(mergePatch(target, patch)); err != nil {
+		return TILRequest{}, fmt.Errorf("could not encode patched TIL %v: %w", existing.ID, err)
+	}
+
+	var req TILRequest
+	if err := decodeJSON(data, &req); err != nil {
+		return TILRequest{}, err
+	}
+	return req, check(&req)
 }
 
 func (h *TilHandler) Search(c *fiber.Ctx) error {
 	type SearchRequest struct {
-		Title    string `json:"title"`
-		Category string `json:"category"`
+		Title    string `json:"title" validate:"max=255"`
+		Category string `json:"category" validate:"max=100"`
 	}
 
 	var req SearchRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+	if err := bind(c, &req); err != nil {
+		return err
 	}
 
-	tils, err := h.service.Search(req.Title, req.Category)
+	tils, err := h.service.Search(c.UserContext(), req.Title, req.Category)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return fmt.Errorf("could not search TILs: %w", err)
 	}
-	return c.JSON(tils)
+	return c.JSON(newTILResponses(tils))
 }
@@ -4,29 +4,42 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/amavis442/til-backend/internal/apperr"
 	"github.com/amavis442/til-backend/internal/auth"
 	"github.com/amavis442/til-backend/internal/handler"
 	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/preferences"
+	"github.com/amavis442/til-backend/internal/queue"
 	"github.com/amavis442/til-backend/internal/til"
 	"github.com/amavis442/til-backend/internal/user"
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
 
-func setupTestApp(verifier auth.TokenVerifier) (*fiber.App, *gorm.DB) {
-	db, err := gorm.Open(sqlite.Open("file:testdb?mode=memory&cache=shared"), &gorm.Config{})
+func setupTestApp(t *testing.T, verifier auth.TokenVerifier) (*fiber.App, *gorm.DB) {
+	app, db, _ := setupTestAppWithQueue(t, verifier)
+	return app, db
+}
+
+// setupTestAppWithQueue is setupTestApp that also returns the queue of the
+// TIL service, to run the jobs it enqueued.
+func setupTestAppWithQueue(t *testing.T, verifier auth.TokenVerifier) (*fiber.App, *gorm.DB, *queue.MemoryQueue) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&user.User{}, &til.TIL{})
+	db.AutoMigrate(&user.User{}, &til.TIL{}, &preferences.UserPreferences{})
 	db.Create(&user.User{
 		Model:        gorm.Model{ID: 1},
 		Username:     "testuser",
@@ -35,24 +48,32 @@ func setupTestApp(verifier auth.TokenVerifier) (*fiber.App, *gorm.DB) {
 
 	userRepo := user.NewRepository(db)
 	repo := til.NewRepository(db)
-	uc := til.NewService(repo)
-	userService := user.NewService(userRepo)
-	h := handler.NewTilHandler(uc, userService)
+	jobs := queue.NewMemoryQueue()
+	uc := til.NewService(repo, jobs)
+	userService := user.NewService(userRepo, queue.NewMemoryQueue())
+	preferencesService := preferences.NewService(preferences.NewRepository(db))
+	h := handler.NewTilHandler(uc, userService, preferencesService)
+	ph := handler.NewPreferencesHandler(preferencesService, slog.New(slog.NewTextHandler(io.Discard, nil)))
 
-	app := fiber.New()
-	api := app.Group("/api", middleware.AuthMiddleware(verifier))
+	app := newTestApp(t)
+	api := app.Group("/api", middleware.AuthMiddleware(verifier, "access_token"))
 	api.Get("/tils", h.List)
 	api.Post("/tils", h.Create)
+	api.Get("/tils/:id", h.GetByID)
+	api.Put("/tils/:id", h.Update)
+	api.Patch("/tils/:id", h.Patch)
+	api.Get("/me/preferences", ph.Get)
+	api.Put("/me/preferences", ph.Put)
 
-	return app, db
+	return app, db, jobs
 }
 
 func TestCreateAndListTIL(t *testing.T) {
 	verifier := &mockTokenVerifier{}
-	app, _ := setupTestApp(verifier)
+	app, _ := setupTestApp(t, verifier)
 
 	// Step 1: Create a TIL via POST
-	input := til.TIL{
+	input := handler.TILRequest{
 		Title:    "TIL Go tests are fun",
 		Content:  "Writing tests with Fiber and GORM",
 		Category: "golang",
@@ -80,8 +101,8 @@ func TestCreateAndListTIL(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	var tils []til.TIL
-	var respBody handler.Response[[]til.TIL]
+	var tils []handler.TILResponse
+	var respBody handler.Response[[]handler.TILResponse]
 	err = json.NewDecoder(resp.Body).Decode(&respBody)
 	assert.NoError(t, err)
 
@@ -96,3 +117,317 @@ func TestCreateAndListTIL(t *testing.T) {
 		assert.WithinDuration(t, time.Now(), tils[0].CreatedAt, time.Second)
 	}
 }
+
+func TestListTIL_UsesPreferencePageSize(t *testing.T) {
+	app, _ := setupTestApp(t, &mockTokenVerifier{})
+
+	send := func(method, path, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	for _, title := range []string{"First", "Second", "Third"} {
+		resp := send(http.MethodPost, "/api/tils", `{"title":"`+title+`","content":"content","category":"golang"}`)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp := send(http.MethodPut, "/api/me/preferences", `{"page_size":2}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var respBody handler.Response[[]handler.TILResponse]
+	resp = send(http.MethodGet, "/api/tils", "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&respBody))
+	assert.Len(t, respBody.Items, 2)
+	assert.Equal(t, 2, respBody.Limit)
+	assert.Equal(t, int64(3), respBody.Total)
+
+	resp = send(http.MethodGet, "/api/tils?limit=5", "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&respBody))
+	assert.Len(t, respBody.Items, 3, "an explicit limit wins over the preference")
+}
+
+func TestTilHandler_Errors(t *testing.T) {
+	app, db := setupTestApp(t, &mockTokenVerifier{})
+	db.Create(&user.User{Model: gorm.Model{ID: 2}, Username: "bob", PasswordHash: "irrelevant"})
+	db.Create(&til.TIL{ID: 7, Title: "Bobs TIL", Content: "content", Category: "golang", UserID: 2})
+
+	send := func(method, path, body string) (*http.Response, handler.Problem) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		req.Header.Set("If-Match", "*") // Preconditions are tested in TestTilHandler_Preconditions
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var p handler.Problem
+		_ = json.NewDecoder(resp.Body).Decode(&p)
+		return resp, p
+	}
+
+	resp, p := send(http.MethodGet, "/api/tils/999", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, handler.ProblemContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "TIL not found", p.Detail)
+
+	resp, _ = send(http.MethodGet, "/api/tils/abc", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = send(http.MethodPut, "/api/tils/7", `{"title":"Mine now","content":"content","category":"golang"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "user 1 can not change the TIL of user 2")
+	var stored til.TIL
+	db.First(&stored, 7)
+	assert.Equal(t, "Bobs TIL", stored.Title)
+
+	resp, p = send(http.MethodPost, "/api/tils", `{"title":"","content":"","category":"golang"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, []apperr.FieldError{
+		{Field: "title", Code: "required", Message: "is required"},
+		{Field: "content", Code: "required", Message: "is required"},
+	}, p.Errors)
+
+	resp, _ = send(http.MethodPost, "/api/tils", `{"title":"Bobs TIL","content":"again","category":"golang"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, _ = send(http.MethodPost, "/api/tils", `{"title":"`+strings.Repeat("é", 255)+`","content":"content","category":"golang"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "the title length counts characters, not bytes")
+
+	resp, p = send(http.MethodPost, "/api/tils", `{"title":"`+strings.Repeat("é", 256)+`","content":"content","category":"golang"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, []apperr.FieldError{{Field: "title", Code: "max", Message: "must be at most 255 characters"}}, p.Errors)
+
+	resp, _ = send(http.MethodPost, "/api/tils", `{"title":"Typo","content":"content","categroy":"golang"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown fields are rejected")
+
+	resp, _ = send(http.MethodPost, "/api/tils", `{"title":"Two","content":"content","category":"golang"} {}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "only one JSON value is allowed")
+}
+
+func TestTilHandler_MassAssignment(t *testing.T) {
+	app, db := setupTestApp(t, &mockTokenVerifier{})
+	db.Create(&user.User{Model: gorm.Model{ID: 2}, Username: "bob", PasswordHash: "irrelevant"})
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	db.Create(&til.TIL{ID: 7, Title: "Mine", Content: "content", HTML: "<p>content</p>", Category: "golang", UserID: 1, CreatedAt: created})
+
+	send := func(method, path, body string) (*http.Response, map[string]any) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		req.Header.Set("If-Match", "*") // Preconditions are tested in TestTilHandler_Preconditions
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var data map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&data)
+		return resp, data
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"create for another user", http.MethodPost, "/api/tils", `{"title":"Hijack","content":"content","category":"golang","user_id":2}`},
+		{"create with an id", http.MethodPost, "/api/tils", `{"id":7,"title":"Hijack","content":"content","category":"golang"}`},
+		{"create with timestamps", http.MethodPost, "/api/tils", `{"title":"Hijack","content":"content","category":"golang","created_at":"2000-01-01T00:00:00Z"}`},
+		{"update the owner", http.MethodPut, "/api/tils/7", `{"title":"Mine","content":"content","category":"golang","user_id":2}`},
+		{"update the creation time", http.MethodPut, "/api/tils/7", `{"title":"Mine","content":"content","category":"golang","created_at":"2000-01-01T00:00:00Z"}`},
+		{"undelete", http.MethodPut, "/api/tils/7", `{"title":"Mine","content":"content","category":"golang","DeletedAt":null}`},
+		{"create with html", http.MethodPost, "/api/tils", `{"title":"Hijack","content":"content","category":"golang","html":"<script>alert(1)</script>"}`},
+		{"update the html", http.MethodPut, "/api/tils/7", `{"title":"Mine","content":"content","category":"golang","html":"<script>alert(1)</script>"}`},
+		{"patch the html", http.MethodPatch, "/api/tils/7", `{"html":"<script>alert(1)</script>"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := send(tt.method, tt.path, tt.body)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+
+	var count int64
+	db.Model(&til.TIL{}).Where("title = ?", "Hijack").Count(&count)
+	assert.Zero(t, count, "no TIL was created")
+
+	resp, _ := send(http.MethodPut, "/api/tils/7", `{"title":"Renamed"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "a PUT must send every required field")
+
+	resp, data := send(http.MethodPut, "/api/tils/7", `{"title":"Renamed","content":"new content","category":"go"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Renamed", data["title"])
+	assert.Equal(t, float64(1), data["user_id"])
+	assert.Equal(t, created.Format(time.RFC3339), data["created_at"])
+	assert.Contains(t, data, "updated_at")
+	assert.NotContains(t, data, "UpdatedAt")
+	assert.NotContains(t, data, "DeletedAt")
+
+	var stored til.TIL
+	db.First(&stored, 7)
+	assert.Equal(t, uint(1), stored.UserID)
+	assert.True(t, created.Equal(stored.CreatedAt), "created_at changed to %v", stored.CreatedAt)
+	assert.NotContains(t, stored.HTML, "<script>", "the HTML is only rendered by the server")
+}
+
+func TestTilHandler_Preconditions(t *testing.T) {
+	app, db := setupTestApp(t, &mockTokenVerifier{})
+	db.Create(&til.TIL{ID: 7, Title: "Mine", Content: "content", HTML: "<p>content</p>", Category: "golang", UserID: 1})
+
+	send := func(method, body string, headers ...string) (*http.Response, map[string]any) {
+		req := httptest.NewRequest(method, "/api/tils/7", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var data map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&data)
+		return resp, data
+	}
+	full := `{"title":"Renamed","content":"content","category":"golang"}`
+
+	resp, data := send(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, float64(1), data["version"])
+
+	resp, _ = send(http.MethodGet, "", "If-None-Match", `"1"`)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = send(http.MethodGet, "", "If-None-Match", `W/"1"`)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "weak tags match on reads")
+	resp, _ = send(http.MethodGet, "", "If-None-Match", `"0", "2"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = send(http.MethodPut, full)
+	assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	resp, _ = send(http.MethodPatch, `{"title":"Renamed"}`)
+	assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	resp, _ = send(http.MethodPut, full, "If-Match", `W/"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, "weak tags never match on changes")
+
+	// Two tabs read version 1, the second save must not clobber the first
+	resp, data = send(http.MethodPut, full, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	assert.Equal(t, float64(2), data["version"])
+	resp, data = send(http.MethodPatch, `{"content":"other tab"}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, "The TIL was changed since you read it", data["detail"])
+
+	resp, data = send(http.MethodPatch, `{"content":"new content"}`, "If-Match", `"2"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "Renamed", data["title"], "fields missing from the patch are kept")
+	assert.Equal(t, "new content", data["content"])
+
+	resp, data = send(http.MethodPatch, `{"title":null}`, "If-Match", `"3"`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "title", data["errors"].([]any)[0].(map[string]any)["field"])
+	resp, _ = send(http.MethodPatch, `{"user_id":2}`, "If-Match", `"3"`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = send(http.MethodPatch, `["title"]`, "If-Match", `"3"`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = send(http.MethodPatch, `{"category":"go"}`, "If-Match", "*")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"4"`, resp.Header.Get("ETag"))
+
+	// A client that holds several copies sends all their tags
+	resp, _ = send(http.MethodPatch, `{"category":"golang"}`, "If-Match", `"2", W/"4", "3"`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, "no strong tag matches")
+	resp, _ = send(http.MethodPatch, `{"category":"golang"}`, "If-Match", `"2", "4", "3"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "a tag later in the list matches")
+	assert.Equal(t, `"5"`, resp.Header.Get("ETag"))
+	resp, _ = send(http.MethodPut, `{"title":"Renamed","content":"new content","category":"go"}`, "If-Match", `"1","5"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"6"`, resp.Header.Get("ETag"))
+
+	var stored til.TIL
+	db.First(&stored, 7)
+	assert.Equal(t, uint(6), stored.Version)
+	assert.Equal(t, "go", stored.Category)
+	assert.Equal(t, "new content", stored.Content)
+}
+
+func TestTilHandler_PatchRendersContent(t *testing.T) {
+	app, db, jobs := setupTestAppWithQueue(t, &mockTokenVerifier{})
+	db.Create(&til.TIL{ID: 7, Title: "Mine", Content: "content", HTML: "<p>content</p>\n", Category: "golang", UserID: 1})
+
+	patch := func(body, etag string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, "/api/tils/7", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		req.Header.Set("If-Match", etag)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := patch(`{"content":"**new** content"}`, `"1"`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+
+	render := til.RenderHandler(til.NewService(til.NewRepository(db), queue.NewMemoryQueue()))
+	queued := jobs.Jobs(til.RenderJob)
+	require.Len(t, queued, 1, "the new content is rendered")
+	require.NoError(t, render(t.Context(), queued[0]))
+
+	var stored til.TIL
+	require.NoError(t, db.First(&stored, 7).Error)
+	assert.Equal(t, "<p><strong>new</strong> content</p>\n", stored.HTML)
+	assert.Equal(t, uint(2), stored.Version, "rendering is not a change of the TIL")
+
+	resp = patch(`{"content":"newer content"}`, etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the ETag of the edit is still valid after the render")
+}
+
+func TestPreferencesHandler(t *testing.T) {
+	app, _ := setupTestApp(t, &mockTokenVerifier{})
+
+	send := func(method, body string) (*http.Response, map[string]any) {
+		req := httptest.NewRequest(method, "/api/me/preferences", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer dummy-token")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		var data map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&data)
+		return resp, data
+	}
+
+	resp, data := send(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, float64(10), data["page_size"])
+	assert.Equal(t, "markdown", data["editor_mode"])
+
+	resp, data = send(http.MethodPut, `{"editor_mode":"wysiwyg","weekly_digest":true,"timezone":"Europe/Amsterdam"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "wysiwyg", data["editor_mode"])
+	assert.Equal(t, float64(10), data["page_size"], "missing fields get their default")
+
+	_, data = send(http.MethodGet, "")
+	assert.Equal(t, true, data["weekly_digest"])
+	assert.Equal(t, "Europe/Amsterdam", data["timezone"])
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"unknown field", `{"colour":"blue"}`, http.StatusBadRequest},
+		{"page size too large", `{"page_size":1000}`, http.StatusUnprocessableEntity},
+		{"page size zero", `{"page_size":0}`, http.StatusUnprocessableEntity},
+		{"unknown editor mode", `{"editor_mode":"vim"}`, http.StatusUnprocessableEntity},
+		{"unknown theme", `{"markdown_theme":"neon"}`, http.StatusUnprocessableEntity},
+		{"invalid timezone", `{"timezone":"Nowhere/City"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := send(http.MethodPut, tt.body)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Run(t *testing.T) {
+	ok := health.Check{Name: "database", Run: func(ctx context.Context) error { return nil }}
+	broken := health.Check{Name: "jwt_keys", Run: func(ctx context.Context) error { return errors.New("not loaded") }}
+	slow := health.Check{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	report := health.NewChecker(time.Second, ok).Run(t.Context())
+	assert.True(t, report.OK())
+	assert.Equal(t, health.StatusOK, report.Checks["database"].Status)
+
+	report = health.NewChecker(10*time.Millisecond, ok, broken, slow).Run(t.Context())
+	assert.False(t, report.OK())
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Equal(t, health.StatusOK, report.Checks["database"].Status)
+	assert.Equal(t, health.Result{Status: health.StatusFail, Error: "not loaded", Duration: report.Checks["jwt_keys"].Duration}, report.Checks["jwt_keys"])
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error, "every check has a deadline")
+}
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amavis442/til-backend/internal/queue"
+)
+
+// SendJob is the kind of the queued jobs that send a message.
+const SendJob = "mail.send"
+
+// NewSendJob returns a job that sends msg. A message with a key is sent once,
+// however often it is enqueued.
+func NewSendJob(key string, msg Message) (*queue.Job, error) {
+	return queue.NewJob(SendJob, key, msg)
+}
+
+// SendHandler runs the SendJob jobs with m.
+func SendHandler(m Mailer) queue.Handler {
+	return func(ctx context.Context, job *queue.Job) error {
+		var msg Message
+		if err := job.Decode(&msg); err != nil {
+			return err
+		}
+		err := m.Send(ctx, msg)
+		if errors.Is(err, ErrInvalidHeader) {
+			return queue.Permanent(err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mailerFunc func(ctx context.Context, msg Message) error
+
+func (f mailerFunc) Send(ctx context.Context, msg Message) error { return f(ctx, msg) }
+
+func TestSendHandler(t *testing.T) {
+	var sent []Message
+	h := SendHandler(mailerFunc(func(ctx context.Context, msg Message) error {
+		if msg.Subject == "" {
+			return ErrInvalidHeader
+		}
+		sent = append(sent, msg)
+		return nil
+	}))
+
+	msg := Message{To: "ada@example.com", Subject: "Hello", Body: "Hi Ada"}
+	job, err := NewSendJob("hello:1", msg)
+	require.NoError(t, err)
+	assert.Equal(t, SendJob, job.Kind)
+	require.NoError(t, h(t.Context(), job))
+	assert.Equal(t, []Message{msg}, sent)
+
+	job, err = NewSendJob("", Message{To: "ada@example.com"})
+	require.NoError(t, err)
+	assert.True(t, queue.IsPermanent(h(t.Context(), job)), "a header that is invalid now is invalid on the next attempt")
+}
@@ -0,0 +1,111 @@
+// Package mail sends plain text email through an SMTP server, or only logs it
+// when no server is configured.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/tracing"
+)
+
+var ErrInvalidHeader = errors.New("header contains a line break") // ErrInvalidHeader is returned for a recipient or subject that would add headers.
+
+// Message is a plain text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns an SMTPMailer, or a LogMailer when cfg has no SMTP server.
+func New(cfg config.MailConfig, logger *slog.Logger) Mailer {
+	if cfg.SMTPAddr == "" {
+		return &LogMailer{logger: logger}
+	}
+	return NewSMTPMailer(cfg)
+}
+
+// SMTPMailer sends email through an SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	m := &SMTPMailer{addr: cfg.SMTPAddr, from: cfg.From}
+	if cfg.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		m.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	_, span := tracing.Start(ctx, "mail.Send")
+	defer span.End()
+
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("could not send email to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// LogMailer logs the email instead of sending it.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.InfoContext(ctx, "Email not sent, MAIL_SMTP_ADDR is not set", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// format writes msg as a MIME message with a quoted-printable UTF-8 body.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	for _, h := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := w.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)
+	data, err := format("til@example.com", Message{
+		To:      "alice@example.com",
+		Subject: "Your week in TILs ✓",
+		Body:    "Café\nDone",
+	}, date)
+	require.NoError(t, err)
+
+	assert.Equal(t, "From: til@example.com\r\n"+
+		"To: alice@example.com\r\n"+
+		"Subject: =?utf-8?q?Your_week_in_TILs_=E2=9C=93?=\r\n"+
+		"Date: Mon, 16 Mar 2026 08:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n\r\n"+
+		"Caf=C3=A9\r\nDone", string(data))
+}
+
+func TestFormat_RejectsHeaderInjection(t *testing.T) {
+	_, err := format("til@example.com", Message{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "Hi"}, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	_, err = format("til@example.com", Message{To: "alice@example.com", Subject: "Hi\nBcc: mallory@example.com"}, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+}
@@ -0,0 +1,129 @@
+// Package metrics holds the Prometheus metrics of the server. They are
+// registered on Registry, which /metrics exposes together with the Go runtime,
+// process and database pool metrics.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "til"
+
+// Registry holds every metric of the server.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts the requests by method, route template and status.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration measures how long the requests take by method, route
+	// template and status.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the HTTP requests by method, route template and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// Logins counts the logins by method (password or oidc) and result
+	// (success or failure).
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Logins by method and result.",
+	}, []string{"method", "result"})
+
+	// RefreshTokens counts the refresh token requests by result: rotated when a
+	// new pair was issued, reused when a refresh token that was already rotated
+	// was sent again, and invalid for anything else that was refused.
+	RefreshTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_tokens_total",
+		Help:      "Refresh token requests by result.",
+	}, []string{"result"})
+
+	// TILs counts the TIL entries that were created or updated.
+	TILs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tils_total",
+		Help:      "TIL entries by operation.",
+	}, []string{"operation"})
+
+	// JobRuns counts the runs of the background jobs by job and status
+	// (succeeded or failed).
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Runs of the background jobs by job and status.",
+	}, []string{"job", "status"})
+
+	// QueuedJobs counts the attempts of the queued jobs by kind and result
+	// (done, retry or dead).
+	QueuedJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queued_jobs_total",
+		Help:      "Attempts of the queued jobs by kind and result.",
+	}, []string{"kind", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		Logins,
+		RefreshTokens,
+		TILs,
+		JobRuns,
+		QueuedJobs,
+	)
+}
+
+// RegisterDB adds the statistics of the connection pool of db as the go_sql_*
+// metrics with db_name set to name.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the count and duration of every request that passes it.
+// Errors are rendered by the error handler of the app first, so the status is
+// the one sent to the client.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		route := middleware.Finish(c, c.Next())
+		if route == "" {
+			route = "unmatched"
+		}
+		observe(c, route, start)
+		return nil
+	}
+}
+
+func observe(c *fiber.Ctx, route string, start time.Time) {
+	// The method points into a buffer fiber reuses, the metric keeps the label
+	method := utils.CopyString(c.Method())
+	status := strconv.Itoa(c.Response().StatusCode())
+	HTTPRequests.WithLabelValues(method, route, status).Inc()
+	HTTPRequestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+}
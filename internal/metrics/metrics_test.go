@@ -0,0 +1,72 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_LabelsByRouteTemplate(t *testing.T) {
+	app := fiber.New()
+	app.Use(metrics.Middleware())
+	api := app.Group("/api", func(c *fiber.Ctx) error { return c.Next() })
+	api.Get("/things/:id", func(c *fiber.Ctx) error { return c.SendString("thing") })
+	api.Get("/broken", func(c *fiber.Ctx) error { return fiber.ErrBadGateway })
+
+	count := func(method, route, status string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(method, route, status))
+	}
+	before := map[string]float64{
+		"found":     count("GET", "/api/things/:id", "200"),
+		"error":     count("GET", "/api/broken", "502"),
+		"unmatched": count("GET", "unmatched", "404"),
+		"method":    count("POST", "unmatched", "405"),
+	}
+
+	for _, r := range []struct{ method, path string }{
+		{"GET", "/api/things/1"},
+		{"GET", "/api/things/2"},
+		{"GET", "/api/broken"},
+		{"GET", "/api/nothing/here"},
+		{"POST", "/api/things/1"},
+	} {
+		_, err := app.Test(httptest.NewRequest(r.method, r.path, nil))
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2.0, count("GET", "/api/things/:id", "200")-before["found"], "ids are not labels")
+	assert.Equal(t, 1.0, count("GET", "/api/broken", "502")-before["error"], "errors count with their status")
+	assert.Equal(t, 1.0, count("GET", "unmatched", "404")-before["unmatched"])
+	assert.Equal(t, 1.0, count("POST", "unmatched", "405")-before["method"])
+	assert.Zero(t, count("GET", "/api", "404"), "unmatched requests are not counted for the group")
+}
+
+func TestHandler_ServesRegistry(t *testing.T) {
+	db, err := database.OpenSQL("sqlite://" + filepath.Join(t.TempDir(), "til.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, metrics.RegisterDB(db, "metrics_test"))
+
+	metrics.TILs.WithLabelValues("create").Inc()
+
+	app := fiber.New()
+	app.Get("/metrics", metrics.Handler())
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Contains(t, string(body), `til_tils_total{operation="create"}`)
+	assert.Contains(t, string(body), `go_sql_open_connections{db_name="metrics_test"}`)
+	assert.Contains(t, string(body), "go_goroutines")
+	assert.Contains(t, string(body), "process_")
+}
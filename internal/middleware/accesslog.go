@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/logging"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AccessLog logs every request that passes it once it is done, with its route,
+// status, latency and the id of the logged in user. It puts the request id of
+// the requestid middleware in the user context, so everything logged with
+// that context carries it. Server errors are logged as error, client errors as
+// warning. The query string and headers are left out, they can hold secrets.
+func AccessLog(logger *slog.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		if id, ok := c.Locals("requestid").(string); ok {
+			c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+		}
+
+		route := Finish(c, c.Next())
+		status := c.Response().StatusCode()
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Method()),
+			slog.String("path", c.Path()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", len(c.Response().Body())),
+			slog.String("ip", IP(c)),
+		}
+		if route != "" {
+			attrs = append(attrs, slog.String("route", route))
+		}
+		if userID, ok := c.Locals("userID").(uint); ok {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(userID)))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= fiber.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.UserContext(), level, "request", attrs...)
+		return nil
+	}
+}
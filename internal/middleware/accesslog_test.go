@@ -0,0 +1,84 @@
+package middleware_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/logging"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, slog.LevelInfo)
+
+	app := fiber.New()
+	app.Use(requestid.New())
+	app.Use(middleware.AccessLog(logger))
+	api := app.Group("/api", func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(7))
+		return c.Next()
+	})
+	api.Get("/tils/:id", func(c *fiber.Ctx) error {
+		logger.InfoContext(c.UserContext(), "in handler")
+		return c.SendString("til")
+	})
+	api.Get("/broken", func(c *fiber.Ctx) error { return fiber.ErrBadGateway })
+
+	send := func(path string, headers ...string) []map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		_, err := app.Test(req)
+		require.NoError(t, err)
+
+		var records []map[string]any
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			var m map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &m))
+			records = append(records, m)
+		}
+		buf.Reset()
+		return records
+	}
+
+	records := send("/api/tils/3?token=secret", "X-Request-ID", "from-proxy", "Authorization", "Bearer secret")
+	require.Len(t, records, 2)
+	assert.Equal(t, "from-proxy", records[0]["request_id"], "the handler logs with the request id")
+	access := records[1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, "INFO", access["level"])
+	assert.Equal(t, "from-proxy", access["request_id"])
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/api/tils/3", access["path"])
+	assert.Equal(t, "/api/tils/:id", access["route"])
+	assert.Equal(t, float64(200), access["status"])
+	assert.Equal(t, float64(7), access["user_id"])
+	assert.Contains(t, access, "latency_ms")
+	line, _ := json.Marshal(access)
+	assert.NotContains(t, string(line), "secret", "query string and headers are not logged")
+
+	records = send("/api/broken")
+	require.Len(t, records, 1)
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, float64(502), records[0]["status"])
+	assert.NotEmpty(t, records[0]["request_id"], "a request id is generated")
+
+	records = send("/nothing")
+	require.Len(t, records, 1)
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, float64(404), records[0]["status"])
+	assert.NotContains(t, records[0], "route")
+}
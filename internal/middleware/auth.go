@@ -1,50 +1,39 @@
 package middleware
 
 import (
-	"fmt"
 	"log/slog"
 	"strings"
 
 	"github.com/amavis442/til-backend/internal/auth"
-	"github.com/amavis442/til-backend/internal/config"
 	"github.com/gofiber/fiber/v2"
 )
 
-func AuthMiddleware(verifier auth.TokenVerifier) fiber.Handler {
+// AuthMiddleware reads the access token from the Authorization header, or from
+// the cookie named cookieName, and stores the user id in c.Locals("userID").
+func AuthMiddleware(verifier auth.TokenVerifier, cookieName string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var tokenStr string
-		if !config.IsProduction() {
-			cookie := c.Cookies("access_token")
-			slog.Info(fmt.Sprintf("Cookie is '%v'", cookie))
-		}
-
 		authHeader := c.Get("Authorization")
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			tokenStr = strings.TrimPrefix(authHeader, "Bearer ")
 		} else {
 			// Fallback to cookie
-			tokenStr = c.Cookies("access_token")
-		}
-		if !config.IsProduction() {
-			slog.Info(fmt.Sprintf("Middleware token string: %v", tokenStr))
+			tokenStr = c.Cookies(cookieName)
 		}
 
 		if tokenStr == "" {
-			return c.SendStatus(fiber.StatusUnauthorized)
+			return fiber.ErrUnauthorized
 		}
 
 		claims, err := verifier.Verify(tokenStr)
 		if err != nil || claims["typ"] != "access" {
-			return c.SendStatus(fiber.StatusUnauthorized)
+			return fiber.ErrUnauthorized
 		}
 
 		userID, err := verifier.ExtractUserID(claims)
-		if !config.IsProduction() {
-			slog.Info(fmt.Sprintf("User id is: %v", userID))
-		}
 		if err != nil {
-			slog.Info(fmt.Sprintf("Error is: %v", err))
-			return c.SendStatus(fiber.StatusUnauthorized)
+			slog.DebugContext(c.UserContext(), "Access token without a valid user id", "error", err)
+			return fiber.ErrUnauthorized
 		}
 
 		c.Locals("userID", userID)
@@ -3,11 +3,9 @@ package middleware_test
 import (
 	"errors"
 	"net/http/httptest"
-	"os"
 	"testing"
 	"time"
 
-	"github.com/amavis442/til-backend/internal/config"
 	"github.com/amavis442/til-backend/internal/middleware"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -30,16 +28,11 @@ func (m *mockTokenVerifier) ExtractUserID(claims jwt.MapClaims) (uint, error) {
 	return 1, nil
 }
 
-func TestMain(m *testing.M) {
-	config.Load()
-	os.Exit(m.Run())
-}
-
 func TestAuthMiddleware(t *testing.T) {
 	verifier := &mockTokenVerifier{}
 	// Setup a test Fiber app with your middleware and a dummy protected route
 	app := fiber.New()
-	app.Use(middleware.AuthMiddleware(verifier))
+	app.Use(middleware.AuthMiddleware(verifier, "access_token"))
 	app.Get("/protected", func(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusOK)
 	})
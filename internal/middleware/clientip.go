@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ClientIP finds the address of the client of every request, which IP returns.
+// On a connection from one of trusted, the addresses or CIDR ranges of the
+// proxies in front of the server, header is read from the right: every proxy
+// appends the address it got the request from, so the first entry that is not
+// a trusted proxy is the client, and the entries left of it are whatever the
+// client sent. Without trusted proxies, or on any other connection, the address
+// of the connection counts. It panics on an entry of trusted that is not an
+// address or CIDR range, which config.Validate refuses.
+func ClientIP(trusted []string, header string) fiber.Handler {
+	proxies := make([]*net.IPNet, 0, len(trusted))
+	for _, proxy := range trusted {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("middleware: trusted proxy %q is not an address or CIDR range", proxy))
+		}
+		proxies = append(proxies, network)
+	}
+	isProxy := func(ip net.IP) bool {
+		for _, network := range proxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(c *fiber.Ctx) error {
+		if header == "" || !isProxy(c.Context().RemoteIP()) {
+			return c.Next()
+		}
+		hops := strings.Split(c.Get(header), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// A proxy would not have added this, so everything from here on is the client's
+				break
+			}
+			c.Locals("clientIP", ip.String())
+			if !isProxy(ip) {
+				break
+			}
+		}
+		return c.Next()
+	}
+}
+
+// IP returns the address of the client of the request, see ClientIP.
+func IP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("clientIP").(string); ok {
+		return ip
+	}
+	return c.IP()
+}
@@ -0,0 +1,82 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	// app.Test connects from 0.0.0.0
+	newApp := func(trusted ...string) *fiber.App {
+		app := fiber.New()
+		app.Use(middleware.ClientIP(trusted, fiber.HeaderXForwardedFor))
+		app.Get("/", func(c *fiber.Ctx) error { return c.SendString(middleware.IP(c)) })
+		return app
+	}
+	ip := func(app *fiber.App, forwardedFor string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if forwardedFor != "" {
+			req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n])
+	}
+
+	tests := []struct {
+		name         string
+		trusted      []string
+		forwardedFor string
+		want         string
+	}{
+		{"no proxies", nil, "203.0.113.7", "0.0.0.0"},
+		{"untrusted peer", []string{"10.0.0.1"}, "203.0.113.7", "0.0.0.0"},
+		{"trusted proxy", []string{"0.0.0.0"}, "203.0.113.7", "203.0.113.7"},
+		{"spoofed entries left of the client", []string{"0.0.0.0"}, "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"chain of trusted proxies", []string{"0.0.0.0", "10.0.0.0/8"}, "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"garbage left of the client", []string{"0.0.0.0"}, "evil, 203.0.113.7", "203.0.113.7"},
+		{"garbage added after the proxy", []string{"0.0.0.0", "10.0.0.0/8"}, "203.0.113.7, evil, 10.0.0.2", "10.0.0.2"},
+		{"no header", []string{"0.0.0.0"}, "", "0.0.0.0"},
+		{"only proxies", []string{"0.0.0.0", "10.0.0.0/8"}, "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ip(newApp(tt.trusted...), tt.forwardedFor))
+		})
+	}
+
+	assert.Panics(t, func() { middleware.ClientIP([]string{"proxy.local"}, fiber.HeaderXForwardedFor) })
+}
+
+func TestRateLimit_ByIPBehindProxy(t *testing.T) {
+	app := fiber.New()
+	app.Use(middleware.ClientIP([]string{"0.0.0.0"}, fiber.HeaderXForwardedFor))
+	app.Get("/", middleware.RateLimit(ratelimit.NewMemoryStore(), "auth", config.RateLimitRule{Limit: 1, Window: time.Minute}, middleware.ByIP),
+		func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	send := func(forwardedFor string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	// The proxy appends the address it got the request from to what the client sent
+	assert.Equal(t, http.StatusNoContent, send("203.0.113.1"))
+	assert.Equal(t, http.StatusNoContent, send("203.0.113.2"), "clients behind the proxy have their own count")
+	assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.1, 203.0.113.1"), "a spoofed address does not reset the count")
+	assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.2, 203.0.113.1"))
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QueryDeadline gives the user context of every request a deadline, so the
+// database queries of a request are cancelled when it takes too long. Handlers
+// pass c.UserContext() down to the repositories. A timeout of 0 sets no deadline.
+//
+// Only the deadline cancels them: fasthttp does not tell a running handler
+// that the client went away, so the queries of a request whose client hung up
+// still run until they finish or the deadline passes.
+func QueryDeadline(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
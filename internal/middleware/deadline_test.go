@@ -0,0 +1,48 @@
+package middleware_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestQueryDeadline(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		hasDeadline bool
+	}{
+		{name: "With timeout", timeout: time.Second, hasDeadline: true},
+		{name: "Disabled", timeout: 0, hasDeadline: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var deadline time.Time
+			var hasDeadline bool
+			app := fiber.New()
+			app.Use(middleware.QueryDeadline(tc.timeout))
+			app.Get("/", func(c *fiber.Ctx) error {
+				deadline, hasDeadline = c.UserContext().Deadline()
+				return c.SendStatus(fiber.StatusNoContent)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusNoContent {
+				t.Errorf("expected status %d, got %d", fiber.StatusNoContent, resp.StatusCode)
+			}
+			if hasDeadline != tc.hasDeadline {
+				t.Fatalf("expected deadline %v, got %v", tc.hasDeadline, hasDeadline)
+			}
+			if tc.hasDeadline && time.Until(deadline) > tc.timeout {
+				t.Errorf("deadline %v is further away than %v", deadline, tc.timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitKey returns who a request is counted for.
+type RateLimitKey func(c *fiber.Ctx) string
+
+// ByIP counts the requests per IP address of the client, see ClientIP.
+func ByIP(c *fiber.Ctx) string {
+	return "ip:" + IP(c)
+}
+
+// ByUser counts the requests per logged in user, and per IP address before
+// AuthMiddleware has run.
+func ByUser(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("userID").(uint); ok {
+		return "user:" + strconv.FormatUint(uint64(userID), 10)
+	}
+	return ByIP(c)
+}
+
+// RateLimit lets rule.Limit requests per rule.Window through for every key and
+// answers the rest with 429 Too Many Requests and Retry-After. name keeps the
+// counts of rules apart. Every answer has the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers of the strictest rule the
+// request passed. When the store fails, requests are let through.
+func RateLimit(store ratelimit.Store, name string, rule config.RateLimitRule, key RateLimitKey) fiber.Handler {
+	if !rule.Enabled() {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	policy := fmt.Sprintf("%d;w=%d", rule.Limit, int(rule.Window.Seconds()))
+
+	return func(c *fiber.Ctx) error {
+		hits, reset, err := store.Hit(c.UserContext(), name+":"+key(c), rule.Window)
+		if err != nil {
+			slog.WarnContext(c.UserContext(), "Could not count the request for the rate limit", "rule", name, "error", err)
+			return c.Next()
+		}
+
+		remaining := max(int64(rule.Limit)-hits, 0)
+		resetIn := strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds())))
+		if current, err := strconv.ParseInt(c.GetRespHeader("RateLimit-Remaining"), 10, 64); err != nil || remaining <= current {
+			c.Set("RateLimit-Policy", policy)
+			c.Set("RateLimit-Limit", strconv.Itoa(rule.Limit))
+			c.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			c.Set("RateLimit-Reset", resetIn)
+		}
+
+		if hits > int64(rule.Limit) {
+			c.Set(fiber.HeaderRetryAfter, resetIn)
+			return fiber.NewError(fiber.StatusTooManyRequests, "Too many requests, try again later")
+		}
+		return c.Next()
+	}
+}
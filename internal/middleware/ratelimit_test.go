@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	app := fiber.New()
+	api := app.Group("/api", func(c *fiber.Ctx) error {
+		if id, err := strconv.Atoi(c.Get("X-User")); err == nil {
+			c.Locals("userID", uint(id))
+		}
+		return c.Next()
+	}, middleware.RateLimit(store, "api", config.RateLimitRule{Limit: 3, Window: time.Minute}, middleware.ByUser))
+	api.Get("/tils", func(c *fiber.Ctx) error { return c.SendString("tils") })
+	api.Post("/tils/search",
+		middleware.RateLimit(store, "search", config.RateLimitRule{Limit: 1, Window: time.Minute}, middleware.ByUser),
+		func(c *fiber.Ctx) error { return c.SendString("found") })
+
+	send := func(method, path, user string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User", user)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		resp := send(http.MethodGet, "/api/tils", "1")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "3", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(remaining), resp.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", resp.Header.Get("RateLimit-Reset"))
+		assert.Equal(t, "3;w=60", resp.Header.Get("RateLimit-Policy"))
+	}
+
+	resp := send(http.MethodGet, "/api/tils", "1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	t.Run("other users have their own count", func(t *testing.T) {
+		resp := send(http.MethodGet, "/api/tils", "2")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("RateLimit-Remaining"))
+	})
+
+	t.Run("the strictest rule sets the headers", func(t *testing.T) {
+		resp := send(http.MethodPost, "/api/tils/search", "3")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+		resp = send(http.MethodPost, "/api/tils/search", "3")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+
+		resp = send(http.MethodGet, "/api/tils", "3")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "search has its own bucket")
+	})
+
+	t.Run("a rule without limit lets everything through", func(t *testing.T) {
+		app := fiber.New()
+		app.Get("/", middleware.RateLimit(store, "off", config.RateLimitRule{}, middleware.ByIP), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	})
+}
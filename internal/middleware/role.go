@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// UserLookup finds the logged in user, like user.Service.
+type UserLookup interface {
+	GetByID(ctx context.Context, userID uint) (*user.User, error)
+}
+
+// RequireRole only lets users with role through. It runs after AuthMiddleware
+// and reads the role from the database, as the access token does not carry it,
+// so a demoted or disabled user loses access at once.
+func RequireRole(users UserLookup, role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(uint)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		u, err := users.GetByID(c.UserContext(), userID)
+		if errors.Is(err, apperr.ErrNotFound) {
+			return fiber.ErrUnauthorized
+		}
+		if err != nil {
+			return err
+		}
+		if u.Disabled || u.Role != role {
+			return fiber.NewError(fiber.StatusForbidden, "This requires the role "+role)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,56 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userLookup func(userID uint) (*user.User, error)
+
+func (f userLookup) GetByID(ctx context.Context, userID uint) (*user.User, error) {
+	return f(userID)
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *user.User
+		err        error
+		wantStatus int
+	}{
+		{"admin", &user.User{Role: user.RoleAdmin}, nil, fiber.StatusOK},
+		{"user", &user.User{Role: user.RoleUser}, nil, fiber.StatusForbidden},
+		{"disabled admin", &user.User{Role: user.RoleAdmin, Disabled: true}, nil, fiber.StatusForbidden},
+		{"deleted user", nil, apperr.NotFound("User not found", errors.New("record not found")), fiber.StatusUnauthorized},
+		{"database down", nil, errors.New("connection refused"), fiber.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := userLookup(func(userID uint) (*user.User, error) {
+				assert.Equal(t, uint(1), userID)
+				return tt.user, tt.err
+			})
+			app := fiber.New()
+			app.Use(middleware.AuthMiddleware(&mockTokenVerifier{}, "access_token"))
+			app.Get("/admin", middleware.RequireRole(lookup, user.RoleAdmin), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/admin", nil)
+			req.Header.Set("Authorization", "Bearer some.valid.token")
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
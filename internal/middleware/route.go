@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type routeKey struct{}
+
+// Finish is called by middleware that observes requests, like metrics and
+// tracing, with the error returned by c.Next. It renders err with the error
+// handler of the app, so the response has the status sent to the client, and
+// returns the template of the route that matched, or "" when none matched.
+// The innermost caller remembers the route, as the error is gone for the
+// callers around it.
+func Finish(c *fiber.Ctx, err error) string {
+	if err != nil {
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+		if unmatched(c, err) {
+			c.Locals(routeKey{}, "")
+			return ""
+		}
+	}
+	if route, ok := c.Locals(routeKey{}).(string); ok {
+		return route
+	}
+	route := c.Route().Path
+	c.Locals(routeKey{}, route)
+	return route
+}
+
+// unmatched reports whether err comes from the router because no route matched
+// the request. The route of c is then the last middleware, which would be
+// mistaken for the route of the request.
+func unmatched(c *fiber.Ctx, err error) bool {
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) {
+		return false
+	}
+	switch fiberErr.Code {
+	case fiber.StatusMethodNotAllowed:
+		return fiberErr.Message == fiber.ErrMethodNotAllowed.Message
+	case fiber.StatusNotFound:
+		return strings.HasPrefix(fiberErr.Message, "Cannot "+c.Method()+" ")
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BearerToken only lets requests through that send token in the Authorization
+// header. It protects endpoints for machines, like /metrics, that have no user.
+func BearerToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sent, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			return fiber.ErrUnauthorized
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/middleware"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/tracing/tracingtest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attributes(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTrace(t *testing.T) {
+	recorder := tracingtest.Record(t)
+
+	app := fiber.New()
+	app.Use(middleware.Trace())
+	api := app.Group("/api", func(c *fiber.Ctx) error {
+		c.Locals("userID", uint(7))
+		return c.Next()
+	})
+	api.Get("/tils/:id", func(c *fiber.Ctx) error {
+		_, span := tracing.Start(c.UserContext(), "til.GetByID")
+		span.End()
+		return c.SendString("til")
+	})
+	api.Get("/broken", func(c *fiber.Ctx) error { return fiber.ErrBadGateway })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/tils/3", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := recorder.Ended()
+	require.Equal(t, []string{"til.GetByID", "GET /api/tils/:id"}, tracingtest.Names(spans))
+	server, service := spans[1], spans[0]
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String(), "continues the trace of the caller")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, server.SpanContext().SpanID(), service.Parent().SpanID(), "handlers get the span in the user context")
+
+	attrs := attributes(server.Attributes())
+	assert.Equal(t, "/api/tils/:id", attrs["http.route"].AsString())
+	assert.Equal(t, "/api/tils/3", attrs["url.path"].AsString())
+	assert.Equal(t, "7", attrs["enduser.id"].AsString())
+	assert.Equal(t, int64(200), attrs["http.response.status_code"].AsInt64())
+	assert.Equal(t, codes.Unset, server.Status().Code)
+
+	t.Run("server error", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/broken", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+		span := tracingtest.Find(recorder.Ended(), "GET /api/broken")
+		require.NotNil(t, span)
+		assert.Equal(t, codes.Error, span.Status().Code)
+		assert.Equal(t, int64(502), attributes(span.Attributes())["http.response.status_code"].AsInt64())
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/nothing/here", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		assert.Equal(t, "GET", span.Name(), "named after the method only")
+		assert.NotContains(t, attributes(span.Attributes()), attribute.Key("http.route"))
+	})
+}
@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/amavis442/til-backend/internal/config"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrMissingIDToken = errors.New("token response does not contain an id_token") // ErrMissingIDToken is returned when the IdP did not send an ID token.
+	ErrNonceMismatch  = errors.New("id_token nonce does not match")               // ErrNonceMismatch is returned when the ID token was not issued for this login.
+)
+
+// Identity is the user information taken from a verified ID token.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// AuthRequest holds the values of one login attempt that have to survive the
+// redirect to the identity provider and back.
+type AuthRequest struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// NewAuthRequest creates a login attempt with a random state, nonce and PKCE verifier.
+func NewAuthRequest() (AuthRequest, error) {
+	state, err := randomString()
+	if err != nil {
+		return AuthRequest{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return AuthRequest{}, err
+	}
+	return AuthRequest{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}, nil
+}
+
+// Provider implements the authorization-code flow with PKCE against an OpenID Connect provider.
+type Provider struct {
+	oauth2   oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// NewProvider fetches the discovery document of the issuer and prepares the
+// ID-token verifier that checks signatures against the issuer's JWKS.
+func NewProvider(ctx context.Context, cfg config.OIDCConfig) (*Provider, error) {
+	provider, err := gooidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s failed: %w", cfg.IssuerURL, err)
+	}
+
+	return &Provider{
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       cfg.Scopes,
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the identity provider the browser should be sent to.
+func (p *Provider) AuthCodeURL(req AuthRequest) string {
+	return p.oauth2.AuthCodeURL(req.State, gooidc.Nonce(req.Nonce), oauth2.S256ChallengeOption(req.Verifier))
+}
+
+// Exchange trades the authorization code for tokens and returns the identity
+// from the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, req AuthRequest) (*Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(req.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != req.Nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid id_token claims: %w", err)
+	}
+
+	return &Identity{
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
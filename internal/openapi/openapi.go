@@ -0,0 +1,49 @@
+// Package openapi serves the OpenAPI document of the API and a page to read
+// it. The document is written by hand; the handler tests check every request
+// and response against it, so it stays in line with the routes.
+package openapi
+
+import (
+	"embed"
+	"path"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Document is the OpenAPI 3.1 document of the API as JSON.
+//
+//go:embed openapi.json
+var Document []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the pinned Swagger UI files the docs page loads, see
+// swagger-ui/README.md.
+//
+//go:embed swagger-ui/*.js swagger-ui/*.css
+var swaggerUI embed.FS
+
+// Spec sends the OpenAPI document.
+func Spec(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(Document)
+}
+
+// Docs sends a page that renders the OpenAPI document with Swagger UI.
+func Docs(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Send(docsPage)
+}
+
+// Asset sends the Swagger UI file named by the file parameter. The docs page
+// loads them from the server, so it runs no script from a CDN.
+func Asset(c *fiber.Ctx) error {
+	name := c.Params("file")
+	data, err := swaggerUI.ReadFile(path.Join("swagger-ui", name))
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	c.Type(path.Ext(name), "utf-8")
+	return c.Send(data)
+}
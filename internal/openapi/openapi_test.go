@@ -0,0 +1,64 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/openapi"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pb33f/libopenapi"
+	validator "github.com/pb33f/libopenapi-validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocument_IsValid(t *testing.T) {
+	doc, err := libopenapi.NewDocument(openapi.Document)
+	require.NoError(t, err)
+	v, errs := validator.NewValidator(doc)
+	require.Empty(t, errs)
+
+	valid, validationErrs := v.ValidateDocument()
+	for _, e := range validationErrs {
+		t.Errorf("%s: %s", e.Message, e.Reason)
+	}
+	assert.True(t, valid)
+}
+
+func TestSpecAndDocs(t *testing.T) {
+	app := fiber.New()
+	app.Get("/openapi.json", openapi.Spec)
+	app.Get("/docs", openapi.Docs)
+	app.Get("/docs/:file", openapi.Asset)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+	var doc map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, "3.1.0", doc["openapi"])
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/docs", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	page, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(page), `url: "/openapi.json"`)
+	assert.NotContains(t, string(page), "https://", "the page loads nothing from another origin")
+
+	for file, mime := range map[string]string{
+		"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+		"swagger-ui.css":       "text/css; charset=utf-8",
+	} {
+		resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/docs/"+file, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, file)
+		assert.Equal(t, mime, resp.Header.Get(fiber.HeaderContentType), file)
+	}
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/docs/README.md", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
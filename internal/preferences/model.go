@@ -0,0 +1,85 @@
+package preferences
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/validate"
+)
+
+var ErrValidation = errors.New("validation error") // ErrValidation is returned when preferences fail validation.
+
+const MaxPageSize = 100 // Same as the max of the validate tag of PageSize
+
+// Preferences holds the settings a user can change for the frontend.
+type Preferences struct {
+	DefaultCategory string `json:"default_category" validate:"max=100"`                              // Category that is preselected for a new TIL
+	PageSize        int    `json:"page_size" validate:"min=1,max=100"`                               // Number of TILs per page when no limit is given
+	EditorMode      string `json:"editor_mode" validate:"oneof=markdown wysiwyg"`                    // Editor of the frontend
+	MarkdownTheme   string `json:"markdown_theme" validate:"oneof=default github dracula solarized"` // Theme used to render markdown
+	WeeklyDigest    bool   `json:"weekly_digest"`                                                    // Opt-in for the weekly digest email
+	Timezone        string `json:"timezone" validate:"omitempty,timezone"`                           // Empty means the timezone of the profile is used
+}
+
+// Defaults returns the preferences of a user that never saved any.
+func Defaults() Preferences {
+	return Preferences{
+		PageSize:      10,
+		EditorMode:    "markdown",
+		MarkdownTheme: "default",
+	}
+}
+
+// Validate returns an apperr validation error listing every invalid field.
+func (p Preferences) Validate() error {
+	if fields := validate.Struct(p); len(fields) > 0 {
+		return apperr.Validation(ErrValidation, fields...)
+	}
+	return nil
+}
+
+// Value stores the preferences as JSON.
+func (p Preferences) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads the preferences from JSON. Fields missing from the stored JSON,
+// like settings added after it was saved, keep their default value.
+func (p *Preferences) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Preferences", value)
+	}
+
+	prefs := Defaults()
+	if err := json.Unmarshal(b, &prefs); err != nil {
+		return err
+	}
+	*p = prefs
+	return nil
+}
+
+// UserPreferences is the row in user_preferences with the preferences of one user.
+type UserPreferences struct {
+	UserID    uint        `gorm:"primaryKey;autoIncrement:false"`
+	Data      Preferences `gorm:"type:jsonb;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (UserPreferences) TableName() string {
+	return "user_preferences"
+}
@@ -0,0 +1,63 @@
+package preferences
+
+import (
+	"context"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	GetByUserID(ctx context.Context, userID uint) (*UserPreferences, error)
+	Save(ctx context.Context, prefs *UserPreferences) error
+	GetWeeklyDigestUserIDs(ctx context.Context) ([]uint, error)
+	Delete(ctx context.Context, userID uint) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) GetByUserID(ctx context.Context, userID uint) (*UserPreferences, error) {
+	var prefs UserPreferences
+	result := database.Conn(ctx, r.db).Where("user_id = ?", userID).First(&prefs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &prefs, nil
+}
+
+// Save inserts the preferences, or replaces them when the user already has preferences.
+func (r *repository) Save(ctx context.Context, prefs *UserPreferences) error {
+	return database.Conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(prefs).Error
+}
+
+// GetWeeklyDigestUserIDs returns the ids of the users that opted in to the
+// weekly digest, lowest first. The preferences are JSON, whose functions differ
+// per database, so they are filtered here.
+func (r *repository) GetWeeklyDigestUserIDs(ctx context.Context) ([]uint, error) {
+	var ids []uint
+	var batch []UserPreferences
+	err := database.Conn(ctx, r.db).Order("user_id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, p := range batch {
+			if p.Data.WeeklyDigest {
+				ids = append(ids, p.UserID)
+			}
+		}
+		return nil
+	}).Error
+	return ids, err
+}
+
+// Delete removes the preferences of the user, if any.
+func (r *repository) Delete(ctx context.Context, userID uint) error {
+	return database.Conn(ctx, r.db).Where("user_id = ?", userID).Delete(&UserPreferences{}).Error
+}
@@ -0,0 +1,69 @@
+package preferences
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amavis442/til-backend/internal/tracing"
+	"gorm.io/gorm"
+)
+
+type Service interface {
+	Get(ctx context.Context, userID uint) (Preferences, error)
+	Save(ctx context.Context, userID uint, prefs Preferences) (Preferences, error)
+	WeeklyDigestUserIDs(ctx context.Context) ([]uint, error)
+	Delete(ctx context.Context, userID uint) error
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(r Repository) Service {
+	return &service{repo: r}
+}
+
+// Get returns the preferences of the user, or the defaults when none are saved.
+func (s *service) Get(ctx context.Context, userID uint) (Preferences, error) {
+	ctx, span := tracing.Start(ctx, "preferences.Get")
+	defer span.End()
+
+	prefs, err := s.repo.GetByUserID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Defaults(), nil
+	}
+	if err != nil {
+		return Preferences{}, err
+	}
+	return prefs.Data, nil
+}
+
+// Save validates and stores the preferences of the user.
+func (s *service) Save(ctx context.Context, userID uint, prefs Preferences) (Preferences, error) {
+	ctx, span := tracing.Start(ctx, "preferences.Save")
+	defer span.End()
+
+	if err := prefs.Validate(); err != nil {
+		return Preferences{}, err
+	}
+	if err := s.repo.Save(ctx, &UserPreferences{UserID: userID, Data: prefs}); err != nil {
+		return Preferences{}, err
+	}
+	return prefs, nil
+}
+
+// WeeklyDigestUserIDs returns the ids of the users that want the weekly digest.
+func (s *service) WeeklyDigestUserIDs(ctx context.Context) ([]uint, error) {
+	ctx, span := tracing.Start(ctx, "preferences.WeeklyDigestUserIDs")
+	defer span.End()
+
+	return s.repo.GetWeeklyDigestUserIDs(ctx)
+}
+
+// Delete removes the preferences of the user, so the defaults apply again.
+func (s *service) Delete(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "preferences.Delete")
+	defer span.End()
+
+	return s.repo.Delete(ctx, userID)
+}
@@ -0,0 +1,121 @@
+package preferences_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/preferences"
+	"gorm.io/gorm"
+)
+
+type fakeRepo struct {
+	stored  *preferences.UserPreferences
+	getErr  error
+	saveErr error
+	saved   *preferences.UserPreferences
+}
+
+func (f *fakeRepo) GetByUserID(ctx context.Context, userID uint) (*preferences.UserPreferences, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	if f.stored == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return f.stored, nil
+}
+
+func (f *fakeRepo) Save(ctx context.Context, prefs *preferences.UserPreferences) error {
+	f.saved = prefs
+	return f.saveErr
+}
+
+func (f *fakeRepo) Delete(ctx context.Context, userID uint) error {
+	f.stored = nil
+	return f.saveErr
+}
+
+func (f *fakeRepo) GetWeeklyDigestUserIDs(ctx context.Context) ([]uint, error) {
+	if f.stored != nil && f.stored.Data.WeeklyDigest {
+		return []uint{f.stored.UserID}, f.getErr
+	}
+	return nil, f.getErr
+}
+
+func TestService_Get_DefaultsWhenNothingStored(t *testing.T) {
+	svc := preferences.NewService(&fakeRepo{})
+
+	got, err := svc.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != preferences.Defaults() {
+		t.Errorf("got %+v, want defaults %+v", got, preferences.Defaults())
+	}
+}
+
+func TestService_Get_PropagatesError(t *testing.T) {
+	repoErr := errors.New("db down")
+	svc := preferences.NewService(&fakeRepo{getErr: repoErr})
+
+	if _, err := svc.Get(t.Context(), 1); !errors.Is(err, repoErr) {
+		t.Errorf("expected %v, got %v", repoErr, err)
+	}
+}
+
+func TestService_Save(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(p *preferences.Preferences)
+		wantErr bool
+	}{
+		{"defaults are valid", func(p *preferences.Preferences) {}, false},
+		{"page size too small", func(p *preferences.Preferences) { p.PageSize = 0 }, true},
+		{"page size too large", func(p *preferences.Preferences) { p.PageSize = preferences.MaxPageSize + 1 }, true},
+		{"unknown editor mode", func(p *preferences.Preferences) { p.EditorMode = "vim" }, true},
+		{"unknown theme", func(p *preferences.Preferences) { p.MarkdownTheme = "neon" }, true},
+		{"valid timezone", func(p *preferences.Preferences) { p.Timezone = "Europe/Amsterdam" }, false},
+		{"invalid timezone", func(p *preferences.Preferences) { p.Timezone = "Nowhere/City" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{}
+			svc := preferences.NewService(repo)
+
+			prefs := preferences.Defaults()
+			tt.modify(&prefs)
+			_, err := svc.Save(t.Context(), 7, prefs)
+
+			if tt.wantErr {
+				if !errors.Is(err, preferences.ErrValidation) {
+					t.Errorf("expected ErrValidation, got %v", err)
+				}
+				if repo.saved != nil {
+					t.Error("invalid preferences must not be saved")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if repo.saved == nil || repo.saved.UserID != 7 || repo.saved.Data != prefs {
+				t.Errorf("expected preferences of user 7 to be saved, got %+v", repo.saved)
+			}
+		})
+	}
+}
+
+func TestPreferences_ScanKeepsDefaultsForMissingFields(t *testing.T) {
+	var p preferences.Preferences
+	if err := p.Scan([]byte(`{"page_size":25}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := preferences.Defaults()
+	want.PageSize = 25
+	if p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
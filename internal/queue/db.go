@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrLost is returned when a job finished after its lease expired and another
+// worker claimed it in the meantime. The result of the attempt is dropped.
+var ErrLost = errors.New("the job was claimed by another worker")
+
+// DBQueue keeps the jobs in the jobs table.
+type DBQueue struct {
+	db          *gorm.DB
+	maxAttempts int
+	lease       time.Duration
+	now         func() time.Time
+}
+
+// NewDBQueue returns the queue in db. The attempts of a job and how long a
+// worker may hold it come from cfg.
+func NewDBQueue(db *gorm.DB, cfg config.QueueConfig) *DBQueue {
+	return &DBQueue{db: db, maxAttempts: cfg.MaxAttempts, lease: cfg.Lease, now: time.Now}
+}
+
+// Enqueue inserts the jobs, which run right away unless RunAt is set. Jobs
+// whose idempotency key is already in the table are skipped, whatever the
+// status of the existing job.
+func (q *DBQueue) Enqueue(ctx context.Context, jobs ...*Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	now := q.now()
+	for _, j := range jobs {
+		j.Status = StatusPending
+		if j.RunAt.IsZero() {
+			j.RunAt = now
+		}
+		if j.MaxAttempts == 0 {
+			j.MaxAttempts = q.maxAttempts
+		}
+	}
+	return database.Conn(ctx, q.db).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "idempotency_key"}}, DoNothing: true}).
+		Create(jobs).Error
+}
+
+// Claim hands the job that is due first to worker, or nil when no job is due.
+// A running job whose lease expired is due as well: its worker died or hung.
+// Workers skip the rows other workers are claiming, so they never wait for
+// each other.
+func (q *DBQueue) Claim(ctx context.Context, worker string) (*Job, error) {
+	now := q.now()
+	var job *Job
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var found Job
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ?", StatusPending, now).
+			Or("status = ? AND locked_at < ?", StatusRunning, now.Add(-q.lease)).
+			Order("run_at, id").
+			Take(&found).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if found.Status == StatusRunning && found.Attempts >= found.MaxAttempts {
+			// The last attempt never finished
+			found.Status = StatusDead
+			found.LastError = fmt.Sprintf("the lease of attempt %d expired", found.Attempts)
+			found.FinishedAt = &now
+			return tx.Model(&found).Select("status", "last_error", "finished_at").Updates(&found).Error
+		}
+
+		found.Status = StatusRunning
+		found.Attempts++
+		found.LockedBy = worker
+		found.LockedAt = &now
+		if err := tx.Model(&found).Select("status", "attempts", "locked_by", "locked_at").Updates(&found).Error; err != nil {
+			return err
+		}
+		job = &found
+		return nil
+	})
+	return job, err
+}
+
+// Complete marks the claimed job as done.
+func (q *DBQueue) Complete(ctx context.Context, job *Job) error {
+	now := q.now()
+	job.Status = StatusDone
+	job.FinishedAt = &now
+	return q.finish(ctx, job)
+}
+
+// Fail records the error of the claimed job. The job is tried again after a
+// backoff, or dead-lettered when this was its last attempt or the error is
+// Permanent.
+func (q *DBQueue) Fail(ctx context.Context, job *Job, jobErr error) error {
+	now := q.now()
+	job.LastError = jobErr.Error()
+	if IsPermanent(jobErr) || job.Attempts >= job.MaxAttempts {
+		job.Status = StatusDead
+		job.FinishedAt = &now
+	} else {
+		job.Status = StatusPending
+		job.RunAt = now.Add(backoff(job.Attempts))
+	}
+	return q.finish(ctx, job)
+}
+
+// finish saves the outcome of an attempt, unless the job was claimed again
+// since; the attempts tell the claims apart.
+func (q *DBQueue) finish(ctx context.Context, job *Job) error {
+	result := q.db.WithContext(ctx).Model(job).
+		Where("status = ? AND attempts = ?", StatusRunning, job.Attempts).
+		Select("status", "run_at", "last_error", "finished_at").
+		Updates(job)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Retry puts a dead job back in the queue with all its attempts.
+func (q *DBQueue) Retry(ctx context.Context, id uint) error {
+	result := q.db.WithContext(ctx).Model(&Job{ID: id}).
+		Where("status = ?", StatusDead).
+		Updates(map[string]any{
+			"status":      StatusPending,
+			"attempts":    0,
+			"run_at":      q.now(),
+			"finished_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperr.NotFound("Dead job not found", gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// List returns up to limit jobs with the given status, the last changed first.
+func (q *DBQueue) List(ctx context.Context, status string, limit int) ([]Job, error) {
+	var jobs []Job
+	err := q.db.WithContext(ctx).Where("status = ?", status).Order("updated_at desc, id desc").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// PurgeDone deletes the jobs that succeeded before the given time. Dead jobs
+// are kept until they are retried.
+func (q *DBQueue) PurgeDone(ctx context.Context, before time.Time) (int64, error) {
+	result := q.db.WithContext(ctx).Where("status = ? AND finished_at < ?", StatusDone, before).Delete(&Job{})
+	return result.RowsAffected, result.Error
+}
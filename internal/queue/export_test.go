@@ -0,0 +1,8 @@
+package queue
+
+import "time"
+
+// SetClock makes the queue read the time from now.
+func (q *DBQueue) SetClock(now func() time.Time) {
+	q.now = now
+}
@@ -0,0 +1,105 @@
+// Package queue is a durable queue of jobs in the jobs table. Services enqueue
+// side effects, like sending mail, in the same transaction as the change that
+// causes them, so the job exists if and only if the change was committed.
+// The workers of the worker package claim the jobs with FOR UPDATE SKIP
+// LOCKED; failed jobs are retried with exponential backoff and dead-lettered
+// after the last attempt.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	StatusPending = "pending" // The job waits until its run_at
+	StatusRunning = "running" // A worker claimed the job
+	StatusDone    = "done"    // The job succeeded
+	StatusDead    = "dead"    // The job failed its last attempt and waits for someone to look at it
+)
+
+// Job is a row in the jobs table.
+type Job struct {
+	ID             uint       `gorm:"primarykey"`
+	Kind           string     `gorm:"size:100;not null"`    // Picks the handler of the job
+	Payload        string     `gorm:"type:text;not null"`   // JSON the handler decodes
+	IdempotencyKey *string    `gorm:"size:255;uniqueIndex"` // A second job with the same key is not enqueued
+	Status         string     `gorm:"size:20;not null;index:idx_jobs_status_run_at,priority:1"`
+	RunAt          time.Time  `gorm:"not null;index:idx_jobs_status_run_at,priority:2"` // The job does not run before this time
+	Attempts       int        `gorm:"not null;default:0"`
+	MaxAttempts    int        `gorm:"not null"`
+	LockedBy       string     `gorm:"size:255;not null;default:''"` // Worker that claimed the job last
+	LockedAt       *time.Time // When the job was claimed last
+	LastError      string     `gorm:"type:text;not null;default:''"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	FinishedAt     *time.Time // When the job succeeded or died
+}
+
+// NewJob returns a job of the given kind with payload encoded as JSON. A job
+// with a key is enqueued only once, even when the same job is enqueued again.
+func NewJob(kind, key string, payload any) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode the payload of job %s: %w", kind, err)
+	}
+	job := &Job{Kind: kind, Payload: string(data)}
+	if key != "" {
+		job.IdempotencyKey = &key
+	}
+	return job, nil
+}
+
+// Decode decodes the payload into v. A payload that does not decode will not
+// decode on the next attempt either, so the error is Permanent.
+func (j *Job) Decode(v any) error {
+	if err := json.Unmarshal([]byte(j.Payload), v); err != nil {
+		return Permanent(fmt.Errorf("could not decode the payload of job %s: %w", j.Kind, err))
+	}
+	return nil
+}
+
+// Queue enqueues jobs. Enqueue joins the database transaction of ctx, see
+// database.Transaction.
+type Queue interface {
+	Enqueue(ctx context.Context, jobs ...*Job) error
+}
+
+// Handler runs a job. When it returns an error the job is tried again later,
+// unless the error is Permanent.
+type Handler func(ctx context.Context, job *Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as an error that another attempt will not fix, so the
+// job is dead-lettered right away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// backoff is how long a job waits after its attempt-th failed attempt: 10
+// seconds, doubling with every attempt up to 6 hours.
+func backoff(attempt int) time.Duration {
+	const base, limit = 10 * time.Second, 6 * time.Hour
+	if attempt < 1 {
+		return base
+	}
+	if attempt > 16 {
+		return limit
+	}
+	return min(base<<(attempt-1), limit)
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryQueue keeps the enqueued jobs in memory, for tests of the code that
+// enqueues them. Nothing runs the jobs, and they are not rolled back with the
+// transaction they were enqueued in.
+type MemoryQueue struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{}
+}
+
+// Enqueue keeps the jobs, skipping those whose idempotency key it already has.
+func (q *MemoryQueue) Enqueue(ctx context.Context, jobs ...*Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, j := range jobs {
+		if j.IdempotencyKey != nil && q.hasKey(*j.IdempotencyKey) {
+			continue
+		}
+		j.Status = StatusPending
+		q.jobs = append(q.jobs, j)
+	}
+	return nil
+}
+
+func (q *MemoryQueue) hasKey(key string) bool {
+	for _, j := range q.jobs {
+		if j.IdempotencyKey != nil && *j.IdempotencyKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Jobs returns the jobs of the given kind in the order they were enqueued, or
+// all jobs when kind is empty.
+func (q *MemoryQueue) Jobs(kind string) []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var out []*Job
+	for _, j := range q.jobs {
+		if kind == "" || j.Kind == kind {
+			out = append(out, j)
+		}
+	}
+	return out
+}
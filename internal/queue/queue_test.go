@@ -0,0 +1,211 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var start = time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)
+
+// testQueue is a queue whose clock only moves when the test moves it.
+type testQueue struct {
+	*queue.DBQueue
+	db  *gorm.DB
+	now time.Time
+}
+
+func newTestQueue(t *testing.T) *testQueue {
+	db := storagetest.OpenSQLite(t)
+	cfg := config.QueueConfig{Workers: 1, PollInterval: 10 * time.Millisecond, MaxAttempts: 3, Lease: time.Minute}
+	q := &testQueue{DBQueue: queue.NewDBQueue(db, cfg), db: db, now: start}
+	q.SetClock(func() time.Time { return q.now })
+	return q
+}
+
+func (q *testQueue) enqueue(t *testing.T, kind, key string) {
+	job, err := queue.NewJob(kind, key, map[string]string{"to": "ada@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(t.Context(), job))
+}
+
+func (q *testQueue) count(t *testing.T, status string) int64 {
+	var n int64
+	require.NoError(t, q.db.Model(&queue.Job{}).Where("status = ?", status).Count(&n).Error)
+	return n
+}
+
+func TestDBQueue_EnqueueOnce(t *testing.T) {
+	q := newTestQueue(t)
+	q.enqueue(t, "mail.send", "digest:1")
+	q.enqueue(t, "mail.send", "digest:1")
+	q.enqueue(t, "mail.send", "")
+	q.enqueue(t, "mail.send", "")
+
+	assert.Equal(t, int64(3), q.count(t, queue.StatusPending), "the key is enqueued once, jobs without key always")
+}
+
+func TestDBQueue_EnqueueInTransaction(t *testing.T) {
+	q := newTestQueue(t)
+	failed := errors.New("failed")
+
+	err := database.Transaction(t.Context(), q.db, func(ctx context.Context) error {
+		job, err := queue.NewJob("mail.send", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(ctx, job))
+		return failed
+	})
+	require.ErrorIs(t, err, failed)
+	assert.Equal(t, int64(0), q.count(t, queue.StatusPending), "rolled back with the transaction")
+
+	err = database.Transaction(t.Context(), q.db, func(ctx context.Context) error {
+		job, err := queue.NewJob("mail.send", "", nil)
+		require.NoError(t, err)
+		return q.Enqueue(ctx, job)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), q.count(t, queue.StatusPending))
+}
+
+func TestDBQueue_Claim(t *testing.T) {
+	q := newTestQueue(t)
+	later, err := queue.NewJob("mail.send", "later", nil)
+	require.NoError(t, err)
+	later.RunAt = start.Add(time.Hour)
+	require.NoError(t, q.Enqueue(t.Context(), later))
+	q.enqueue(t, "mail.send", "first")
+	q.enqueue(t, "mail.send", "second")
+
+	first, err := q.Claim(t.Context(), "worker-a")
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	assert.Equal(t, "first", *first.IdempotencyKey)
+	assert.Equal(t, queue.StatusRunning, first.Status)
+	assert.Equal(t, 1, first.Attempts)
+	assert.Equal(t, "worker-a", first.LockedBy)
+
+	second, err := q.Claim(t.Context(), "worker-b")
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	assert.Equal(t, "second", *second.IdempotencyKey)
+
+	none, err := q.Claim(t.Context(), "worker-b")
+	require.NoError(t, err)
+	assert.Nil(t, none, "the last job is not due yet")
+
+	// worker-a died; after its lease the job is claimed again
+	q.now = start.Add(2 * time.Minute)
+	require.NoError(t, q.Complete(t.Context(), second))
+	again, err := q.Claim(t.Context(), "worker-b")
+	require.NoError(t, err)
+	require.NotNil(t, again)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, 2, again.Attempts)
+
+	assert.ErrorIs(t, q.Complete(t.Context(), first), queue.ErrLost, "worker-a lost the job")
+	require.NoError(t, q.Complete(t.Context(), again))
+	assert.Equal(t, int64(2), q.count(t, queue.StatusDone))
+}
+
+func TestDBQueue_ClaimExpiredLastAttempt(t *testing.T) {
+	q := newTestQueue(t)
+	q.enqueue(t, "mail.send", "")
+	for range 3 {
+		job, err := q.Claim(t.Context(), "worker")
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		q.now = q.now.Add(2 * time.Minute)
+	}
+
+	job, err := q.Claim(t.Context(), "worker")
+	require.NoError(t, err)
+	assert.Nil(t, job)
+	dead, err := q.List(t.Context(), queue.StatusDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "the lease of attempt 3 expired", dead[0].LastError)
+}
+
+func TestDBQueue_Fail(t *testing.T) {
+	q := newTestQueue(t)
+	q.enqueue(t, "mail.send", "")
+
+	var runAts []time.Time
+	for range 3 {
+		job, err := q.Claim(t.Context(), "worker")
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.NoError(t, q.Fail(t.Context(), job, errors.New("connection refused")))
+		runAts = append(runAts, job.RunAt)
+		q.now = job.RunAt
+	}
+
+	assert.Equal(t, start.Add(10*time.Second), runAts[0])
+	assert.Equal(t, start.Add(30*time.Second), runAts[1], "the backoff doubles")
+	dead, err := q.List(t.Context(), queue.StatusDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1, "dead after the last attempt")
+	assert.Equal(t, "connection refused", dead[0].LastError)
+	assert.Equal(t, 3, dead[0].Attempts)
+
+	require.NoError(t, q.Retry(t.Context(), dead[0].ID))
+	job, err := q.Claim(t.Context(), "worker")
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, 1, job.Attempts, "a retried job gets all its attempts")
+
+	err = q.Retry(t.Context(), job.ID)
+	assert.ErrorIs(t, err, apperr.ErrNotFound, "only dead jobs are retried")
+}
+
+func TestDBQueue_FailPermanent(t *testing.T) {
+	q := newTestQueue(t)
+	q.enqueue(t, "mail.send", "")
+	job, err := q.Claim(t.Context(), "worker")
+	require.NoError(t, err)
+
+	require.NoError(t, q.Fail(t.Context(), job, queue.Permanent(errors.New("no such user"))))
+	assert.Equal(t, queue.StatusDead, job.Status)
+	assert.Equal(t, int64(1), q.count(t, queue.StatusDead))
+}
+
+func TestDBQueue_PurgeDone(t *testing.T) {
+	q := newTestQueue(t)
+	q.enqueue(t, "mail.send", "")
+	q.enqueue(t, "mail.send", "")
+	q.enqueue(t, "mail.send", "")
+	for range 2 {
+		job, err := q.Claim(t.Context(), "worker")
+		require.NoError(t, err)
+		require.NoError(t, q.Complete(t.Context(), job))
+	}
+
+	n, err := q.PurgeDone(t.Context(), start.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, int64(1), q.count(t, queue.StatusPending))
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := queue.NewMemoryQueue()
+	a, err := queue.NewJob("mail.send", "digest:1", nil)
+	require.NoError(t, err)
+	b, err := queue.NewJob("mail.send", "digest:1", nil)
+	require.NoError(t, err)
+	c, err := queue.NewJob("til.render", "", nil)
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(t.Context(), a, b, c))
+
+	assert.Len(t, q.Jobs(""), 2)
+	assert.Equal(t, []*queue.Job{a}, q.Jobs("mail.send"))
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Counter is the row in rate_limits with the window of one key.
+type Counter struct {
+	Key     string `gorm:"primaryKey;size:255"`
+	Hits    int64  `gorm:"not null"`
+	ResetAt int64  `gorm:"not null;index"` // Unix milliseconds the window ends
+}
+
+func (Counter) TableName() string {
+	return "rate_limits"
+}
+
+// DBStore keeps the counts in the rate_limits table, so every replica of the
+// server counts the same hits.
+type DBStore struct {
+	db *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+// hitSQL counts a hit in one statement, so concurrent hits of replicas are not
+// lost. A window that has ended starts again at one hit.
+const hitSQL = `INSERT INTO rate_limits (key, hits, reset_at) VALUES (?, 1, ?)
+ON CONFLICT (key) DO UPDATE SET
+	hits = CASE WHEN rate_limits.reset_at <= ? THEN 1 ELSE rate_limits.hits + 1 END,
+	reset_at = CASE WHEN rate_limits.reset_at <= ? THEN excluded.reset_at ELSE rate_limits.reset_at END
+RETURNING hits, reset_at`
+
+func (s *DBStore) Hit(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	now := time.Now()
+	var c Counter
+	err := s.db.WithContext(ctx).Raw(hitSQL, key, now.Add(window).UnixMilli(), now.UnixMilli(), now.UnixMilli()).Scan(&c).Error
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return c.Hits, time.UnixMilli(c.ResetAt), nil
+}
+
+func (s *DBStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("reset_at < ?", before.UnixMilli()).Delete(&Counter{})
+	return result.RowsAffected, result.Error
+}
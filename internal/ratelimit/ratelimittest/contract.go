@@ -0,0 +1,90 @@
+// Package ratelimittest has the contract that every ratelimit.Store must pass.
+package ratelimittest
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Setup returns an empty store.
+type Setup func(t *testing.T) ratelimit.Store
+
+// RunStoreContract runs the contract against the stores of setup.
+func RunStoreContract(t *testing.T, setup Setup) {
+	t.Run("hits are counted per key within the window", func(t *testing.T) {
+		store := setup(t)
+		start := time.Now()
+
+		for want := int64(1); want <= 3; want++ {
+			hits, reset, err := store.Hit(t.Context(), "auth:1.2.3.4", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, want, hits)
+			assert.WithinDuration(t, start.Add(time.Minute), reset, time.Second, "the window does not move")
+		}
+
+		hits, _, err := store.Hit(t.Context(), "auth:5.6.7.8", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), hits)
+	})
+
+	t.Run("a new window starts when the last one ended", func(t *testing.T) {
+		store := setup(t)
+
+		_, _, err := store.Hit(t.Context(), "api:1", 50*time.Millisecond)
+		require.NoError(t, err)
+		_, first, err := store.Hit(t.Context(), "api:1", 50*time.Millisecond)
+		require.NoError(t, err)
+
+		time.Sleep(60 * time.Millisecond)
+		hits, reset, err := store.Hit(t.Context(), "api:1", 50*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), hits)
+		assert.True(t, reset.After(first))
+	})
+
+	t.Run("concurrent hits are all counted", func(t *testing.T) {
+		store := setup(t)
+
+		var mu sync.Mutex
+		var counts []int64
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Go(func() {
+				hits, _, err := store.Hit(t.Context(), "search:1", time.Minute)
+				assert.NoError(t, err)
+				mu.Lock()
+				counts = append(counts, hits)
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+
+		slices.Sort(counts)
+		for i, hits := range counts {
+			assert.Equal(t, int64(i+1), hits)
+		}
+	})
+
+	t.Run("Purge forgets ended windows", func(t *testing.T) {
+		store := setup(t)
+
+		_, _, err := store.Hit(t.Context(), "ended", time.Millisecond)
+		require.NoError(t, err)
+		_, _, err = store.Hit(t.Context(), "running", time.Hour)
+		require.NoError(t, err)
+
+		n, err := store.Purge(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		hits, _, err := store.Hit(t.Context(), "running", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), hits, "running windows are kept")
+	})
+}
@@ -0,0 +1,63 @@
+// Package ratelimit counts requests per key in fixed windows. The memory store
+// counts per process; the database store shares the counts between replicas.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store counts the hits of keys in fixed windows.
+type Store interface {
+	// Hit counts one hit of key and returns the hits of the current window of
+	// key, and when it ends. When the last window has ended, a new one of
+	// length window starts.
+	Hit(ctx context.Context, key string, window time.Duration) (hits int64, reset time.Time, err error)
+	// Purge forgets the windows that ended before before, and returns how many.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type window struct {
+	hits  int64
+	reset time.Time
+}
+
+// MemoryStore keeps the counts in memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	windows map[string]window
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: map[string]window{}, now: time.Now}
+}
+
+func (s *MemoryStore) Hit(_ context.Context, key string, length time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	w := s.windows[key]
+	if !now.Before(w.reset) {
+		w = window{reset: now.Add(length)}
+	}
+	w.hits++
+	s.windows[key] = w
+	return w.hits, w.reset, nil
+}
+
+func (s *MemoryStore) Purge(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key, w := range s.windows {
+		if w.reset.Before(before) {
+			delete(s.windows, key)
+			n++
+		}
+	}
+	return n, nil
+}
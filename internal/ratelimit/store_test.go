@@ -0,0 +1,14 @@
+package ratelimit_test
+
+import (
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/amavis442/til-backend/internal/ratelimit/ratelimittest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ratelimittest.RunStoreContract(t, func(t *testing.T) ratelimit.Store {
+		return ratelimit.NewMemoryStore()
+	})
+}
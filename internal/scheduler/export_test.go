@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+)
+
+// SetClock makes the scheduler read the time from now.
+func (s *Scheduler) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// SetInstance sets the name the scheduler records its runs with.
+func (s *Scheduler) SetInstance(instance string) {
+	s.instance = instance
+}
+
+// Instance returns the name the scheduler records its runs with.
+func (s *Scheduler) Instance() string {
+	return s.instance
+}
+
+// RunJob runs the job called name as if it was scheduled at scheduledAt.
+func (s *Scheduler) RunJob(ctx context.Context, name string, scheduledAt time.Time) {
+	for _, j := range s.jobs {
+		if j.Name == name {
+			s.runJob(ctx, j, scheduledAt)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Locker makes sure only one replica of the server runs a job at a time.
+type Locker interface {
+	// TryLock takes the lock called name without waiting. It returns false
+	// when someone else holds the lock, and otherwise a function that
+	// releases it.
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+}
+
+// MemoryLocker locks within this process only, which is enough for a single
+// replica, like a server on SQLite.
+type MemoryLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{held: map[string]bool{}}
+}
+
+func (l *MemoryLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held[name] {
+		return nil, false, nil
+	}
+	l.held[name] = true
+	return func() {
+		l.mu.Lock()
+		delete(l.held, name)
+		l.mu.Unlock()
+	}, true, nil
+}
+
+// PostgresLocker elects the replica that runs a job with a Postgres advisory
+// lock. The lock belongs to a database session, so the connection that took it
+// is kept out of the pool until the lock is released. When the replica dies,
+// Postgres ends the session and another replica can take the lock.
+type PostgresLocker struct {
+	db *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{db: db}
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := lockKey(name)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key); err != nil {
+			slog.Error("Could not release the job lock", "job", name, "error", err)
+			// The session still holds the lock, so it must not be reused
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// lockKey maps the name of a job to the 64-bit key of its advisory lock.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("til-backend/job/" + name))
+	return int64(h.Sum64())
+}
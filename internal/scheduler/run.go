@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	StatusRunning   = "running"   // The job has started and not finished yet
+	StatusSucceeded = "succeeded" // The job returned no error
+	StatusFailed    = "failed"    // The job returned an error or panicked
+)
+
+// Run is the row in job_runs for one run of a job. Every scheduled time of a
+// job has at most one run, whichever replica ran it.
+type Run struct {
+	ID          uint       `gorm:"primarykey"`
+	Job         string     `gorm:"size:100;not null;uniqueIndex:idx_job_runs_job_scheduled_at"`
+	ScheduledAt time.Time  `gorm:"not null;uniqueIndex:idx_job_runs_job_scheduled_at"`
+	StartedAt   time.Time  `gorm:"not null"`
+	FinishedAt  *time.Time // Nil while the job runs
+	Status      string     `gorm:"size:20;not null"`
+	Error       string     `gorm:"type:text;not null;default:''"`
+	Instance    string     `gorm:"size:255;not null;default:''"` // Host name of the replica that ran the job
+}
+
+func (Run) TableName() string {
+	return "job_runs"
+}
+
+// Repository stores the runs of the jobs.
+type Repository interface {
+	Start(ctx context.Context, run *Run) (bool, error)
+	Finish(ctx context.Context, run *Run) error
+	List(ctx context.Context, job string, limit int) ([]Run, error)
+	PurgeBefore(ctx context.Context, job string, before time.Time) (int64, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Start inserts the run. It returns false, and inserts nothing, when the job
+// already has a run at run.ScheduledAt.
+func (r *repository) Start(ctx context.Context, run *Run) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(run)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Finish saves the end time, status and error of the run.
+func (r *repository) Finish(ctx context.Context, run *Run) error {
+	return r.db.WithContext(ctx).Model(run).Select("finished_at", "status", "error").Updates(run).Error
+}
+
+// List returns up to limit runs of the job, the last scheduled first.
+func (r *repository) List(ctx context.Context, job string, limit int) ([]Run, error) {
+	var runs []Run
+	err := r.db.WithContext(ctx).Where("job = ?", job).Order("scheduled_at desc").Limit(limit).Find(&runs).Error
+	return runs, err
+}
+
+// PurgeBefore deletes the runs of the job that were scheduled before the given time.
+func (r *repository) PurgeBefore(ctx context.Context, job string, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("job = ? AND scheduled_at < ?", job, before).Delete(&Run{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,254 @@
+// Package scheduler runs the background jobs of the server on cron schedules.
+// Every replica of the server runs a scheduler; a Locker elects the replica
+// that runs a job, and the job_runs table makes sure each scheduled time of a
+// job runs once and keeps the history of the runs.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/cron"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var ErrUnknownJob = errors.New("unknown job") // ErrUnknownJob is returned for the runs of a job that was never added.
+
+// Func is the work of a job. It stops when ctx is done.
+type Func func(ctx context.Context) error
+
+// Job is a job that was added to the scheduler.
+type Job struct {
+	Name     string
+	Schedule string        // config.ScheduleOff when the job does not run
+	schedule cron.Schedule // Nil when the job does not run
+	run      Func
+}
+
+// Status is a job with its next and last run.
+type Status struct {
+	Job
+	NextRunAt time.Time // Zero when the job does not run
+	LastRun   *Run      // Nil when the job never ran
+}
+
+// Scheduler runs jobs on their schedules.
+type Scheduler struct {
+	runs      Repository
+	locker    Locker
+	jitter    time.Duration
+	retention time.Duration
+	instance  string
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+// New returns a scheduler without jobs. The jitter and the retention of the
+// history come from cfg.
+func New(runs Repository, locker Locker, cfg config.SchedulerConfig, logger *slog.Logger) *Scheduler {
+	instance, _ := os.Hostname()
+	return &Scheduler{
+		runs:      runs,
+		locker:    locker,
+		jitter:    cfg.Jitter,
+		retention: cfg.HistoryRetention,
+		instance:  instance,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Add adds a job that runs fn on schedule, which is a cron schedule in UTC or
+// config.ScheduleOff. Jobs that are off are still listed by Jobs.
+func (s *Scheduler) Add(name, schedule string, fn Func) error {
+	j := &Job{Name: name, Schedule: schedule, run: fn}
+	if schedule != config.ScheduleOff {
+		parsed, err := cron.Parse(schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule of job %s: %w", name, err)
+		}
+		j.schedule = parsed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.jobs {
+		if existing.Name == name {
+			return fmt.Errorf("job %s was already added", name)
+		}
+	}
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+// Run runs the jobs on their schedules until ctx is done, and waits for the
+// running jobs to stop.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		if j.schedule != nil {
+			wg.Go(func() { s.loop(ctx, j) })
+		}
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *Job) {
+	for {
+		at := j.schedule.Next(s.now().UTC())
+		if at.IsZero() {
+			return
+		}
+		// The jitter spreads the replicas, so they do not all reach for the
+		// lock at the same moment
+		delay := at.Sub(s.now())
+		if s.jitter > 0 {
+			delay += rand.N(s.jitter)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.runJob(ctx, j, at)
+	}
+}
+
+// runJob runs j for the time it was scheduled at, unless another replica runs
+// it or already ran it.
+func (s *Scheduler) runJob(ctx context.Context, j *Job, scheduledAt time.Time) {
+	logger := s.logger.With("job", j.Name, "scheduled_at", scheduledAt)
+
+	unlock, ok, err := s.locker.TryLock(ctx, j.Name)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not take the lock of the job", "error", err)
+		return
+	}
+	if !ok {
+		logger.DebugContext(ctx, "Job runs on another replica")
+		return
+	}
+	defer unlock()
+
+	run := &Run{Job: j.Name, ScheduledAt: scheduledAt, StartedAt: s.now(), Status: StatusRunning, Instance: s.instance}
+	started, err := s.runs.Start(ctx, run)
+	if err != nil {
+		logger.ErrorContext(ctx, "Could not record the start of the job", "error", err)
+		return
+	}
+	if !started {
+		logger.DebugContext(ctx, "Job already ran on another replica")
+		return
+	}
+
+	runCtx, span := tracing.Start(ctx, "scheduler.Run")
+	span.SetAttributes(attribute.String("job.name", j.Name))
+	err = call(runCtx, j.run)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+
+	finished := s.now()
+	run.FinishedAt = &finished
+	run.Status = StatusSucceeded
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+	}
+	metrics.JobRuns.WithLabelValues(j.Name, run.Status).Inc()
+	if err != nil {
+		logger.ErrorContext(ctx, "Job failed", "error", err, "duration", finished.Sub(run.StartedAt))
+	} else {
+		logger.InfoContext(ctx, "Job succeeded", "duration", finished.Sub(run.StartedAt))
+	}
+
+	// The run is recorded even when the server is shutting down
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.runs.Finish(saveCtx, run); err != nil {
+		logger.ErrorContext(ctx, "Could not record the end of the job", "error", err)
+	}
+	if _, err := s.runs.PurgeBefore(saveCtx, j.Name, finished.Add(-s.retention)); err != nil {
+		logger.ErrorContext(ctx, "Could not purge the old runs of the job", "error", err)
+	}
+}
+
+// call runs fn and turns a panic into an error, so one job cannot stop the
+// server.
+func call(ctx context.Context, fn Func) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Jobs returns every job with its next and last run, in the order they were
+// added.
+func (s *Scheduler) Jobs(ctx context.Context) ([]Status, error) {
+	ctx, span := tracing.Start(ctx, "scheduler.Jobs")
+	defer span.End()
+
+	s.mu.Lock()
+	jobs := s.jobs
+	s.mu.Unlock()
+
+	out := make([]Status, 0, len(jobs))
+	for _, j := range jobs {
+		status := Status{Job: *j}
+		if j.schedule != nil {
+			status.NextRunAt = j.schedule.Next(s.now().UTC())
+		}
+		last, err := s.runs.List(ctx, j.Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(last) > 0 {
+			status.LastRun = &last[0]
+		}
+		out = append(out, status)
+	}
+	return out, nil
+}
+
+// Runs returns up to limit runs of the job, the last scheduled first.
+func (s *Scheduler) Runs(ctx context.Context, name string, limit int) ([]Run, error) {
+	ctx, span := tracing.Start(ctx, "scheduler.Runs")
+	defer span.End()
+
+	s.mu.Lock()
+	known := false
+	for _, j := range s.jobs {
+		known = known || j.Name == name
+	}
+	s.mu.Unlock()
+	if !known {
+		return nil, apperr.NotFound("Job not found", ErrUnknownJob)
+	}
+
+	return s.runs.List(ctx, name, limit)
+}
@@ -0,0 +1,214 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/scheduler"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var slot = time.Date(2026, 3, 16, 3, 30, 0, 0, time.UTC)
+
+func newTestScheduler(t *testing.T, runs scheduler.Repository, locker scheduler.Locker) *scheduler.Scheduler {
+	cfg := config.SchedulerConfig{HistoryRetention: 30 * 24 * time.Hour}
+	s := scheduler.New(runs, locker, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.SetClock(func() time.Time { return slot.Add(time.Second) })
+	return s
+}
+
+func TestScheduler_RunsEachScheduledTimeOnce(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	var calls atomic.Int32
+	count := func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}
+
+	// Two replicas whose lockers do not see each other
+	a := newTestScheduler(t, runs, scheduler.NewMemoryLocker())
+	b := newTestScheduler(t, runs, scheduler.NewMemoryLocker())
+	b.SetInstance("replica-b")
+	require.NoError(t, a.Add("purge", "30 3 * * *", count))
+	require.NoError(t, b.Add("purge", "30 3 * * *", count))
+
+	a.RunJob(t.Context(), "purge", slot)
+	b.RunJob(t.Context(), "purge", slot)
+	assert.Equal(t, int32(1), calls.Load())
+
+	b.RunJob(t.Context(), "purge", slot.AddDate(0, 0, 1))
+	assert.Equal(t, int32(2), calls.Load(), "the next day runs again")
+
+	history, err := a.Runs(t.Context(), "purge", 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "replica-b", history[0].Instance, "last scheduled first")
+	assert.Equal(t, scheduler.StatusSucceeded, history[1].Status)
+	assert.Equal(t, a.Instance(), history[1].Instance)
+	require.NotNil(t, history[1].FinishedAt)
+}
+
+func TestScheduler_SkipsWhileLocked(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	locker := scheduler.NewMemoryLocker()
+	s := newTestScheduler(t, runs, locker)
+	called := false
+	require.NoError(t, s.Add("purge", "@hourly", func(ctx context.Context) error {
+		called = true
+		return nil
+	}))
+
+	unlock, ok, err := locker.TryLock(t.Context(), "purge")
+	require.NoError(t, err)
+	require.True(t, ok)
+	s.RunJob(t.Context(), "purge", slot)
+	assert.False(t, called)
+
+	unlock()
+	s.RunJob(t.Context(), "purge", slot)
+	assert.True(t, called, "a run that was skipped for the lock is not recorded")
+}
+
+func TestScheduler_RecordsFailures(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	s := newTestScheduler(t, runs, scheduler.NewMemoryLocker())
+	require.NoError(t, s.Add("failing", "@hourly", func(ctx context.Context) error {
+		return errors.New("database is gone")
+	}))
+	require.NoError(t, s.Add("panicking", "@hourly", func(ctx context.Context) error {
+		panic("nil map")
+	}))
+
+	s.RunJob(t.Context(), "failing", slot)
+	s.RunJob(t.Context(), "panicking", slot)
+
+	failed, err := s.Runs(t.Context(), "failing", 1)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, scheduler.StatusFailed, failed[0].Status)
+	assert.Equal(t, "database is gone", failed[0].Error)
+
+	panicked, err := s.Runs(t.Context(), "panicking", 1)
+	require.NoError(t, err)
+	require.Len(t, panicked, 1)
+	assert.Equal(t, "panic: nil map", panicked[0].Error)
+}
+
+func TestScheduler_PurgesOldRuns(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	s := newTestScheduler(t, runs, scheduler.NewMemoryLocker())
+	require.NoError(t, s.Add("purge", "@daily", func(ctx context.Context) error { return nil }))
+
+	s.RunJob(t.Context(), "purge", slot.AddDate(0, -2, 0))
+	s.RunJob(t.Context(), "purge", slot.AddDate(0, 0, -1))
+	s.RunJob(t.Context(), "purge", slot)
+
+	history, err := s.Runs(t.Context(), "purge", 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, slot, history[0].ScheduledAt.UTC())
+}
+
+func TestScheduler_Run(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	s := scheduler.New(runs, scheduler.NewMemoryLocker(), config.SchedulerConfig{HistoryRetention: time.Hour}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(t.Context())
+	var calls atomic.Int32
+	require.NoError(t, s.Add("tick", "@every 20ms", func(ctx context.Context) error {
+		if calls.Add(1) == 2 {
+			cancel()
+		}
+		return nil
+	}))
+	require.NoError(t, s.Add("never", config.ScheduleOff, func(ctx context.Context) error {
+		t.Error("a job that is off ran")
+		return nil
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestScheduler_Jobs(t *testing.T) {
+	runs := scheduler.NewRepository(storagetest.OpenSQLite(t))
+	s := newTestScheduler(t, runs, scheduler.NewMemoryLocker())
+	noop := func(ctx context.Context) error { return nil }
+	require.NoError(t, s.Add("purge", "30 3 * * *", noop))
+	require.NoError(t, s.Add("digest", config.ScheduleOff, noop))
+	assert.ErrorContains(t, s.Add("purge", "@hourly", noop), "already added")
+	assert.ErrorContains(t, s.Add("broken", "daily", noop), "invalid schedule of job broken")
+
+	s.RunJob(t.Context(), "purge", slot)
+
+	jobs, err := s.Jobs(t.Context())
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "purge", jobs[0].Name)
+	assert.Equal(t, slot.AddDate(0, 0, 1), jobs[0].NextRunAt)
+	require.NotNil(t, jobs[0].LastRun)
+	assert.Equal(t, scheduler.StatusSucceeded, jobs[0].LastRun.Status)
+	assert.Equal(t, "digest", jobs[1].Name)
+	assert.True(t, jobs[1].NextRunAt.IsZero())
+	assert.Nil(t, jobs[1].LastRun)
+
+	_, err = s.Runs(t.Context(), "unknown", 10)
+	assert.ErrorIs(t, err, apperr.ErrNotFound)
+	assert.ErrorIs(t, err, scheduler.ErrUnknownJob)
+}
+
+// TestPostgresLocker needs a real Postgres database. Set TEST_POSTGRES_DSN to
+// run it.
+func TestPostgresLocker(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	db, err := database.OpenSQL(dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Two lockers on separate pools act like two replicas
+	other, err := database.OpenSQL(dsn)
+	require.NoError(t, err)
+	defer other.Close()
+	a, b := scheduler.NewPostgresLocker(db), scheduler.NewPostgresLocker(other)
+
+	unlock, ok, err := a.TryLock(t.Context(), "purge")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = b.TryLock(t.Context(), "purge")
+	require.NoError(t, err)
+	assert.False(t, ok, "held by the other replica")
+
+	unlockOther, ok, err := b.TryLock(t.Context(), "digest")
+	require.NoError(t, err)
+	assert.True(t, ok, "other jobs have their own lock")
+	unlockOther()
+
+	unlock()
+	unlock, ok, err = b.TryLock(t.Context(), "purge")
+	require.NoError(t, err)
+	assert.True(t, ok, "released")
+	unlock()
+}
@@ -0,0 +1,59 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/auth"
+	"github.com/amavis442/til-backend/internal/auth/authtest"
+	"github.com/amavis442/til-backend/internal/ratelimit"
+	"github.com/amavis442/til-backend/internal/ratelimit/ratelimittest"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/til/tiltest"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/amavis442/til-backend/internal/user/usertest"
+)
+
+func TestTILRepository(t *testing.T) {
+	for _, b := range storagetest.Backends() {
+		t.Run(b.Name, func(t *testing.T) {
+			tiltest.RunRepositoryContract(t, func(t *testing.T) (til.Repository, uint, uint) {
+				db := b.Open(t)
+				users := storagetest.CreateUsers(t, db, 2)
+				return til.NewRepository(db), users[0], users[1]
+			})
+		})
+	}
+}
+
+func TestUserRepository(t *testing.T) {
+	for _, b := range storagetest.Backends() {
+		t.Run(b.Name, func(t *testing.T) {
+			usertest.RunRepositoryContract(t, func(t *testing.T) user.Repository {
+				return user.NewRepository(b.Open(t))
+			})
+		})
+	}
+}
+
+func TestAuthRepository(t *testing.T) {
+	for _, b := range storagetest.Backends() {
+		t.Run(b.Name, func(t *testing.T) {
+			authtest.RunRepositoryContract(t, func(t *testing.T) (auth.Repository, uint, uint) {
+				db := b.Open(t)
+				users := storagetest.CreateUsers(t, db, 2)
+				return auth.NewRepository(db), users[0], users[1]
+			})
+		})
+	}
+}
+
+func TestRateLimitStore(t *testing.T) {
+	for _, b := range storagetest.Backends() {
+		t.Run(b.Name, func(t *testing.T) {
+			ratelimittest.RunStoreContract(t, func(t *testing.T) ratelimit.Store {
+				return ratelimit.NewDBStore(b.Open(t))
+			})
+		})
+	}
+}
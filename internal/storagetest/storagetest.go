@@ -0,0 +1,84 @@
+// Package storagetest opens migrated databases for tests that every storage
+// backend must pass. SQLite always runs in-process; Postgres runs when
+// TEST_POSTGRES_DSN points to a database that may be wiped.
+package storagetest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/dbmigrate"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Backend opens an empty, fully migrated database for one test.
+type Backend struct {
+	Name string
+	Open func(t *testing.T) *gorm.DB
+}
+
+// Backends returns SQLite, and Postgres when TEST_POSTGRES_DSN is set.
+func Backends() []Backend {
+	backends := []Backend{{Name: "sqlite", Open: OpenSQLite}}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		backends = append(backends, Backend{
+			Name: "postgres",
+			Open: func(t *testing.T) *gorm.DB { return OpenPostgres(t, dsn) },
+		})
+	}
+	return backends
+}
+
+// OpenSQLite migrates a new SQLite database in a temporary directory.
+func OpenSQLite(t *testing.T) *gorm.DB {
+	return open(t, "sqlite://"+filepath.Join(t.TempDir(), "til.db"))
+}
+
+// OpenPostgres migrates the Postgres database and empties all tables, so every
+// test starts with the same state. Tests that use it must not run in parallel.
+func OpenPostgres(t *testing.T, dsn string) *gorm.DB {
+	db := open(t, dsn)
+	require.NoError(t, db.Exec("TRUNCATE users, tils, refresh_tokens, user_preferences, rate_limits, job_runs, jobs RESTART IDENTITY CASCADE").Error)
+	return db
+}
+
+func open(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+
+	m, err := dbmigrate.Open(dsn)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	require.NoError(t, m.Close())
+
+	db, err := database.Open(dsn, &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// CreateUsers inserts n users and returns their ids, for tables that refer to users.
+func CreateUsers(t *testing.T, db *gorm.DB, n int) []uint {
+	t.Helper()
+
+	ids := make([]uint, n)
+	for i := range ids {
+		u := user.User{
+			Username:     fmt.Sprintf("user%d", i+1),
+			Email:        fmt.Sprintf("user%d@example.com", i+1),
+			PasswordHash: "irrelevant",
+		}
+		require.NoError(t, db.Create(&u).Error)
+		ids[i] = u.ID
+	}
+	return ids
+}
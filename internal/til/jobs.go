@@ -0,0 +1,33 @@
+package til
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amavis442/til-backend/internal/queue"
+)
+
+// RenderJob is the kind of the queued jobs that render a TIL after it was
+// saved.
+const RenderJob = "til.render"
+
+type renderPayload struct {
+	ID uint `json:"id"`
+}
+
+// newRenderJob returns the job that renders version of the TIL with the given
+// ID. Each version is rendered once.
+func newRenderJob(id, version uint) (*queue.Job, error) {
+	return queue.NewJob(RenderJob, fmt.Sprintf("%s:%d:%d", RenderJob, id, version), renderPayload{ID: id})
+}
+
+// RenderHandler runs the RenderJob jobs with s.
+func RenderHandler(s Service) queue.Handler {
+	return func(ctx context.Context, job *queue.Job) error {
+		var p renderPayload
+		if err := job.Decode(&p); err != nil {
+			return err
+		}
+		return s.Render(ctx, p.ID)
+	}
+}
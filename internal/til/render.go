@@ -0,0 +1,21 @@
+package til
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown is CommonMark with the GitHub extensions: tables, strikethrough,
+// autolinks and task lists. Raw HTML in the content is left out.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// RenderMarkdown renders the Markdown content of a TIL entry to HTML.
+func RenderMarkdown(content string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -1,22 +1,31 @@
 package til
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"log/slog"
 	"strings"
+	"time"
 
+	"github.com/amavis442/til-backend/internal/database"
 	"gorm.io/gorm"
 )
 
 type Repository interface {
-	GetAll(limit int, offset int) ([]TIL, error)
-	Create(t TIL) error
-	Update(til TIL) (TIL, error)
-	GetByID(id uint) (TIL, error)
-	Search(title, category string) ([]*TIL, error)
-	FindOne(title, category string) (*TIL, error)
-	Count() (int64, error)
+	GetAll(ctx context.Context, limit int, offset int) ([]TIL, error)
+	Create(ctx context.Context, t *TIL) error
+	Update(ctx context.Context, til TIL) (TIL, error)
+	GetByID(ctx context.Context, id uint) (TIL, error)
+	Search(ctx context.Context, title, category string) ([]*TIL, error)
+	FindOne(ctx context.Context, title, category string) (*TIL, error)
+	Count(ctx context.Context) (int64, error)
+	GetAllByUserID(ctx context.Context, userID uint) ([]TIL, error)
+	DeleteByUserID(ctx context.Context, userID uint) error
+	ReassignUserID(ctx context.Context, fromUserID, toUserID uint) error
+	GetAfterID(ctx context.Context, afterID uint, limit int) ([]TIL, error)
+	UpdateHTML(ctx context.Context, id uint, content, html string) (bool, error)
+	GetByUserIDSince(ctx context.Context, userID uint, since time.Time) ([]TIL, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 type repository struct {
@@ -27,33 +36,57 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db: db}
 }
 
-func (r *repository) GetAll(limit int, offset int) ([]TIL, error) {
+func (r *repository) GetAll(ctx context.Context, limit int, offset int) ([]TIL, error) {
 	var tils []TIL
-	err := r.db.Order("created_at desc").Limit(limit).Offset(offset).Find(&tils).Error
+	err := database.Conn(ctx, r.db).Order("created_at desc").Limit(limit).Offset(offset).Find(&tils).Error
 	return tils, err
 }
 
-// Validation of t TIL is done in the service layer
-func (r *repository) Create(t TIL) error {
-	slog.Info(fmt.Sprintf("Saving TIL to DB: %+v", t))
-	return r.db.Create(&t).Error
+// Create inserts t and sets its ID. The service has validated t.
+func (r *repository) Create(ctx context.Context, t *TIL) error {
+	return database.Conn(ctx, r.db).Create(t).Error
 }
 
-// Validation of t TIL is done in the service layer
-func (r *repository) Update(til TIL) (TIL, error) {
-	err := r.db.Omit("created_at, user_id").Save(&til).Error
-	return til, err
+// Update changes the title, content and category of til, increments its
+// version and returns the stored TIL entry. The owner, creation time and HTML
+// are never changed; the HTML is replaced with UpdateHTML. When til.Version is
+// set, the stored TIL entry must still have that version, otherwise
+// ErrVersionMismatch is returned.
+func (r *repository) Update(ctx context.Context, til TIL) (TIL, error) {
+	query := database.Conn(ctx, r.db).Model(&TIL{ID: til.ID})
+	if til.Version != 0 {
+		query = query.Where("version = ?", til.Version)
+	}
+	result := query.Updates(map[string]any{
+		"title":    til.Title,
+		"content":  til.Content,
+		"category": til.Category,
+		"version":  gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return TIL{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if til.Version == 0 {
+			return TIL{}, gorm.ErrRecordNotFound
+		}
+		if _, err := r.GetByID(ctx, til.ID); err != nil {
+			return TIL{}, err
+		}
+		return TIL{}, ErrVersionMismatch
+	}
+	return r.GetByID(ctx, til.ID)
 }
 
-func (r *repository) GetByID(id uint) (TIL, error) {
+func (r *repository) GetByID(ctx context.Context, id uint) (TIL, error) {
 	var til TIL
-	result := r.db.First(&til, id)
+	result := database.Conn(ctx, r.db).First(&til, id)
 	return til, result.Error
 }
 
-func (r *repository) Search(title, category string) ([]*TIL, error) {
+func (r *repository) Search(ctx context.Context, title, category string) ([]*TIL, error) {
 	var tils []*TIL
-	query := r.db
+	query := database.Conn(ctx, r.db)
 
 	if title != "" {
 		query = query.Where("LOWER(title) LIKE ?", "%"+strings.ToLower(title)+"%")
@@ -67,20 +100,20 @@ func (r *repository) Search(title, category string) ([]*TIL, error) {
 }
 
 // create a FindOne(title, category string)
-func (r *repository) Count() (int64, error) {
+func (r *repository) Count(ctx context.Context) (int64, error) {
 	var count int64
-	err := r.db.Model(&TIL{}).Count(&count).Error
+	err := database.Conn(ctx, r.db).Model(&TIL{}).Count(&count).Error
 	return count, err
 }
 
-func (r *repository) FindOne(title, category string) (*TIL, error) {
+func (r *repository) FindOne(ctx context.Context, title, category string) (*TIL, error) {
 	var til TIL
 
 	if title == "" && category == "" {
 		return nil, errors.New("both title and category are empty")
 	}
 
-	query := r.db
+	query := database.Conn(ctx, r.db)
 	if title != "" {
 		query = query.Where("LOWER(title) LIKE ?", "%"+strings.ToLower(title)+"%")
 	}
@@ -97,3 +130,58 @@ func (r *repository) FindOne(title, category string) (*TIL, error) {
 	}
 	return &til, nil
 }
+
+func (r *repository) GetAllByUserID(ctx context.Context, userID uint) ([]TIL, error) {
+	var tils []TIL
+	err := database.Conn(ctx, r.db).Where("user_id = ?", userID).Order("created_at asc").Find(&tils).Error
+	return tils, err
+}
+
+func (r *repository) DeleteByUserID(ctx context.Context, userID uint) error {
+	if userID == 0 {
+		return errors.New("user id must not be empty cannot delete tils")
+	}
+	return database.Conn(ctx, r.db).Where("user_id = ?", userID).Delete(&TIL{}).Error
+}
+
+// ReassignUserID moves all TILs of one user to another user, including soft-deleted ones.
+func (r *repository) ReassignUserID(ctx context.Context, fromUserID, toUserID uint) error {
+	if fromUserID == 0 || toUserID == 0 {
+		return errors.New("user ids must not be empty cannot reassign tils")
+	}
+	return database.Conn(ctx, r.db).Unscoped().Model(&TIL{}).Where("user_id = ?", fromUserID).Update("user_id", toUserID).Error
+}
+
+// GetAfterID returns up to limit TILs with an id above afterID, lowest id first,
+// so all TILs can be walked in batches while they are changed.
+func (r *repository) GetAfterID(ctx context.Context, afterID uint, limit int) ([]TIL, error) {
+	var tils []TIL
+	err := database.Conn(ctx, r.db).Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&tils).Error
+	return tils, err
+}
+
+// UpdateHTML replaces the rendered content of a TIL when its Markdown is still
+// content, and reports whether it did. Neither the version nor the update time
+// change, as the TIL itself did not; a client's ETag stays valid.
+func (r *repository) UpdateHTML(ctx context.Context, id uint, content, html string) (bool, error) {
+	result := database.Conn(ctx, r.db).Model(&TIL{ID: id}).Where("content = ?", content).UpdateColumn("html", html)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetByUserIDSince returns the TILs of a user created at or after since, oldest first.
+func (r *repository) GetByUserIDSince(ctx context.Context, userID uint, since time.Time) ([]TIL, error) {
+	var tils []TIL
+	err := database.Conn(ctx, r.db).Where("user_id = ? AND created_at >= ?", userID, since).Order("created_at asc").Find(&tils).Error
+	return tils, err
+}
+
+// PurgeDeleted permanently removes TILs that were soft-deleted before the given time.
+func (r *repository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	result := database.Conn(ctx, r.db).Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&TIL{})
+	return result.RowsAffected, result.Error
+}
+
+// Transaction runs fn in a transaction that the repository and the queue join.
+func (r *repository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.Transaction(ctx, r.db, fn)
+}
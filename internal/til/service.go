@@ -1,57 +1,249 @@
 package til
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"gorm.io/gorm"
+)
+
 type Service interface {
-	List(limit int, offset int) ([]TIL, error)
-	ListWithCount(limit int, offset int) ([]TIL, int64, error)
-	Create(t TIL) error
-	Update(t TIL) (TIL, error)
-	GetByID(id uint) (TIL, error)
-	Search(title, category string) ([]*TIL, error)
+	List(ctx context.Context, limit int, offset int) ([]TIL, error)
+	ListWithCount(ctx context.Context, limit int, offset int) ([]TIL, int64, error)
+	Create(ctx context.Context, t TIL) error
+	Update(ctx context.Context, t TIL) (TIL, error)
+	GetByID(ctx context.Context, id uint) (TIL, error)
+	Search(ctx context.Context, title, category string) ([]*TIL, error)
+	ListByUser(ctx context.Context, userID uint) ([]TIL, error)
+	DeleteByUser(ctx context.Context, userID uint) error
+	ReassignUser(ctx context.Context, fromUserID, toUserID uint) error
+	RenderAll(ctx context.Context, onlyMissing bool) (int, error)
+	ListByUserSince(ctx context.Context, userID uint, since time.Time) ([]TIL, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	Render(ctx context.Context, id uint) error
 }
 
+// renderBatchSize is how many TILs RenderAll reads at a time.
+const renderBatchSize = 100
+
 type service struct {
 	repo Repository
+	jobs queue.Queue
 }
 
-func NewService(r Repository) Service {
-	return &service{r}
+// NewService returns the TIL service. Every TIL that is saved is rendered to
+// HTML by a job in jobs.
+func NewService(r Repository, jobs queue.Queue) Service {
+	return &service{repo: r, jobs: jobs}
 }
 
-func (uc *service) List(limit int, offset int) ([]TIL, error) {
-	return uc.repo.GetAll(limit, offset)
+func (uc *service) List(ctx context.Context, limit int, offset int) ([]TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.List")
+	defer span.End()
+
+	return uc.repo.GetAll(ctx, limit, offset)
 }
 
-func (uc *service) ListWithCount(limit int, offset int) ([]TIL, int64, error) {
-	tils, err := uc.repo.GetAll(limit, offset)
+func (uc *service) ListWithCount(ctx context.Context, limit int, offset int) ([]TIL, int64, error) {
+	ctx, span := tracing.Start(ctx, "til.ListWithCount")
+	defer span.End()
+
+	tils, err := uc.repo.GetAll(ctx, limit, offset)
 	if err != nil {
 		return nil, 0, err
 	}
-	total, err := uc.repo.Count()
+	total, err := uc.repo.Count(ctx)
 	if err != nil {
 		return nil, 0, err
 	}
 	return tils, total, nil
 }
 
-func (uc *service) Create(t TIL) error {
-	til, err := uc.repo.FindOne(t.Title, t.Category)
+func (uc *service) Create(ctx context.Context, t TIL) error {
+	ctx, span := tracing.Start(ctx, "til.Create")
+	defer span.End()
+
+	til, err := uc.repo.FindOne(ctx, t.Title, t.Category)
 	if err != nil {
 		return err
 	}
 	if til != nil {
-		return ErrDuplicate
+		return apperr.Conflict("A TIL with this title already exists in this category", ErrDuplicate)
+	}
+	return uc.repo.Transaction(ctx, func(ctx context.Context) error {
+		if err := uc.repo.Create(ctx, &t); err != nil {
+			return err
+		}
+		return uc.enqueueRender(ctx, t)
+	})
+}
+
+// enqueueRender renders the saved version of t in the background.
+func (uc *service) enqueueRender(ctx context.Context, t TIL) error {
+	job, err := newRenderJob(t.ID, t.Version)
+	if err != nil {
+		return err
+	}
+	return uc.jobs.Enqueue(ctx, job)
+}
+
+func (u *service) GetByID(ctx context.Context, id uint) (TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.GetByID")
+	defer span.End()
+
+	t, err := u.repo.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TIL{}, apperr.NotFound("TIL not found", err)
+	}
+	return t, err
+}
+
+// Update saves til when til.UserID owns the stored TIL. When til.Version is
+// set, the stored TIL must still have that version.
+func (uc *service) Update(ctx context.Context, til TIL) (TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.Update")
+	defer span.End()
+
+	existing, err := uc.GetByID(ctx, til.ID)
+	if err != nil {
+		return TIL{}, err
+	}
+	if existing.UserID != til.UserID {
+		return TIL{}, apperr.Forbidden("You can only change your own TILs", ErrNotOwner)
+	}
+	if til.Version != 0 && til.Version != existing.Version {
+		return TIL{}, apperr.PreconditionFailed("The TIL was changed since you read it", ErrVersionMismatch)
+	}
+
+	var updated TIL
+	err = uc.repo.Transaction(ctx, func(ctx context.Context) error {
+		var err error
+		if updated, err = uc.repo.Update(ctx, til); err != nil {
+			return err
+		}
+		return uc.enqueueRender(ctx, updated)
+	})
+	switch {
+	case errors.Is(err, ErrVersionMismatch):
+		return TIL{}, apperr.PreconditionFailed("The TIL was changed since you read it", err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return TIL{}, apperr.NotFound("TIL not found", err)
+	case err != nil:
+		return TIL{}, err
+	}
+	return updated, nil
+}
+
+func (u *service) Search(ctx context.Context, title, category string) ([]*TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.Search")
+	defer span.End()
+
+	return u.repo.Search(ctx, title, category)
+}
+
+// ListByUser returns all TILs of a user, oldest first.
+func (u *service) ListByUser(ctx context.Context, userID uint) ([]TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.ListByUser")
+	defer span.End()
+
+	return u.repo.GetAllByUserID(ctx, userID)
+}
+
+// ListByUserSince returns the TILs a user created at or after since, oldest first.
+func (u *service) ListByUserSince(ctx context.Context, userID uint, since time.Time) ([]TIL, error) {
+	ctx, span := tracing.Start(ctx, "til.ListByUserSince")
+	defer span.End()
+
+	return u.repo.GetByUserIDSince(ctx, userID, since)
+}
+
+func (u *service) DeleteByUser(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "til.DeleteByUser")
+	defer span.End()
+
+	return u.repo.DeleteByUserID(ctx, userID)
+}
+
+func (u *service) ReassignUser(ctx context.Context, fromUserID, toUserID uint) error {
+	ctx, span := tracing.Start(ctx, "til.ReassignUser")
+	defer span.End()
+
+	return u.repo.ReassignUserID(ctx, fromUserID, toUserID)
+}
+
+// RenderAll renders the content of every TIL entry to HTML again, or only of
+// those without HTML when onlyMissing is set. It returns how many TILs got
+// new HTML.
+func (u *service) RenderAll(ctx context.Context, onlyMissing bool) (int, error) {
+	ctx, span := tracing.Start(ctx, "til.RenderAll")
+	defer span.End()
+
+	rendered := 0
+	var afterID uint
+	for {
+		tils, err := u.repo.GetAfterID(ctx, afterID, renderBatchSize)
+		if err != nil {
+			return rendered, err
+		}
+		for _, t := range tils {
+			afterID = t.ID
+			if onlyMissing && t.HTML != "" {
+				continue
+			}
+			changed, err := u.render(ctx, t)
+			if err != nil {
+				return rendered, err
+			}
+			if changed {
+				rendered++
+			}
+		}
+		if len(tils) < renderBatchSize {
+			return rendered, nil
+		}
 	}
-	return uc.repo.Create(t)
 }
 
-func (u *service) GetByID(id uint) (TIL, error) {
-	return u.repo.GetByID(id)
+// Render renders the current Markdown of the TIL with the given ID to HTML,
+// unless the TIL was deleted.
+func (u *service) Render(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "til.Render")
+	defer span.End()
+
+	t, err := u.repo.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = u.render(ctx, t)
+	return err
 }
 
-func (uc *service) Update(til TIL) (TIL, error) {
-	return uc.repo.Update(til)
+// render saves the HTML of the content of t, and reports whether it differed
+// from the HTML t had. A TIL whose content was changed or that was deleted
+// since t was read is skipped: every change queues a render of its own.
+func (u *service) render(ctx context.Context, t TIL) (bool, error) {
+	html, err := RenderMarkdown(t.Content)
+	if err != nil {
+		return false, fmt.Errorf("could not render TIL %d: %w", t.ID, err)
+	}
+	if html == t.HTML {
+		return false, nil
+	}
+	return u.repo.UpdateHTML(ctx, t.ID, t.Content, html)
 }
 
-func (u *service) Search(title, category string) ([]*TIL, error) {
-	return u.repo.Search(title, category)
+// PurgeDeleted hard-deletes TILs that were deleted before the given time.
+func (u *service) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "til.PurgeDeleted")
+	defer span.End()
+
+	return u.repo.PurgeDeleted(ctx, before)
 }
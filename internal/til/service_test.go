@@ -1,10 +1,18 @@
 package til_test
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/queue"
 	"github.com/amavis442/til-backend/internal/til"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/tracing/tracingtest"
+	"gorm.io/gorm"
 )
 
 // --- Fake repository for testing ---
@@ -21,38 +29,90 @@ type fakeRepo struct {
 	searchErr error
 	findRet   *til.TIL
 	findErr   error
+	deleteErr error
+	moveErr   error
 }
 
-func (f *fakeRepo) GetAll(limit int, offset int) ([]til.TIL, error) {
+func (f *fakeRepo) GetAll(ctx context.Context, limit int, offset int) ([]til.TIL, error) {
 	if f.tListErr != nil {
 		return nil, f.tListErr
 	}
 	return f.tList, nil
 }
-func (f *fakeRepo) Create(t til.TIL) error {
+func (f *fakeRepo) Create(ctx context.Context, t *til.TIL) error {
+	if f.createErr == nil {
+		t.ID = 1
+	}
 	return f.createErr
 }
-func (f *fakeRepo) Update(t til.TIL) (til.TIL, error) {
+func (f *fakeRepo) Update(ctx context.Context, t til.TIL) (til.TIL, error) {
 	return f.updateRet, f.updateErr
 }
-func (f *fakeRepo) GetByID(id uint) (til.TIL, error) {
+func (f *fakeRepo) GetByID(ctx context.Context, id uint) (til.TIL, error) {
 	return f.getRet, f.getErr
 }
-func (f *fakeRepo) Search(title, category string) ([]*til.TIL, error) {
+func (f *fakeRepo) Search(ctx context.Context, title, category string) ([]*til.TIL, error) {
 	return f.searchRet, f.searchErr
 }
 
-func (f *fakeRepo) FindOne(title, category string) (*til.TIL, error) {
+func (f *fakeRepo) FindOne(ctx context.Context, title, category string) (*til.TIL, error) {
 	return f.findRet, f.findErr
 }
 
-func (f *fakeRepo) Count() (int64, error) {
+func (f *fakeRepo) Count(ctx context.Context) (int64, error) {
 	if f.countErr != nil {
 		return 0, f.countErr
 	}
 	return int64(len(f.tList)), nil
 }
 
+func (f *fakeRepo) GetAllByUserID(ctx context.Context, userID uint) ([]til.TIL, error) {
+	if f.tListErr != nil {
+		return nil, f.tListErr
+	}
+	return f.tList, nil
+}
+
+func (f *fakeRepo) DeleteByUserID(ctx context.Context, userID uint) error {
+	return f.deleteErr
+}
+
+func (f *fakeRepo) ReassignUserID(ctx context.Context, fromUserID, toUserID uint) error {
+	return f.moveErr
+}
+
+func (f *fakeRepo) GetAfterID(ctx context.Context, afterID uint, limit int) ([]til.TIL, error) {
+	var out []til.TIL
+	for _, t := range f.tList {
+		if t.ID > afterID && len(out) < limit {
+			out = append(out, t)
+		}
+	}
+	return out, f.tListErr
+}
+
+func (f *fakeRepo) UpdateHTML(ctx context.Context, id uint, content, html string) (bool, error) {
+	for i := range f.tList {
+		if f.tList[i].ID == id && f.tList[i].Content == content {
+			f.tList[i].HTML = html
+			return true, f.updateErr
+		}
+	}
+	return false, f.updateErr
+}
+
+func (f *fakeRepo) GetByUserIDSince(ctx context.Context, userID uint, since time.Time) ([]til.TIL, error) {
+	return f.tList, f.tListErr
+}
+
+func (f *fakeRepo) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return 0, f.deleteErr
+}
+
+func (f *fakeRepo) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 // --- Additional fakeRepo for spying ---
 type spyRepo struct {
 	fakeRepo
@@ -62,14 +122,14 @@ type spyRepo struct {
 	createCalled    bool
 }
 
-func (s *spyRepo) FindOne(title, category string) (*til.TIL, error) {
+func (s *spyRepo) FindOne(ctx context.Context, title, category string) (*til.TIL, error) {
 	s.findOneCalled = true
 	s.findOneTitle = title
 	s.findOneCategory = category
 	return s.findRet, s.findErr
 }
 
-func (s *spyRepo) Create(t til.TIL) error {
+func (s *spyRepo) Create(ctx context.Context, t *til.TIL) error {
 	s.createCalled = true
 	return s.createErr
 }
@@ -106,8 +166,8 @@ func TestService_List(t *testing.T) {
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			svc := til.NewService(&fakeRepo{tList: tt.repoData})
-			got, err := svc.List(10, 0)
+			svc := til.NewService(&fakeRepo{tList: tt.repoData}, queue.NewMemoryQueue())
+			got, err := svc.List(t.Context(), 10, 0)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -121,8 +181,8 @@ func TestService_List(t *testing.T) {
 	}
 	t.Run("repo error", func(t *testing.T) {
 		repoErr := errors.New("repo failure")
-		svc := til.NewService(&fakeRepo{tListErr: repoErr})
-		_, err := svc.List(10, 0)
+		svc := til.NewService(&fakeRepo{tListErr: repoErr}, queue.NewMemoryQueue())
+		_, err := svc.List(t.Context(), 10, 0)
 		if !errors.Is(err, repoErr) {
 			t.Errorf("expected error %v, got %v", repoErr, err)
 		}
@@ -142,8 +202,8 @@ func TestService_Create(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			svc := til.NewService(&fakeRepo{createErr: tt.createErr})
-			err := svc.Create(tt.input)
+			svc := til.NewService(&fakeRepo{createErr: tt.createErr}, queue.NewMemoryQueue())
+			err := svc.Create(t.Context(), tt.input)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("got error: %v, wantErr: %v", err, tt.wantErr)
 			}
@@ -151,12 +211,31 @@ func TestService_Create(t *testing.T) {
 	}
 }
 
+func TestService_Create_StartsSpan(t *testing.T) {
+	recorder := tracingtest.Record(t)
+	ctx, parent := tracing.Start(t.Context(), "POST /api/tils")
+
+	svc := til.NewService(&fakeRepo{}, queue.NewMemoryQueue())
+	if err := svc.Create(ctx, til.TIL{Title: "Test", Content: "Test Content", UserID: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parent.End()
+
+	span := tracingtest.Find(recorder.Ended(), "til.Create")
+	if span == nil {
+		t.Fatalf("no til.Create span in %v", tracingtest.Names(recorder.Ended()))
+	}
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("til.Create is not a child of the span in the context")
+	}
+}
+
 // Service returns ErrDuplicate when a TIL entry with the same title and category already exists
 func TestService_Create_ReturnsErrDuplicateIfExists(t *testing.T) {
 	duplicate := &til.TIL{ID: 1, Title: "Go", Category: "Programming"}
 	repo := &fakeRepo{findRet: duplicate}
-	svc := til.NewService(repo)
-	err := svc.Create(til.TIL{Title: "Go", Category: "Programming"})
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+	err := svc.Create(t.Context(), til.TIL{Title: "Go", Category: "Programming"})
 	if !errors.Is(err, til.ErrDuplicate) {
 		t.Errorf("expected ErrDuplicate, got %v", err)
 	}
@@ -165,10 +244,10 @@ func TestService_Create_ReturnsErrDuplicateIfExists(t *testing.T) {
 // Service calls repository FindOne with correct title and category parameters
 func TestService_Create_CallsFindOneWithCorrectParams(t *testing.T) {
 	repo := &spyRepo{}
-	svc := til.NewService(repo)
+	svc := til.NewService(repo, queue.NewMemoryQueue())
 	title := "Go"
 	category := "Programming"
-	_ = svc.Create(til.TIL{Title: title, Category: category})
+	_ = svc.Create(t.Context(), til.TIL{Title: title, Category: category})
 	if !repo.findOneCalled {
 		t.Error("expected FindOne to be called")
 	}
@@ -181,8 +260,8 @@ func TestService_Create_CallsFindOneWithCorrectParams(t *testing.T) {
 func TestService_Create_PropagatesFindOneError(t *testing.T) {
 	repoErr := errors.New("find error")
 	repo := &fakeRepo{findErr: repoErr}
-	svc := til.NewService(repo)
-	err := svc.Create(til.TIL{Title: "Go", Category: "Programming"})
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+	err := svc.Create(t.Context(), til.TIL{Title: "Go", Category: "Programming"})
 	if !errors.Is(err, repoErr) {
 		t.Errorf("expected error %v, got %v", repoErr, err)
 	}
@@ -194,8 +273,8 @@ func TestService_Create_DoesNotCallCreateOnDuplicate(t *testing.T) {
 	repo := &spyRepo{fakeRepo: fakeRepo{
 		findRet: duplicate,
 	}}
-	svc := til.NewService(repo)
-	_ = svc.Create(til.TIL{Title: "Go", Category: "Programming"})
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+	_ = svc.Create(t.Context(), til.TIL{Title: "Go", Category: "Programming"})
 	if repo.createCalled {
 		t.Error("expected Create not to be called when duplicate exists")
 	}
@@ -204,21 +283,21 @@ func TestService_Create_DoesNotCallCreateOnDuplicate(t *testing.T) {
 // Service handles nil repository implementation gracefully
 func TestService_Create_NilRepository(t *testing.T) {
 	var nilRepo *fakeRepo = nil
-	svc := til.NewService(nilRepo)
+	svc := til.NewService(nilRepo, queue.NewMemoryQueue())
 	defer func() {
 		if r := recover(); r == nil {
 			t.Error("expected panic or error when repository is nil, but got none")
 		}
 	}()
-	_ = svc.Create(til.TIL{Title: "Go", Category: "Programming"})
+	_ = svc.Create(t.Context(), til.TIL{Title: "Go", Category: "Programming"})
 }
 
 // Service returns error if repository Create returns an unexpected error
 func TestService_Create_HandlesUnexpectedCreateError(t *testing.T) {
 	createErr := errors.New("unexpected create error")
 	repo := &fakeRepo{createErr: createErr}
-	svc := til.NewService(repo)
-	err := svc.Create(til.TIL{Title: "Go", Category: "Programming"})
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+	err := svc.Create(t.Context(), til.TIL{Title: "Go", Category: "Programming"})
 	if !errors.Is(err, createErr) {
 		t.Errorf("expected error %v, got %v", createErr, err)
 	}
@@ -226,10 +305,10 @@ func TestService_Create_HandlesUnexpectedCreateError(t *testing.T) {
 
 func TestService_GetByID(t *testing.T) {
 	expected := til.TIL{ID: 1, Title: "Go"}
-	svc := til.NewService(&fakeRepo{getRet: expected})
+	svc := til.NewService(&fakeRepo{getRet: expected}, queue.NewMemoryQueue())
 
 	t.Run("success", func(t *testing.T) {
-		got, err := svc.GetByID(1)
+		got, err := svc.GetByID(t.Context(), 1)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -239,8 +318,8 @@ func TestService_GetByID(t *testing.T) {
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		svc := til.NewService(&fakeRepo{getErr: errors.New("not found")})
-		_, err := svc.GetByID(999)
+		svc := til.NewService(&fakeRepo{getErr: errors.New("not found")}, queue.NewMemoryQueue())
+		_, err := svc.GetByID(t.Context(), 999)
 		if err == nil {
 			t.Errorf("expected error, got nil")
 		}
@@ -276,8 +355,8 @@ func TestService_Update(t *testing.T) {
 			svc := til.NewService(&fakeRepo{
 				updateRet: tt.updateRet,
 				updateErr: tt.updateErr,
-			})
-			got, err := svc.Update(tt.input)
+			}, queue.NewMemoryQueue())
+			got, err := svc.Update(t.Context(), tt.input)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("expected error=%v, got err=%v", tt.wantErr, err)
 			}
@@ -288,6 +367,40 @@ func TestService_Update(t *testing.T) {
 	}
 }
 
+// Service refuses to update a TIL entry of another user
+func TestService_Update_RejectsOtherOwner(t *testing.T) {
+	svc := til.NewService(&fakeRepo{getRet: til.TIL{ID: 1, UserID: 2}}, queue.NewMemoryQueue())
+	_, err := svc.Update(t.Context(), til.TIL{ID: 1, Title: "Mine now", UserID: 1})
+	if !errors.Is(err, til.ErrNotOwner) || !errors.Is(err, apperr.ErrForbidden) {
+		t.Errorf("expected ErrNotOwner, got %v", err)
+	}
+}
+
+// Service refuses to update a TIL entry that changed since it was read
+func TestService_Update_RejectsStaleVersion(t *testing.T) {
+	svc := til.NewService(&fakeRepo{getRet: til.TIL{ID: 1, UserID: 1, Version: 3}}, queue.NewMemoryQueue())
+	_, err := svc.Update(t.Context(), til.TIL{ID: 1, Title: "Old", UserID: 1, Version: 2})
+	if !errors.Is(err, til.ErrVersionMismatch) || !errors.Is(err, apperr.ErrPreconditionFailed) {
+		t.Errorf("expected ErrVersionMismatch, got %v", err)
+	}
+
+	// The repository catches updates in between the read and the write
+	svc = til.NewService(&fakeRepo{getRet: til.TIL{ID: 1, UserID: 1, Version: 2}, updateErr: til.ErrVersionMismatch}, queue.NewMemoryQueue())
+	_, err = svc.Update(t.Context(), til.TIL{ID: 1, Title: "Old", UserID: 1, Version: 2})
+	if !errors.Is(err, apperr.ErrPreconditionFailed) {
+		t.Errorf("expected precondition failed, got %v", err)
+	}
+}
+
+// Service wraps a missing TIL entry in a not found error
+func TestService_GetByID_NotFound(t *testing.T) {
+	svc := til.NewService(&fakeRepo{getErr: gorm.ErrRecordNotFound}, queue.NewMemoryQueue())
+	_, err := svc.GetByID(t.Context(), 1)
+	if !errors.Is(err, apperr.ErrNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
 func TestService_Search(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -325,8 +438,8 @@ func TestService_Search(t *testing.T) {
 			svc := til.NewService(&fakeRepo{
 				searchRet: tt.searchRet,
 				searchErr: tt.searchErr,
-			})
-			got, err := svc.Search(tt.title, tt.category)
+			}, queue.NewMemoryQueue())
+			got, err := svc.Search(t.Context(), tt.title, tt.category)
 			if (err != nil) != tt.expectError {
 				t.Errorf("expected error=%v, got err=%v", tt.expectError, err)
 			}
@@ -336,3 +449,107 @@ func TestService_Search(t *testing.T) {
 		})
 	}
 }
+
+func TestService_RenderAll(t *testing.T) {
+	repo := &fakeRepo{tList: []til.TIL{
+		{ID: 1, Content: "# Go", HTML: "<h1>Go</h1>\n"},
+		{ID: 2, Content: "**bold**", HTML: "stale"},
+		{ID: 3, Content: "plain"},
+	}}
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+
+	n, err := svc.RenderAll(t.Context(), true)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 TIL without HTML rendered, got %d, %v", n, err)
+	}
+	if repo.tList[1].HTML != "stale" {
+		t.Errorf("TILs with HTML must be kept, got %q", repo.tList[1].HTML)
+	}
+
+	n, err = svc.RenderAll(t.Context(), false)
+	if err != nil || n != 1 {
+		t.Fatalf("expected only the changed TIL rendered, got %d, %v", n, err)
+	}
+	if want := "<p><strong>bold</strong></p>\n"; repo.tList[1].HTML != want {
+		t.Errorf("expected %q, got %q", want, repo.tList[1].HTML)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	html, err := til.RenderMarkdown("| a |\n| - |\n| ~~b~~ |\n\n<script>alert(1)</script>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html, "<table>") || !strings.Contains(html, "<del>b</del>") {
+		t.Errorf("expected a table with strikethrough, got %q", html)
+	}
+	if strings.Contains(html, "<script>") {
+		t.Errorf("raw HTML must be left out, got %q", html)
+	}
+}
+
+func TestService_EnqueuesRender(t *testing.T) {
+	jobs := queue.NewMemoryQueue()
+	svc := til.NewService(&fakeRepo{
+		getRet:    til.TIL{ID: 1, UserID: 1, Version: 1},
+		updateRet: til.TIL{ID: 1, UserID: 1, Version: 2},
+	}, jobs)
+
+	if err := svc.Create(t.Context(), til.TIL{Title: "Go", Content: "# Go", Category: "go"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := svc.Update(t.Context(), til.TIL{ID: 1, UserID: 1, Content: "# Go", HTML: "<h1>Go</h1>"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	got := jobs.Jobs(til.RenderJob)
+	if len(got) != 2 {
+		t.Fatalf("expected a render job for every create and update, got %d", len(got))
+	}
+	if key := *got[1].IdempotencyKey; key != "til.render:1:2" {
+		t.Errorf("expected the job to render version 2, got key %q", key)
+	}
+}
+
+func TestService_Render(t *testing.T) {
+	repo := &fakeRepo{
+		getRet: til.TIL{ID: 3, Content: "plain"},
+		tList:  []til.TIL{{ID: 3, Content: "plain"}},
+	}
+	svc := til.NewService(repo, queue.NewMemoryQueue())
+	job, err := queue.NewJob(til.RenderJob, "", map[string]uint{"id": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := til.RenderHandler(svc)(t.Context(), job); err != nil {
+		t.Fatalf("RenderHandler: %v", err)
+	}
+	if want := "<p>plain</p>\n"; repo.tList[0].HTML != want {
+		t.Errorf("expected %q, got %q", want, repo.tList[0].HTML)
+	}
+
+	// The content changed after it was rendered
+	repo.tList[0].Content = "*changed*"
+	repo.getRet = repo.tList[0]
+	if err := svc.Render(t.Context(), 3); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if want := "<p><em>changed</em></p>\n"; repo.tList[0].HTML != want {
+		t.Errorf("expected the current content rendered %q, got %q", want, repo.tList[0].HTML)
+	}
+
+	// The TIL was changed again while it was rendered; that change queued its own render
+	repo.getRet.Content = "stale"
+	if err := svc.Render(t.Context(), 3); err != nil {
+		t.Errorf("a TIL that changed since needs no HTML of the old content, got %v", err)
+	}
+	if want := "<p><em>changed</em></p>\n"; repo.tList[0].HTML != want {
+		t.Errorf("expected %q kept, got %q", want, repo.tList[0].HTML)
+	}
+
+	deleted := til.NewService(&fakeRepo{getErr: gorm.ErrRecordNotFound}, queue.NewMemoryQueue())
+	if err := deleted.Render(t.Context(), 3); err != nil {
+		t.Errorf("a TIL that was deleted since needs no HTML, got %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+// Package tiltest has the contract that every til.Repository must pass.
+package tiltest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/til"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Setup returns an empty repository, and the ids of two existing users that
+// TILs can belong to.
+type Setup func(t *testing.T) (repo til.Repository, userA, userB uint)
+
+var day0 = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func create(t *testing.T, repo til.Repository, title, category string, userID uint, day int) {
+	t.Helper()
+	require.NoError(t, repo.Create(t.Context(), &til.TIL{
+		Title:     title,
+		Content:   "About " + title,
+		Category:  category,
+		UserID:    userID,
+		CreatedAt: day0.AddDate(0, 0, day),
+	}))
+}
+
+func titles[T til.TIL | *til.TIL](tils []T) []string {
+	out := make([]string, 0, len(tils))
+	for _, t := range tils {
+		switch v := any(t).(type) {
+		case til.TIL:
+			out = append(out, v.Title)
+		case *til.TIL:
+			out = append(out, v.Title)
+		}
+	}
+	return out
+}
+
+// RunRepositoryContract runs the contract against the repositories of setup.
+func RunRepositoryContract(t *testing.T, setup Setup) {
+	t.Run("GetAll pages newest first", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		for i, title := range []string{"one", "two", "three", "four", "five"} {
+			create(t, repo, title, "go", alice, i)
+		}
+
+		page, err := repo.GetAll(t.Context(), 2, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"five", "four"}, titles(page))
+
+		page, err = repo.GetAll(t.Context(), 2, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"three", "two"}, titles(page))
+
+		page, err = repo.GetAll(t.Context(), 2, 4)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one"}, titles(page))
+
+		count, err := repo.Count(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), count)
+	})
+
+	t.Run("GetAllByUserID lists oldest first", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "second", "go", alice, 2)
+		create(t, repo, "first", "go", alice, 1)
+		create(t, repo, "bobs", "go", bob, 0)
+
+		tils, err := repo.GetAllByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"first", "second"}, titles(tils))
+	})
+
+	t.Run("soft-deleted TILs are hidden", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "Alice learned", "go", alice, 0)
+		create(t, repo, "Bob learned", "go", bob, 1)
+
+		all, err := repo.GetAll(t.Context(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		var aliceID uint
+		for _, t := range all {
+			if t.UserID == alice {
+				aliceID = t.ID
+			}
+		}
+
+		require.NoError(t, repo.DeleteByUserID(t.Context(), alice))
+
+		all, err = repo.GetAll(t.Context(), 10, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Bob learned"}, titles(all))
+
+		count, err := repo.Count(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		found, err := repo.Search(t.Context(), "learned", "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Bob learned"}, titles(found))
+
+		one, err := repo.FindOne(t.Context(), "Alice learned", "go")
+		require.NoError(t, err)
+		assert.Nil(t, one)
+
+		_, err = repo.GetByID(t.Context(), aliceID)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+		byUser, err := repo.GetAllByUserID(t.Context(), alice)
+		require.NoError(t, err)
+		assert.Empty(t, byUser)
+	})
+
+	t.Run("Search ignores case", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "Go embeds files", "Go", alice, 0)
+		create(t, repo, "SQL joins", "SQL", alice, 1)
+		create(t, repo, "Goroutines leak", "go", alice, 2)
+
+		found, err := repo.Search(t.Context(), "EMBED", "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Go embeds files"}, titles(found))
+
+		found, err = repo.Search(t.Context(), "", "GO")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Goroutines leak", "Go embeds files"}, titles(found), "newest first")
+
+		found, err = repo.Search(t.Context(), "joins", "sql")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SQL joins"}, titles(found))
+
+		found, err = repo.Search(t.Context(), "nothing like this", "")
+		require.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("FindOne finds duplicates ignoring case", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "Go embeds files", "go", alice, 0)
+
+		dup, err := repo.FindOne(t.Context(), "GO EMBEDS FILES", "Go")
+		require.NoError(t, err)
+		require.NotNil(t, dup)
+		assert.Equal(t, "Go embeds files", dup.Title)
+
+		none, err := repo.FindOne(t.Context(), "Go embeds files", "sql")
+		require.NoError(t, err)
+		assert.Nil(t, none, "same title in another category is not a duplicate")
+
+		_, err = repo.FindOne(t.Context(), "", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("queries stop when the context is cancelled", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "one", "go", alice, 0)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err := repo.GetAll(ctx, 10, 0)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("GetByID returns not found", func(t *testing.T) {
+		repo, _, _ := setup(t)
+
+		_, err := repo.GetByID(t.Context(), 999)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+	})
+
+	t.Run("Update keeps owner, creation time and HTML", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "Draft", "go", alice, 0)
+		all, err := repo.GetAll(t.Context(), 1, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+
+		updated := all[0]
+		updated.Title = "Final"
+		updated.UserID = bob
+		updated.CreatedAt = time.Time{}
+		updated.HTML = "<script>alert(1)</script>"
+		_, err = repo.Update(t.Context(), updated)
+		require.NoError(t, err)
+
+		got, err := repo.GetByID(t.Context(), all[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Final", got.Title)
+		assert.Equal(t, alice, got.UserID)
+		assert.True(t, day0.Equal(got.CreatedAt), "created_at changed to %v", got.CreatedAt)
+		assert.Equal(t, all[0].HTML, got.HTML)
+		assert.False(t, got.UpdatedAt.Before(all[0].UpdatedAt), "updated_at went back to %v", got.UpdatedAt)
+	})
+
+	t.Run("Update returns the stored TIL", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "Draft", "go", alice, 0)
+		all, err := repo.GetAll(t.Context(), 1, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+
+		got, err := repo.Update(t.Context(), til.TIL{ID: all[0].ID, Title: "Final", Content: "Done", Category: "go"})
+		require.NoError(t, err)
+		assert.Equal(t, "Final", got.Title)
+		assert.Equal(t, alice, got.UserID)
+		assert.True(t, day0.Equal(got.CreatedAt), "created_at changed to %v", got.CreatedAt)
+	})
+
+	t.Run("Update of a missing or deleted TIL is not found", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "Draft", "go", alice, 0)
+		all, err := repo.GetAll(t.Context(), 1, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+
+		_, err = repo.Update(t.Context(), til.TIL{ID: 999, Title: "Final", Content: "Done", Category: "go"})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+		require.NoError(t, repo.DeleteByUserID(t.Context(), alice))
+		_, err = repo.Update(t.Context(), til.TIL{ID: all[0].ID, Title: "Final", Content: "Done", Category: "go"})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+	})
+
+	t.Run("Update increments the version and refuses stale versions", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "Draft", "go", alice, 0)
+		all, err := repo.GetAll(t.Context(), 1, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, uint(1), all[0].Version)
+
+		got, err := repo.Update(t.Context(), til.TIL{ID: all[0].ID, Title: "First tab", Content: "Done", Category: "go", Version: 1})
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), got.Version)
+
+		_, err = repo.Update(t.Context(), til.TIL{ID: all[0].ID, Title: "Second tab", Content: "Done", Category: "go", Version: 1})
+		assert.True(t, errors.Is(err, til.ErrVersionMismatch), "got %v", err)
+		_, err = repo.Update(t.Context(), til.TIL{ID: 999, Title: "Missing", Content: "Done", Category: "go", Version: 1})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "got %v", err)
+
+		got, err = repo.GetByID(t.Context(), all[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, "First tab", got.Title)
+		assert.Equal(t, uint(2), got.Version)
+
+		got, err = repo.Update(t.Context(), til.TIL{ID: all[0].ID, Title: "Any version", Content: "Done", Category: "go"})
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), got.Version, "an update without version still increments it")
+	})
+
+	t.Run("ReassignUserID moves every TIL", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "one", "go", alice, 0)
+		create(t, repo, "two", "go", alice, 1)
+
+		require.NoError(t, repo.ReassignUserID(t.Context(), alice, bob))
+
+		tils, err := repo.GetAllByUserID(t.Context(), bob)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one", "two"}, titles(tils))
+
+		assert.Error(t, repo.ReassignUserID(t.Context(), 0, bob))
+		assert.Error(t, repo.DeleteByUserID(t.Context(), 0))
+	})
+
+	t.Run("GetAfterID walks all TILs in batches", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "one", "go", alice, 2)
+		create(t, repo, "two", "go", bob, 1)
+		create(t, repo, "three", "go", alice, 0)
+
+		first, err := repo.GetAfterID(t.Context(), 0, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"one", "two"}, titles(first), "lowest id first, whatever the creation time")
+
+		rest, err := repo.GetAfterID(t.Context(), first[1].ID, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"three"}, titles(rest))
+	})
+
+	t.Run("UpdateHTML keeps the version and the update time, and refuses changed content", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		create(t, repo, "one", "go", alice, 0)
+		tils, err := repo.GetAfterID(t.Context(), 0, 1)
+		require.NoError(t, err)
+		before := tils[0]
+
+		saved, err := repo.UpdateHTML(t.Context(), before.ID, before.Content, "<p>About one</p>\n")
+		require.NoError(t, err)
+		assert.True(t, saved)
+
+		after, err := repo.GetByID(t.Context(), before.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "<p>About one</p>\n", after.HTML)
+		assert.Equal(t, before.Version, after.Version, "the ETag stays valid")
+		assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt), "%v != %v", before.UpdatedAt, after.UpdatedAt)
+
+		saved, err = repo.UpdateHTML(t.Context(), before.ID, "old content", "<p>Old</p>\n")
+		require.NoError(t, err)
+		assert.False(t, saved)
+		stored, err := repo.GetByID(t.Context(), before.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "<p>About one</p>\n", stored.HTML)
+
+		saved, err = repo.UpdateHTML(t.Context(), 999, "", "")
+		require.NoError(t, err)
+		assert.False(t, saved)
+	})
+
+	t.Run("GetByUserIDSince lists the newer TILs of the user", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "one", "go", alice, 0)
+		create(t, repo, "three", "go", alice, 2)
+		create(t, repo, "two", "go", alice, 1)
+		create(t, repo, "bobs", "go", bob, 2)
+
+		tils, err := repo.GetByUserIDSince(t.Context(), alice, day0.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"two", "three"}, titles(tils))
+	})
+
+	t.Run("PurgeDeleted removes TILs deleted before the time", func(t *testing.T) {
+		repo, alice, bob := setup(t)
+		create(t, repo, "one", "go", alice, 0)
+		create(t, repo, "two", "go", bob, 0)
+		require.NoError(t, repo.DeleteByUserID(t.Context(), alice))
+
+		n, err := repo.PurgeDeleted(t.Context(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, n, "deleted after the time")
+
+		n, err = repo.PurgeDeleted(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		n, err = repo.PurgeDeleted(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Zero(t, n, "already purged")
+
+		count, err := repo.Count(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count, "TILs that are not deleted stay")
+	})
+
+	t.Run("Transaction commits or rolls back every change", func(t *testing.T) {
+		repo, alice, _ := setup(t)
+		failed := errors.New("failed")
+
+		err := repo.Transaction(t.Context(), func(ctx context.Context) error {
+			tl := &til.TIL{Title: "one", Content: "About one", Category: "go", UserID: alice}
+			require.NoError(t, repo.Create(ctx, tl))
+			require.NotZero(t, tl.ID, "Create must set the id")
+			return failed
+		})
+		require.ErrorIs(t, err, failed)
+		count, err := repo.Count(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count, "rolled back")
+
+		err = repo.Transaction(t.Context(), func(ctx context.Context) error {
+			return repo.Create(ctx, &til.TIL{Title: "two", Content: "About two", Category: "go", UserID: alice})
+		})
+		require.NoError(t, err)
+		count, err = repo.Count(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count, "committed")
+	})
+}
@@ -0,0 +1,77 @@
+package tracing_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/database"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/tracing/tracingtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
+)
+
+type note struct {
+	ID   uint
+	Text string
+}
+
+func openDB(t *testing.T) *gorm.DB {
+	db, err := database.Open("sqlite://"+filepath.Join(t.TempDir(), "til.db"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&note{}))
+	require.NoError(t, db.Use(tracing.GormPlugin{}))
+	return db
+}
+
+func attr(span interface{ Attributes() []attribute.KeyValue }, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestGormPlugin_QuerySpans(t *testing.T) {
+	db := openDB(t)
+	recorder := tracingtest.Record(t)
+
+	ctx, parent := tracing.Start(context.Background(), "til.Create")
+	require.NoError(t, db.WithContext(ctx).Create(&note{Text: "secret text"}).Error)
+	var n note
+	require.NoError(t, db.WithContext(ctx).First(&n, n.ID+1).Error)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Equal(t, []string{"insert notes", "select notes", "til.Create"}, tracingtest.Names(spans))
+	for _, span := range spans[:2] {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID(), "queries are children of the span in their context")
+		assert.Equal(t, "sqlite", attr(span, "db.system.name").AsString())
+		assert.Equal(t, "notes", attr(span, "db.collection.name").AsString())
+		assert.Equal(t, codes.Unset, span.Status().Code)
+	}
+	insert := attr(spans[0], "db.query.text").AsString()
+	assert.Contains(t, insert, "INSERT INTO `notes`")
+	assert.NotContains(t, insert, "secret text", "the values are left out")
+	assert.Equal(t, int64(1), attr(spans[0], "db.response.affected_rows").AsInt64())
+}
+
+func TestGormPlugin_Errors(t *testing.T) {
+	db := openDB(t)
+	recorder := tracingtest.Record(t)
+
+	var n note
+	assert.ErrorIs(t, db.First(&n, 42).Error, gorm.ErrRecordNotFound)
+	assert.Error(t, db.Table("missing").Create(map[string]any{"text": "x"}).Error)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Unset, spans[0].Status().Code, "not finding a row is no error")
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Len(t, spans[1].Events(), 1, "the error is recorded")
+}
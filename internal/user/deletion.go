@@ -0,0 +1,70 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"gorm.io/gorm"
+)
+
+// deletionCodeTTL is how long a mailed deletion code can be used.
+const deletionCodeTTL = 30 * time.Minute
+
+// RequestDeletionCode mails the user a code that confirms the deletion of the
+// account instead of the password, for users that log in through an identity
+// provider and never chose a password. The code replaces any earlier code and
+// is only mailed to a verified address.
+func (s *service) RequestDeletionCode(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "user.RequestDeletionCode")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperr.NotFound("User not found", err)
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		return apperr.Conflict("Verify your email address before you ask for a deletion code", ErrEmailNotVerified)
+	}
+
+	code := rand.Text()
+	expires := time.Now().Add(deletionCodeTTL)
+	user.DeletionCode = &code
+	user.DeletionCodeExpiresAt = &expires
+	return s.repo.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, &user); err != nil {
+			return err
+		}
+		return s.enqueueDeletionCode(ctx, user.ID)
+	})
+}
+
+// CheckDeletionCode reports whether code is the deletion code of the user and
+// has not expired.
+func (s *service) CheckDeletionCode(ctx context.Context, userID uint, code string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.CheckDeletionCode")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, apperr.NotFound("User not found", err)
+	}
+	if err != nil {
+		return false, err
+	}
+	return validDeletionCode(&user, code, time.Now()), nil
+}
+
+func validDeletionCode(u *User, code string, now time.Time) bool {
+	if code == "" || u.DeletionCode == nil || u.DeletionCodeExpiresAt == nil || now.After(*u.DeletionCodeExpiresAt) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(code), []byte(*u.DeletionCode)) == 1
+}
@@ -0,0 +1,144 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/mail"
+	"github.com/amavis442/til-backend/internal/queue"
+)
+
+// VerifyEmailJob is the kind of the queued jobs that mail the verification
+// token to a user.
+const VerifyEmailJob = "user.verify_email"
+
+// DeletionCodeJob is the kind of the queued jobs that mail the deletion code
+// to a user.
+const DeletionCodeJob = "user.deletion_code"
+
+type verifyEmailPayload struct {
+	UserID uint `json:"user_id"`
+}
+
+// enqueueVerification mails the verification token of the user once the
+// transaction of ctx is committed. The job reads the token when it runs, so
+// the token is not stored in the queue.
+func (s *service) enqueueVerification(ctx context.Context, userID uint) error {
+	job, err := queue.NewJob(VerifyEmailJob, "", verifyEmailPayload{UserID: userID})
+	if err != nil {
+		return err
+	}
+	return s.jobs.Enqueue(ctx, job)
+}
+
+// VerificationMailHandler runs the VerifyEmailJob jobs. The mail links to
+// verifyURL with the token added as ?token=, or holds only the token when
+// verifyURL is empty. Users that were deleted or verified since get no mail.
+func VerificationMailHandler(s Service, mailer mail.Mailer, verifyURL string) queue.Handler {
+	return func(ctx context.Context, job *queue.Job) error {
+		var p verifyEmailPayload
+		if err := job.Decode(&p); err != nil {
+			return err
+		}
+
+		u, err := s.GetByID(ctx, p.UserID)
+		if errors.Is(err, apperr.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if u.EmailVerified || u.EmailVerificationToken == nil {
+			return nil
+		}
+
+		msg, err := verificationMessage(u, verifyURL)
+		if err != nil {
+			return queue.Permanent(err)
+		}
+		return mailer.Send(ctx, msg)
+	}
+}
+
+func verificationMessage(u *User, verifyURL string) (mail.Message, error) {
+	name := u.DisplayName
+	if name == "" {
+		name = u.Username
+	}
+	token := *u.EmailVerificationToken
+
+	action := "verify it with this token:\n\n" + token
+	if verifyURL != "" {
+		link, err := url.Parse(verifyURL)
+		if err != nil {
+			return mail.Message{}, fmt.Errorf("invalid verify URL: %w", err)
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		action = "open this link to verify it:\n\n" + link.String()
+	}
+
+	return mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that %s is your email address; %s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", name, u.Email, action),
+	}, nil
+}
+
+type deletionCodePayload struct {
+	UserID uint `json:"user_id"`
+}
+
+// enqueueDeletionCode mails the deletion code of the user once the transaction
+// of ctx is committed. Like the verification token, the code is read when the
+// job runs.
+func (s *service) enqueueDeletionCode(ctx context.Context, userID uint) error {
+	job, err := queue.NewJob(DeletionCodeJob, "", deletionCodePayload{UserID: userID})
+	if err != nil {
+		return err
+	}
+	return s.jobs.Enqueue(ctx, job)
+}
+
+// DeletionCodeMailHandler runs the DeletionCodeJob jobs. Users that were
+// deleted since, or whose code expired, get no mail.
+func DeletionCodeMailHandler(s Service, mailer mail.Mailer) queue.Handler {
+	return func(ctx context.Context, job *queue.Job) error {
+		var p deletionCodePayload
+		if err := job.Decode(&p); err != nil {
+			return err
+		}
+
+		u, err := s.GetByID(ctx, p.UserID)
+		if errors.Is(err, apperr.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if u.DeletionCode == nil || u.DeletionCodeExpiresAt == nil || time.Now().After(*u.DeletionCodeExpiresAt) {
+			return nil
+		}
+		return mailer.Send(ctx, deletionCodeMessage(u))
+	}
+}
+
+func deletionCodeMessage(u *User) mail.Message {
+	name := u.DisplayName
+	if name == "" {
+		name = u.Username
+	}
+	return mail.Message{
+		To:      u.Email,
+		Subject: "Confirm the deletion of your account",
+		Body: fmt.Sprintf("Hi %s,\n\nEnter this code to confirm that you want to delete your account and all your data:\n\n%s\n\n"+
+			"The code can be used until %s. If you did not ask for this, you can ignore this email; "+
+			"the account is not deleted without the code.\n", name, *u.DeletionCode, u.DeletionCodeExpiresAt.UTC().Format("15:04 MST")),
+	}
+}
@@ -0,0 +1,83 @@
+package user_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/mail"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMailer struct {
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestVerificationMail(t *testing.T) {
+	jobs := queue.NewMemoryQueue()
+	svc := user.NewService(user.NewRepository(storagetest.OpenSQLite(t)), jobs)
+	mailer := &recordingMailer{}
+	send := user.VerificationMailHandler(svc, mailer, "https://til.example.com/verify?lang=en")
+
+	require.NoError(t, svc.Register(t.Context(), "ada", "ada@example.com", "correct horse"))
+	queued := jobs.Jobs(user.VerifyEmailJob)
+	require.Len(t, queued, 1, "Register queues the verification email")
+
+	require.NoError(t, send(t.Context(), queued[0]))
+	require.Len(t, mailer.sent, 1)
+	ada, err := svc.GetByUsername(t.Context(), "ada")
+	require.NoError(t, err)
+	assert.Equal(t, "ada@example.com", mailer.sent[0].To)
+	assert.Contains(t, mailer.sent[0].Body, "https://til.example.com/verify?lang=en&token="+*ada.EmailVerificationToken)
+
+	email := "lovelace@example.com"
+	_, err = svc.UpdateProfile(t.Context(), ada.ID, user.ProfileUpdate{Email: &email})
+	require.NoError(t, err)
+	queued = jobs.Jobs(user.VerifyEmailJob)
+	require.Len(t, queued, 2, "a new email address is verified again")
+
+	ada, err = svc.GetByUsername(t.Context(), "ada")
+	require.NoError(t, err)
+	require.NoError(t, svc.VerifyEmail(t.Context(), *ada.EmailVerificationToken))
+	require.NoError(t, send(t.Context(), queued[1]))
+	assert.Len(t, mailer.sent, 1, "a verified address gets no email")
+}
+
+func TestDeletionCodeMail(t *testing.T) {
+	jobs := queue.NewMemoryQueue()
+	svc := user.NewService(user.NewRepository(storagetest.OpenSQLite(t)), jobs)
+	mailer := &recordingMailer{}
+	send := user.DeletionCodeMailHandler(svc, mailer)
+
+	ada, err := svc.FindOrCreateByEmail(t.Context(), "ada@example.com", "ada")
+	require.NoError(t, err)
+	require.NoError(t, svc.RequestDeletionCode(t.Context(), ada.ID))
+	queued := jobs.Jobs(user.DeletionCodeJob)
+	require.Len(t, queued, 1)
+
+	require.NoError(t, send(t.Context(), queued[0]))
+	require.Len(t, mailer.sent, 1)
+	ada, err = svc.GetByID(t.Context(), ada.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ada@example.com", mailer.sent[0].To)
+	assert.Contains(t, mailer.sent[0].Body, *ada.DeletionCode)
+
+	valid, err := svc.CheckDeletionCode(t.Context(), ada.ID, *ada.DeletionCode)
+	require.NoError(t, err)
+	assert.True(t, valid)
+	valid, err = svc.CheckDeletionCode(t.Context(), ada.ID, "")
+	require.NoError(t, err)
+	assert.False(t, valid)
+
+	require.NoError(t, svc.DeleteAccount(t.Context(), ada.ID))
+	require.NoError(t, send(t.Context(), queued[0]))
+	assert.Len(t, mailer.sent, 1, "a deleted user gets no email")
+}
@@ -1,11 +1,39 @@
 package user
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrValidation               = errors.New("validation error")                 // ErrValidation is returned when profile fields are invalid.
+	ErrUsernameTaken            = errors.New("username already taken")           // ErrUsernameTaken is returned when another user has the username.
+	ErrEmailTaken               = errors.New("email already in use")             // ErrEmailTaken is returned when another user has the email.
+	ErrInvalidVerificationToken = errors.New("invalid email verification token") // ErrInvalidVerificationToken is returned when no user has the verification token.
+	ErrDisabled                 = errors.New("account disabled")                 // ErrDisabled is returned when a disabled user logs in.
+	ErrUnknownRole              = errors.New("unknown role")                     // ErrUnknownRole is returned when a role is neither RoleUser nor RoleAdmin.
+	ErrEmailNotVerified         = errors.New("email not verified")               // ErrEmailNotVerified is returned when an external login matches a user whose email is not verified.
+)
+
+const (
+	RoleUser  = "ROLE_USER"  // Every user has at least this role
+	RoleAdmin = "ROLE_ADMIN" // Administrators
+)
 
 type User struct {
 	gorm.Model
-	Username     string `gorm:"unique;not null"`
-	PasswordHash string `gorm:"not null"`
-	Email        string `gorm:"not null"`
-	Role         string `gorm:"type:varchar(50);not null;default:ROLE_USER"`
+	Username               string     `gorm:"size:255;unique;not null"`
+	PasswordHash           string     `gorm:"size:255;not null"`
+	Email                  string     `gorm:"size:255;not null"`
+	Role                   string     `gorm:"type:varchar(50);not null;default:ROLE_USER"`
+	DisplayName            string     `gorm:"size:100;not null;default:''"`
+	AvatarURL              string     `gorm:"type:text;not null;default:''"`
+	Timezone               string     `gorm:"size:64;not null;default:UTC"`
+	EmailVerified          bool       `gorm:"not null;default:false"`
+	EmailVerificationToken *string    `gorm:"size:64;uniqueIndex"`    // Set while the email address is not verified
+	Disabled               bool       `gorm:"not null;default:false"` // Disabled users cannot log in
+	DeletionCode           *string    `gorm:"size:64"`                // Confirms the deletion of the account instead of the password
+	DeletionCodeExpiresAt  *time.Time // The deletion code is refused after this time
 }
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"github.com/amavis442/til-backend/internal/validate"
+	"gorm.io/gorm"
+)
+
+// ProfileUpdate holds the profile fields to change. Nil fields are left as they are.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Email       *string `json:"email" validate:"omitempty,email,max=255"`
+	Username    *string `json:"username" validate:"omitempty,username"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,optional_http_url"` // Empty removes the avatar
+	Timezone    *string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// UpdateProfile changes the profile of a user. A new email address has to be
+// verified again, so it resets the verification state and mails a new token.
+func (s *service) UpdateProfile(ctx context.Context, userID uint, update ProfileUpdate) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.UpdateProfile")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperr.NotFound("User not found", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Unchanged values are not validated again; users provisioned through an
+	// identity provider can have names that are not valid for registration.
+	if update.Username != nil && *update.Username == user.Username {
+		update.Username = nil
+	}
+	if update.Email != nil && strings.EqualFold(*update.Email, user.Email) {
+		update.Email = nil
+	}
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		update.DisplayName = &name
+	}
+	if fields := validate.Struct(update); len(fields) > 0 {
+		return nil, apperr.Validation(ErrValidation, fields...)
+	}
+
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+
+	if update.Username != nil {
+		existing, err := s.repo.GetByUsername(ctx, *update.Username)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if existing != nil && existing.ID != user.ID {
+			return nil, apperr.Conflict("Username is already taken", ErrUsernameTaken)
+		}
+		user.Username = *update.Username
+	}
+
+	if update.Email != nil {
+		existing, err := s.repo.GetByEmail(ctx, *update.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if existing != nil && existing.ID != user.ID {
+			return nil, apperr.Conflict("Email address is already in use", ErrEmailTaken)
+		}
+
+		token, err := newVerificationToken()
+		if err != nil {
+			return nil, err
+		}
+		user.Email = *update.Email
+		user.EmailVerified = false
+		user.EmailVerificationToken = &token
+	}
+
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+
+	if update.Timezone != nil {
+		user.Timezone = *update.Timezone
+	}
+
+	err = s.repo.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, &user); err != nil {
+			return err
+		}
+		if update.Email == nil {
+			return nil
+		}
+		return s.enqueueVerification(ctx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// VerifyEmail marks the email address of the user with the given token as verified.
+func (s *service) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "user.VerifyEmail")
+	defer span.End()
+
+	if token == "" {
+		return apperr.BadRequest("Invalid verification token", ErrInvalidVerificationToken)
+	}
+
+	user, err := s.repo.GetByEmailVerificationToken(ctx, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperr.BadRequest("Invalid verification token", ErrInvalidVerificationToken)
+	}
+	if err != nil {
+		return err
+	}
+
+	user.EmailVerified = true
+	user.EmailVerificationToken = nil
+	return s.repo.Update(ctx, user)
+}
+
+func newVerificationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
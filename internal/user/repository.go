@@ -1,14 +1,25 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/database"
 	"gorm.io/gorm"
 )
 
 type Repository interface {
-	GetByUsername(username string) (*User, error)
-	Create(user *User) error
-	Update(user *User) error
-	GetByID(id uint) (User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByEmailVerificationToken(ctx context.Context, token string) (*User, error)
+	Create(ctx context.Context, user *User) error
+	Update(ctx context.Context, user *User) error
+	GetByID(ctx context.Context, id uint) (User, error)
+	Delete(ctx context.Context, id uint) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
 	// add more DB methods here as needed
 }
 
@@ -20,25 +31,68 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db: db}
 }
 
-func (r *repository) GetByUsername(username string) (*User, error) {
+func (r *repository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	var user User
+	result := database.Conn(ctx, r.db).Where("username = ?", username).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// GetByEmail looks up a user by email, ignoring case.
+func (r *repository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	var user User
+	result := database.Conn(ctx, r.db).Where("LOWER(email) = ?", strings.ToLower(email)).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+func (r *repository) GetByEmailVerificationToken(ctx context.Context, token string) (*User, error) {
 	var user User
-	result := r.db.Where("username = ?", username).First(&user)
+	result := database.Conn(ctx, r.db).Where("email_verification_token = ?", token).First(&user)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &user, nil
 }
 
-func (r *repository) Create(user *User) error {
-	return r.db.Create(&user).Error
+func (r *repository) Create(ctx context.Context, user *User) error {
+	return database.Conn(ctx, r.db).Create(&user).Error
 }
 
-func (r *repository) GetByID(id uint) (User, error) {
+func (r *repository) GetByID(ctx context.Context, id uint) (User, error) {
 	var user User
-	result := r.db.First(&user, id)
+	result := database.Conn(ctx, r.db).First(&user, id)
 	return user, result.Error
 }
 
-func (r *repository) Update(user *User) error {
-	return r.db.Save(&user).Error
+func (r *repository) Update(ctx context.Context, user *User) error {
+	return database.Conn(ctx, r.db).Save(&user).Error
+}
+
+// Delete soft-deletes the user.
+func (r *repository) Delete(ctx context.Context, id uint) error {
+	if id == 0 {
+		return errors.New("user id must not be empty cannot delete user")
+	}
+	return database.Conn(ctx, r.db).Delete(&User{}, id).Error
+}
+
+// PurgeDeleted permanently removes users that were soft-deleted before the
+// given time, with their refresh tokens and preferences. Users that still own
+// TILs, deleted or not, are kept.
+func (r *repository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	result := database.Conn(ctx, r.db).Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Where("NOT EXISTS (SELECT 1 FROM tils WHERE tils.user_id = users.id)").
+		Delete(&User{})
+	return result.RowsAffected, result.Error
+}
+
+// Transaction runs fn in a transaction that the repository and the queue join.
+func (r *repository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.Transaction(ctx, r.db, fn)
 }
@@ -1,33 +1,58 @@
 package user
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/tracing"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type Service interface {
-	ValidateCredentials(username, password string) (bool, uint, error)
-	GetByUsername(username string) (*User, error)
-	Register(username, email, password string) error
-	UserExists(userID uint) (bool, error)
-	UpdatePassword(userID uint, password string) error
+	ValidateCredentials(ctx context.Context, username, password string) (bool, uint, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	Register(ctx context.Context, username, email, password string) error
+	UserExists(ctx context.Context, userID uint) (bool, error)
+	UpdatePassword(ctx context.Context, userID uint, password string) error
+	FindOrCreateByEmail(ctx context.Context, email, username string) (*User, error)
+	GetByID(ctx context.Context, userID uint) (*User, error)
+	DeleteAccount(ctx context.Context, userID uint) error
+	UpdateProfile(ctx context.Context, userID uint, update ProfileUpdate) (*User, error)
+	VerifyEmail(ctx context.Context, token string) error
+	SetRole(ctx context.Context, userID uint, role string) error
+	SetDisabled(ctx context.Context, userID uint, disabled bool) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	RequestDeletionCode(ctx context.Context, userID uint) error
+	CheckDeletionCode(ctx context.Context, userID uint, code string) (bool, error)
 }
 
 type service struct {
 	repo Repository
+	jobs queue.Queue
 }
 
-func NewService(r Repository) Service {
-	return &service{repo: r}
+// NewService returns the user service. The verification email is sent by a
+// job in jobs.
+func NewService(r Repository, jobs queue.Queue) Service {
+	return &service{repo: r, jobs: jobs}
 }
 
 // ValidateCredentials compares the given password with the stored hash.
-// Returns true and user ID if valid, false otherwise.
-func (s *service) ValidateCredentials(username, password string) (bool, uint, error) {
-	user, err := s.GetByUsername(username)
+// Returns true and user ID if valid, false otherwise. A disabled user with the
+// right password gets ErrDisabled, and an error means the credentials could
+// not be checked.
+func (s *service) ValidateCredentials(ctx context.Context, username, password string) (bool, uint, error) {
+	ctx, span := tracing.Start(ctx, "user.ValidateCredentials")
+	defer span.End()
+
+	user, err := s.GetByUsername(ctx, username)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, 0, nil // user not found
@@ -36,20 +61,32 @@ func (s *service) ValidateCredentials(username, password string) (bool, uint, er
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
-	if err != nil {
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 		return false, 0, nil // invalid password
 	}
+	if err != nil {
+		return false, 0, fmt.Errorf("could not check the password of user %d: %w", user.ID, err)
+	}
+	if user.Disabled {
+		return false, 0, apperr.Forbidden("This account is disabled", ErrDisabled)
+	}
 
 	return true, user.ID, nil
 }
 
-func (s *service) GetByUsername(username string) (*User, error) {
-	return s.repo.GetByUsername(username)
+func (s *service) GetByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.GetByUsername")
+	defer span.End()
+
+	return s.repo.GetByUsername(ctx, username)
 }
 
 // ExistsByID checks if a user with the given userID exists and returns true if found, otherwise false.
-func (s *service) UserExists(userID uint) (bool, error) {
-	_, err := s.repo.GetByID(userID)
+func (s *service) UserExists(ctx context.Context, userID uint) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.UserExists")
+	defer span.End()
+
+	_, err := s.repo.GetByID(ctx, userID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return false, nil
 	}
@@ -59,14 +96,17 @@ func (s *service) UserExists(userID uint) (bool, error) {
 	return true, nil
 }
 
-func (s *service) Register(username, email, password string) error {
+func (s *service) Register(ctx context.Context, username, email, password string) error {
+	ctx, span := tracing.Start(ctx, "user.Register")
+	defer span.End()
+
 	// Check if user already exists
-	existing, err := s.repo.GetByUsername(username)
+	existing, err := s.repo.GetByUsername(ctx, username)
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return err
 	}
 	if existing != nil {
-		return fmt.Errorf("username already taken")
+		return apperr.Conflict("Username is already taken", ErrUsernameTaken)
 	}
 
 	// Hash password
@@ -75,37 +115,201 @@ func (s *service) Register(username, email, password string) error {
 		return err
 	}
 
+	token, err := newVerificationToken()
+	if err != nil {
+		return err
+	}
+
 	// Create user
 	user := &User{
-		Username:     username,
-		PasswordHash: string(hashed),
-		Email:        email,
-		Role:         "ROLE_USER", // Default role
+		Username:               username,
+		PasswordHash:           string(hashed),
+		Email:                  email,
+		Role:                   RoleUser,
+		EmailVerificationToken: &token,
+	}
+
+	return s.repo.Transaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.enqueueVerification(ctx, user.ID)
+	})
+}
+
+func (s *service) UpdatePassword(ctx context.Context, userID uint, password string) error {
+	ctx, span := tracing.Start(ctx, "user.UpdatePassword")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperr.NotFound("User not found", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Hash password
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = string(hashed)
+
+	return s.repo.Update(ctx, &user)
+}
+
+// FindOrCreateByEmail returns the user with the given email, or provisions a new
+// one for logins through an external identity provider. The username is used as
+// a starting point and gets a numeric suffix when it is already taken. The new
+// user gets a random password, so it can only log in through the provider until
+// the password is changed. An existing user whose email is not verified gets
+// ErrEmailNotVerified: anyone can register with someone else's address, so
+// only a verified address proves the login belongs to the same person.
+func (s *service) FindOrCreateByEmail(ctx context.Context, email, username string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.FindOrCreateByEmail")
+	defer span.End()
+
+	existing, err := s.repo.GetByEmail(ctx, email)
+	if err == nil {
+		if !existing.EmailVerified {
+			return nil, apperr.Conflict("An account with this email address exists, log in with its password and verify the address first", ErrEmailNotVerified)
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	username, err = s.availableUsername(ctx, username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Username:      username,
+		PasswordHash:  string(hashed),
+		Email:         email,
+		Role:          RoleUser,
+		EmailVerified: true, // Verified by the identity provider
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// availableUsername returns username, or the local part of email when username
+// is empty, with a numeric suffix added until no user has that name.
+func (s *service) availableUsername(ctx context.Context, username, email string) (string, error) {
+	base := username
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
 	}
 
-	err = s.repo.Create(user)
+	candidate := base
+	for i := 2; i <= 100; i++ {
+		_, err := s.repo.GetByUsername(ctx, candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+	return "", fmt.Errorf("no free username found for %s", base)
+}
+
+func (s *service) GetByID(ctx context.Context, userID uint) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.GetByID")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, apperr.NotFound("User not found", err)
+	}
 	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteAccount anonymizes the personal data of the user and soft-deletes it.
+// The username is freed as well, so it can be registered again.
+func (s *service) DeleteAccount(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "user.DeleteAccount")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	user.Username = fmt.Sprintf("deleted-user-%d", user.ID)
+	user.Email = fmt.Sprintf("deleted-user-%d@invalid", user.ID)
+	user.DisplayName = ""
+	user.AvatarURL = ""
+	user.EmailVerificationToken = nil
+	user.DeletionCode = nil
+	user.DeletionCodeExpiresAt = nil
+	if err := s.repo.Update(ctx, &user); err != nil {
 		return err
 	}
 
-	return nil
+	return s.repo.Delete(ctx, user.ID)
 }
 
-func (s *service) UpdatePassword(userID uint, password string) error {
-	user, err := s.repo.GetByID(userID)
+// SetRole gives the user RoleUser or RoleAdmin.
+func (s *service) SetRole(ctx context.Context, userID uint, role string) error {
+	ctx, span := tracing.Start(ctx, "user.SetRole")
+	defer span.End()
+
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("%w %q", ErrUnknownRole, role)
+	}
+
+	user, err := s.repo.GetByID(ctx, userID)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return errors.New("User not found")
+		return apperr.NotFound("User not found", err)
+	}
+	if err != nil {
+		return err
 	}
 
-	// Hash password
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	user.Role = role
+	return s.repo.Update(ctx, &user)
+}
+
+// SetDisabled disables or enables the user. Disabled users cannot log in; the
+// caller revokes their refresh tokens.
+func (s *service) SetDisabled(ctx context.Context, userID uint, disabled bool) error {
+	ctx, span := tracing.Start(ctx, "user.SetDisabled")
+	defer span.End()
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperr.NotFound("User not found", err)
+	}
 	if err != nil {
 		return err
 	}
 
-	user.PasswordHash = string(hashed)
+	user.Disabled = disabled
+	return s.repo.Update(ctx, &user)
+}
 
-	s.repo.Update(&user)
+// PurgeDeleted hard-deletes the accounts that were deleted before the given
+// time and no longer own any TILs.
+func (s *service) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "user.PurgeDeleted")
+	defer span.End()
 
-	return nil
+	return s.repo.PurgeDeleted(ctx, before)
 }
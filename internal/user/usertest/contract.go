@@ -0,0 +1,170 @@
+// Package usertest has the contract that every user.Repository must pass.
+package usertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Setup returns an empty repository.
+type Setup func(t *testing.T) user.Repository
+
+func create(t *testing.T, repo user.Repository, username, email string) *user.User {
+	t.Helper()
+	u := &user.User{Username: username, Email: email, PasswordHash: "irrelevant"}
+	require.NoError(t, repo.Create(t.Context(), u))
+	require.NotZero(t, u.ID, "Create must set the id")
+	return u
+}
+
+// RunRepositoryContract runs the contract against the repositories of setup.
+func RunRepositoryContract(t *testing.T, setup Setup) {
+	t.Run("Create and look up", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "Alice@Example.com")
+
+		byID, err := repo.GetByID(t.Context(), alice.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", byID.Username)
+		assert.Equal(t, "UTC", byID.Timezone, "defaults are applied")
+
+		byName, err := repo.GetByUsername(t.Context(), "alice")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, byName.ID)
+	})
+
+	t.Run("GetByEmail ignores case", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "Alice@Example.com")
+
+		u, err := repo.GetByEmail(t.Context(), "alice@EXAMPLE.com")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, u.ID)
+	})
+
+	t.Run("duplicate usernames are rejected", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "alice@example.com")
+
+		err := repo.Create(t.Context(), &user.User{Username: "alice", Email: "other@example.com", PasswordHash: "irrelevant"})
+		assert.Error(t, err)
+
+		u, err := repo.GetByUsername(t.Context(), "alice")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, u.ID)
+		assert.Equal(t, "alice@example.com", u.Email)
+	})
+
+	t.Run("missing users are not found", func(t *testing.T) {
+		repo := setup(t)
+
+		_, err := repo.GetByID(t.Context(), 999)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByID: %v", err)
+		_, err = repo.GetByUsername(t.Context(), "nobody")
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByUsername: %v", err)
+		_, err = repo.GetByEmail(t.Context(), "nobody@example.com")
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByEmail: %v", err)
+		_, err = repo.GetByEmailVerificationToken(t.Context(), "unknown")
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByEmailVerificationToken: %v", err)
+	})
+
+	t.Run("queries stop when the context is cancelled", func(t *testing.T) {
+		repo := setup(t)
+		create(t, repo, "alice", "alice@example.com")
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err := repo.GetByUsername(ctx, "alice")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Update persists changes", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "alice@example.com")
+
+		token := "verify-me"
+		alice.DisplayName = "Alice"
+		alice.EmailVerificationToken = &token
+		alice.Role = user.RoleAdmin
+		alice.Disabled = true
+		require.NoError(t, repo.Update(t.Context(), alice))
+
+		u, err := repo.GetByEmailVerificationToken(t.Context(), token)
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, u.ID)
+		assert.Equal(t, "Alice", u.DisplayName)
+		assert.Equal(t, user.RoleAdmin, u.Role)
+		assert.True(t, u.Disabled)
+	})
+
+	t.Run("soft-deleted users are hidden", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "alice@example.com")
+		bob := create(t, repo, "bob", "bob@example.com")
+
+		require.NoError(t, repo.Delete(t.Context(), alice.ID))
+
+		_, err := repo.GetByID(t.Context(), alice.ID)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByID: %v", err)
+		_, err = repo.GetByUsername(t.Context(), "alice")
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByUsername: %v", err)
+		_, err = repo.GetByEmail(t.Context(), "alice@example.com")
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "GetByEmail: %v", err)
+
+		_, err = repo.GetByID(t.Context(), bob.ID)
+		assert.NoError(t, err, "other users are not deleted")
+
+		assert.Error(t, repo.Delete(t.Context(), 0))
+	})
+
+	t.Run("PurgeDeleted removes users deleted before the time", func(t *testing.T) {
+		repo := setup(t)
+		alice := create(t, repo, "alice", "alice@example.com")
+		create(t, repo, "bob", "bob@example.com")
+		require.NoError(t, repo.Delete(t.Context(), alice.ID))
+
+		n, err := repo.PurgeDeleted(t.Context(), time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Zero(t, n, "deleted after the time")
+
+		n, err = repo.PurgeDeleted(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		n, err = repo.PurgeDeleted(t.Context(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.Zero(t, n, "already purged")
+
+		_, err = repo.GetByUsername(t.Context(), "bob")
+		assert.NoError(t, err, "users that are not deleted stay")
+	})
+
+	t.Run("Transaction commits or rolls back every change", func(t *testing.T) {
+		repo := setup(t)
+		failed := errors.New("failed")
+
+		err := repo.Transaction(t.Context(), func(ctx context.Context) error {
+			require.NoError(t, repo.Create(ctx, &user.User{Username: "alice", Email: "alice@example.com", PasswordHash: "irrelevant"}))
+			_, err := repo.GetByUsername(ctx, "alice")
+			require.NoError(t, err, "the transaction sees its own changes")
+			return failed
+		})
+		require.ErrorIs(t, err, failed)
+		_, err = repo.GetByUsername(t.Context(), "alice")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "rolled back")
+
+		err = repo.Transaction(t.Context(), func(ctx context.Context) error {
+			return repo.Create(ctx, &user.User{Username: "bob", Email: "bob@example.com", PasswordHash: "irrelevant"})
+		})
+		require.NoError(t, err)
+		_, err = repo.GetByUsername(t.Context(), "bob")
+		assert.NoError(t, err, "committed")
+	})
+}
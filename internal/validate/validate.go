@@ -0,0 +1,123 @@
+// Package validate checks structs against their `validate` tags, see
+// github.com/go-playground/validator for the built-in rules. Invalid fields are
+// reported by their JSON name, so the frontend can highlight them.
+//
+// Besides the built-in rules there are:
+//
+//	username           3 to 50 letters, digits, '.', '_' or '-'
+//	timezone           an IANA time zone like Europe/Amsterdam
+//	optional_http_url  empty, or an http or https url
+//	max_bytes=n        a string of at most n bytes, where max counts characters
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/go-playground/validator/v10"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,50}$`)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	must(v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	}))
+	must(v.RegisterValidation("timezone", func(fl validator.FieldLevel) bool {
+		name := fl.Field().String()
+		if name == "" || name == "Local" {
+			return false
+		}
+		_, err := time.LoadLocation(name)
+		return err == nil
+	}))
+	must(v.RegisterValidation("max_bytes", func(fl validator.FieldLevel) bool {
+		limit, err := strconv.Atoi(fl.Param())
+		if err != nil {
+			panic(fmt.Sprintf("validate: invalid max_bytes %q", fl.Param()))
+		}
+		return len(fl.Field().String()) <= limit
+	}))
+	v.RegisterAlias("optional_http_url", "eq=|http_url")
+	return v
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Struct checks s against its `validate` tags and returns the invalid fields,
+// or nil when s is valid.
+func Struct(s any) []apperr.FieldError {
+	err := validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		panic(fmt.Sprintf("validate: %T can not be validated: %v", s, err))
+	}
+
+	fields := make([]apperr.FieldError, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, apperr.FieldError{
+			Field:   e.Field(),
+			Code:    e.Tag(),
+			Message: message(e),
+		})
+	}
+	return fields
+}
+
+func message(e validator.FieldError) string {
+	isString := e.Kind() == reflect.String
+	switch e.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters", e.Param())
+		}
+		return fmt.Sprintf("must be at least %s", e.Param())
+	case "max":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters", e.Param())
+		}
+		return fmt.Sprintf("must be at most %s", e.Param())
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(e.Param()), ", ")
+	case "email":
+		return "must be a valid email address"
+	case "http_url", "optional_http_url":
+		return "must be an http or https url"
+	case "username":
+		return "must be 3 to 50 letters, digits, '.', '_' or '-'"
+	case "timezone":
+		return "must be an IANA time zone like Europe/Amsterdam"
+	case "max_bytes":
+		return fmt.Sprintf("must be at most %s bytes", e.Param())
+	}
+	return "is invalid"
+}
@@ -0,0 +1,99 @@
+package validate_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amavis442/til-backend/internal/apperr"
+	"github.com/amavis442/til-backend/internal/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	Name     string  `json:"name" validate:"required,max=5"`
+	Username string  `json:"username" validate:"omitempty,username"`
+	Email    string  `json:"email" validate:"omitempty,email"`
+	Mode     string  `json:"mode" validate:"omitempty,oneof=light dark"`
+	Size     int     `json:"size" validate:"min=1,max=10"`
+	Timezone *string `json:"timezone" validate:"omitempty,timezone"`
+	Avatar   *string `json:"avatar" validate:"omitempty,optional_http_url"`
+	Secret   string  `json:"secret" validate:"max_bytes=4"`
+	Internal string  `json:"-" validate:"max=1"`
+}
+
+func ptr(s string) *string { return &s }
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name string
+		req  request
+		want []apperr.FieldError
+	}{
+		{
+			name: "valid",
+			req:  request{Name: "ok", Size: 1},
+		},
+		{
+			name: "lengths count characters, not bytes",
+			req:  request{Name: strings.Repeat("é", 5), Size: 10},
+		},
+		{
+			name: "missing and too long",
+			req:  request{Name: strings.Repeat("é", 6)},
+			want: []apperr.FieldError{
+				{Field: "name", Code: "max", Message: "must be at most 5 characters"},
+				{Field: "size", Code: "min", Message: "must be at least 1"},
+			},
+		},
+		{
+			name: "required",
+			req:  request{Size: 11},
+			want: []apperr.FieldError{
+				{Field: "name", Code: "required", Message: "is required"},
+				{Field: "size", Code: "max", Message: "must be at most 10"},
+			},
+		},
+		{
+			name: "custom rules",
+			req:  request{Name: "ok", Size: 1, Username: "no spaces", Email: "nope", Mode: "blue", Timezone: ptr("Mars/Olympus_Mons")},
+			want: []apperr.FieldError{
+				{Field: "username", Code: "username", Message: "must be 3 to 50 letters, digits, '.', '_' or '-'"},
+				{Field: "email", Code: "email", Message: "must be a valid email address"},
+				{Field: "mode", Code: "oneof", Message: "must be one of light, dark"},
+				{Field: "timezone", Code: "timezone", Message: "must be an IANA time zone like Europe/Amsterdam"},
+			},
+		},
+		{
+			name: "pointers are only checked when set",
+			req:  request{Name: "ok", Size: 1, Timezone: ptr("Europe/Amsterdam"), Avatar: ptr("")},
+		},
+		{
+			name: "empty time zone and invalid url",
+			req:  request{Name: "ok", Size: 1, Timezone: ptr(""), Avatar: ptr("javascript:alert(1)")},
+			want: []apperr.FieldError{
+				{Field: "timezone", Code: "timezone", Message: "must be an IANA time zone like Europe/Amsterdam"},
+				{Field: "avatar", Code: "optional_http_url", Message: "must be an http or https url"},
+			},
+		},
+		{
+			name: "max_bytes counts bytes",
+			req:  request{Name: "ok", Size: 1, Secret: "ééé"},
+			want: []apperr.FieldError{
+				{Field: "secret", Code: "max_bytes", Message: "must be at most 4 bytes"},
+			},
+		},
+		{
+			name: "fields without a json name keep their Go name",
+			req:  request{Name: "ok", Size: 1, Internal: "too long"},
+			want: []apperr.FieldError{
+				{Field: "Internal", Code: "max", Message: "must be at most 1 characters"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validate.Struct(tt.req))
+		})
+	}
+}
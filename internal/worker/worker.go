@@ -0,0 +1,153 @@
+// Package worker runs the jobs in the queue with the handlers of their kind.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/metrics"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Worker runs the jobs of the queue with the handlers of their kind.
+type Worker struct {
+	queue    *queue.DBQueue
+	workers  int
+	poll     time.Duration
+	lease    time.Duration
+	instance string
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	handlers map[string]queue.Handler
+}
+
+// New returns a worker without handlers. How many jobs run at the same time
+// and how often the queue is polled come from cfg.
+func New(q *queue.DBQueue, cfg config.QueueConfig, logger *slog.Logger) *Worker {
+	instance, _ := os.Hostname()
+	return &Worker{
+		queue:    q,
+		workers:  cfg.Workers,
+		poll:     cfg.PollInterval,
+		lease:    cfg.Lease,
+		instance: instance,
+		logger:   logger,
+		handlers: map[string]queue.Handler{},
+	}
+}
+
+// Handle runs the jobs of the given kind with h.
+func (w *Worker) Handle(kind string, h queue.Handler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers[kind] = h
+}
+
+// Run runs jobs until ctx is done, and waits for the running jobs to stop.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range w.workers {
+		name := fmt.Sprintf("%s/%d", w.instance, i)
+		wg.Go(func() { w.loop(ctx, name) })
+	}
+	wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context, name string) {
+	for {
+		ran, err := w.RunNext(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			w.logger.ErrorContext(ctx, "Could not run the next job", "error", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		timer := time.NewTimer(w.poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RunNext claims the job that is due first as worker name and runs it. It
+// reports whether there was a job.
+func (w *Worker) RunNext(ctx context.Context, name string) (bool, error) {
+	job, err := w.queue.Claim(ctx, name)
+	if err != nil || job == nil {
+		return false, err
+	}
+	logger := w.logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	w.mu.Lock()
+	h, ok := w.handlers[job.Kind]
+	w.mu.Unlock()
+
+	// The attempt must end before another worker may claim the job
+	runCtx, cancel := context.WithTimeout(ctx, w.lease)
+	runCtx, span := tracing.Start(runCtx, "queue.Run")
+	span.SetAttributes(attribute.String("job.kind", job.Kind), attribute.Int("job.attempt", job.Attempts))
+	if ok {
+		err = call(runCtx, h, job)
+	} else {
+		// A newer replica may know the kind, so the job is tried again
+		err = fmt.Errorf("no handler for job kind %s", job.Kind)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	cancel()
+
+	// The outcome is saved even when the server is shutting down
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err == nil {
+		err = w.queue.Complete(saveCtx, job)
+	} else {
+		logger.WarnContext(ctx, "Job failed", "error", err)
+		err = w.queue.Fail(saveCtx, job, err)
+	}
+	if errors.Is(err, queue.ErrLost) {
+		logger.WarnContext(ctx, "Job took longer than its lease", "lease", w.lease)
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("could not save the outcome of job %d: %w", job.ID, err)
+	}
+
+	result := job.Status
+	if result == queue.StatusPending {
+		result = "retry"
+	}
+	metrics.QueuedJobs.WithLabelValues(job.Kind, result).Inc()
+	if job.Status == queue.StatusDead {
+		logger.ErrorContext(ctx, "Job is dead", "error", job.LastError)
+	}
+	return true, nil
+}
+
+// call runs h and turns a panic into an error, so one job cannot stop the
+// server.
+func call(ctx context.Context, h queue.Handler, job *queue.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
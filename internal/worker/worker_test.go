@@ -0,0 +1,100 @@
+package worker_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/amavis442/til-backend/internal/config"
+	"github.com/amavis442/til-backend/internal/queue"
+	"github.com/amavis442/til-backend/internal/storagetest"
+	"github.com/amavis442/til-backend/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker_RunNext(t *testing.T) {
+	db := storagetest.OpenSQLite(t)
+	cfg := config.QueueConfig{Workers: 1, PollInterval: time.Millisecond, MaxAttempts: 3, Lease: time.Minute}
+	q := queue.NewDBQueue(db, cfg)
+	w := worker.New(q, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var sent []string
+	w.Handle("mail.send", func(ctx context.Context, job *queue.Job) error {
+		var payload struct{ To string }
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		sent = append(sent, payload.To)
+		return nil
+	})
+	w.Handle("panics", func(ctx context.Context, job *queue.Job) error {
+		panic("nil map")
+	})
+
+	for _, kind := range []string{"mail.send", "panics", "unknown"} {
+		job, err := queue.NewJob(kind, "", map[string]string{"to": "ada@example.com"})
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(t.Context(), job))
+	}
+	require.NoError(t, q.Enqueue(t.Context(), &queue.Job{Kind: "mail.send", Payload: "not json"}))
+
+	for range 4 {
+		ran, err := w.RunNext(t.Context(), "worker")
+		require.NoError(t, err)
+		assert.True(t, ran)
+	}
+	ran, err := w.RunNext(t.Context(), "worker")
+	require.NoError(t, err)
+	assert.False(t, ran, "the failed jobs wait for their backoff")
+
+	assert.Equal(t, []string{"ada@example.com"}, sent)
+	done, err := q.List(t.Context(), queue.StatusDone, 10)
+	require.NoError(t, err)
+	assert.Len(t, done, 1)
+	pending, err := q.List(t.Context(), queue.StatusPending, 10)
+	require.NoError(t, err)
+	assert.Len(t, pending, 2, "the panic and the unknown kind are tried again")
+	dead, err := q.List(t.Context(), queue.StatusDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1, "a payload that does not decode never will")
+	assert.Contains(t, dead[0].LastError, "could not decode the payload of job mail.send")
+}
+
+func TestWorker_Run(t *testing.T) {
+	db := storagetest.OpenSQLite(t)
+	cfg := config.QueueConfig{Workers: 2, PollInterval: time.Millisecond, MaxAttempts: 3, Lease: time.Minute}
+	q := queue.NewDBQueue(db, cfg)
+	w := worker.New(q, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{}, 3)
+	w.Handle("count", func(ctx context.Context, job *queue.Job) error {
+		done <- struct{}{}
+		return nil
+	})
+	for range 3 {
+		job, err := queue.NewJob("count", "", nil)
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(t.Context(), job))
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+	for range 3 {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the jobs did not run")
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+}
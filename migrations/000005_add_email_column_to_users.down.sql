@@ -1,2 +0,0 @@
-ALTER TABLE users
-DROP COLUMN role;
\ No newline at end of file
@@ -1,4 +0,0 @@
-ALTER TABLE til DROP COLUMN user_id;
-ALTER TABLE til DROP CONSTRAINT IF EXISTS fk_user;
-ALTER TABLE "tils" DROP CONSTRAINT  "fk_user";
-ALTER TABLE "tils" DROP COLUMN "user_id";
\ No newline at end of file
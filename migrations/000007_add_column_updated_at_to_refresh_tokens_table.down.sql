@@ -1,2 +0,0 @@
-ALTER TABLE users
-DROP COLUMN updated_at;
\ No newline at end of file
@@ -1,2 +0,0 @@
-ALTER TABLE users
-DROP COLUMN deleted_at;
\ No newline at end of file
@@ -0,0 +1,24 @@
+// Package migrations embeds the SQL migration files, so the server binary can
+// migrate the database without the migrations directory or the migrate CLI.
+//
+// Every dialect has its own directory. The SQLite migrations start at version
+// 12 with the full schema, because SQLite support was added at that version.
+// From there on both directories get a migration with the same version.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
+
+// For returns the migrations of the dialect, "postgres" or "sqlite".
+func For(dialect string) (fs.FS, error) {
+	if _, err := fs.Stat(FS, dialect); err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+	return fs.Sub(FS, dialect)
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "idx_user_id";
+ALTER TABLE "tils" DROP CONSTRAINT IF EXISTS "fk_user";
+ALTER TABLE "tils" DROP COLUMN IF EXISTS "user_id";
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens
+DROP COLUMN updated_at;
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens
+ALTER COLUMN token TYPE VARCHAR(512);
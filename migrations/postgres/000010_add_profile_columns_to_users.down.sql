@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS "idx_users_email_verification_token";
+
+ALTER TABLE users
+DROP COLUMN email_verification_token,
+DROP COLUMN email_verified,
+DROP COLUMN timezone,
+DROP COLUMN avatar_url,
+DROP COLUMN display_name;
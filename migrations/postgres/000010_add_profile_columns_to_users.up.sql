@@ -0,0 +1,8 @@
+ALTER TABLE users
+ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '',
+ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '',
+ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN email_verification_token VARCHAR(64);
+
+CREATE UNIQUE INDEX "idx_users_email_verification_token" ON "users" ("email_verification_token");
@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS "idx_refresh_tokens_deleted_at";
+DROP INDEX IF EXISTS "idx_users_deleted_at";
+
+-- Fails when an email address is longer than 50 characters.
+ALTER TABLE users
+    ALTER COLUMN email TYPE VARCHAR(50);
+
+DROP INDEX IF EXISTS "idx_tils_category";
+DROP INDEX IF EXISTS "idx_tils_deleted_at";
+DROP INDEX IF EXISTS "idx_tils_created_at";
+ALTER INDEX "idx_tils_user_id" RENAME TO "idx_user_id";
+
+ALTER TABLE tils
+    ALTER COLUMN user_id DROP NOT NULL,
+    ALTER COLUMN html DROP NOT NULL,
+    ALTER COLUMN html DROP DEFAULT,
+    ALTER COLUMN category DROP NOT NULL;
@@ -0,0 +1,28 @@
+-- Brings the schema in line with the GORM models. Check the result with
+-- "server migrate drift".
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM tils WHERE user_id IS NULL) THEN
+        RAISE EXCEPTION 'tils without a user_id exist, assign them to a user before running this migration';
+    END IF;
+END $$;
+
+UPDATE tils SET category = 'uncategorized' WHERE category IS NULL;
+UPDATE tils SET html = '' WHERE html IS NULL;
+
+ALTER TABLE tils
+    ALTER COLUMN category SET NOT NULL,
+    ALTER COLUMN html SET DEFAULT '',
+    ALTER COLUMN html SET NOT NULL,
+    ALTER COLUMN user_id SET NOT NULL;
+
+ALTER INDEX "idx_user_id" RENAME TO "idx_tils_user_id";
+CREATE INDEX "idx_tils_created_at" ON "tils" ("created_at");
+CREATE INDEX "idx_tils_deleted_at" ON "tils" ("deleted_at");
+CREATE INDEX "idx_tils_category" ON "tils" ("category");
+
+ALTER TABLE users
+    ALTER COLUMN email TYPE VARCHAR(255);
+
+CREATE INDEX "idx_users_deleted_at" ON "users" ("deleted_at");
+CREATE INDEX "idx_refresh_tokens_deleted_at" ON "refresh_tokens" ("deleted_at");
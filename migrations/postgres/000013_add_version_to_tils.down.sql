@@ -0,0 +1,2 @@
+ALTER TABLE tils
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+-- The version is the ETag of a TIL; every update increments it.
+ALTER TABLE tils
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,9 @@
+-- One row per rate limited key, with the hits of its current window. The
+-- replicas of the server count in this table so the limits hold for all of them.
+CREATE TABLE rate_limits (
+    key VARCHAR(255) PRIMARY KEY,
+    hits BIGINT NOT NULL,
+    reset_at BIGINT NOT NULL
+);
+
+CREATE INDEX idx_rate_limits_reset_at ON rate_limits (reset_at);
@@ -0,0 +1,3 @@
+-- Disabled users cannot log in; tilctl user disable sets it.
+ALTER TABLE users
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
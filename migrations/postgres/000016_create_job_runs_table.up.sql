@@ -0,0 +1,14 @@
+-- One row per run of a background job. The unique index lets only one replica
+-- record, and so run, each scheduled time of a job.
+CREATE TABLE job_runs (
+    id BIGSERIAL PRIMARY KEY,
+    job VARCHAR(100) NOT NULL,
+    scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    instance VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_job_runs_job_scheduled_at ON job_runs (job, scheduled_at);
@@ -0,0 +1,21 @@
+-- The durable job queue. Workers claim due jobs with FOR UPDATE SKIP LOCKED;
+-- the idempotency key keeps a job from being enqueued twice.
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    idempotency_key VARCHAR(255),
+    status VARCHAR(20) NOT NULL,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts BIGINT NOT NULL DEFAULT 0,
+    max_attempts BIGINT NOT NULL,
+    locked_by VARCHAR(255) NOT NULL DEFAULT '',
+    locked_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (idempotency_key);
+CREATE INDEX idx_jobs_status_run_at ON jobs (status, run_at);
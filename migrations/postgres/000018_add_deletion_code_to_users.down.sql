@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deletion_code,
+    DROP COLUMN IF EXISTS deletion_code_expires_at;
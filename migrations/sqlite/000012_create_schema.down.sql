@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_preferences;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS tils;
+DROP TABLE IF EXISTS users;
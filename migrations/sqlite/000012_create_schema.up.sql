@@ -0,0 +1,56 @@
+-- The schema of the Postgres migrations up to this version. The column types
+-- are the ones GORM uses for SQLite, so "server migrate drift" stays clean.
+CREATE TABLE users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at datetime,
+    username text NOT NULL UNIQUE,
+    password_hash text NOT NULL,
+    email text NOT NULL,
+    role varchar(50) NOT NULL DEFAULT 'ROLE_USER',
+    display_name text NOT NULL DEFAULT '',
+    avatar_url text NOT NULL DEFAULT '',
+    timezone text NOT NULL DEFAULT 'UTC',
+    email_verified numeric NOT NULL DEFAULT false,
+    email_verification_token text
+);
+
+CREATE UNIQUE INDEX idx_users_email_verification_token ON users (email_verification_token);
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE tils (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at datetime,
+    title text NOT NULL,
+    content text NOT NULL,
+    html text NOT NULL DEFAULT '',
+    category text NOT NULL,
+    user_id integer NOT NULL REFERENCES users (id)
+);
+
+CREATE INDEX idx_tils_created_at ON tils (created_at);
+CREATE INDEX idx_tils_deleted_at ON tils (deleted_at);
+CREATE INDEX idx_tils_category ON tils (category);
+CREATE INDEX idx_tils_user_id ON tils (user_id);
+
+CREATE TABLE refresh_tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at datetime,
+    token text NOT NULL UNIQUE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at datetime NOT NULL
+);
+
+CREATE INDEX idx_refresh_tokens_deleted_at ON refresh_tokens (deleted_at);
+
+CREATE TABLE user_preferences (
+    user_id integer PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    data jsonb NOT NULL DEFAULT '{}',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
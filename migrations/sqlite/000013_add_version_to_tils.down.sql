@@ -0,0 +1 @@
+ALTER TABLE tils DROP COLUMN version;
@@ -0,0 +1,2 @@
+-- The version is the ETag of a TIL; every update increments it.
+ALTER TABLE tils ADD COLUMN version integer NOT NULL DEFAULT 1;
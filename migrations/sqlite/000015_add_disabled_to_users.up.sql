@@ -0,0 +1,2 @@
+-- Disabled users cannot log in; tilctl user disable sets it.
+ALTER TABLE users ADD COLUMN disabled numeric NOT NULL DEFAULT false;
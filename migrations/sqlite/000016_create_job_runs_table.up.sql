@@ -0,0 +1,14 @@
+-- One row per run of a background job. The unique index lets only one replica
+-- record, and so run, each scheduled time of a job.
+CREATE TABLE job_runs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    job text NOT NULL,
+    scheduled_at datetime NOT NULL,
+    started_at datetime NOT NULL,
+    finished_at datetime,
+    status text NOT NULL,
+    error text NOT NULL DEFAULT '',
+    instance text NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_job_runs_job_scheduled_at ON job_runs (job, scheduled_at);
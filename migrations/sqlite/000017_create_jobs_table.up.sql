@@ -0,0 +1,21 @@
+-- The durable job queue. Workers claim due jobs with FOR UPDATE SKIP LOCKED;
+-- the idempotency key keeps a job from being enqueued twice.
+CREATE TABLE jobs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    kind text NOT NULL,
+    payload text NOT NULL,
+    idempotency_key text,
+    status text NOT NULL,
+    run_at datetime NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    locked_by text NOT NULL DEFAULT '',
+    locked_at datetime,
+    last_error text NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at datetime
+);
+
+CREATE UNIQUE INDEX idx_jobs_idempotency_key ON jobs (idempotency_key);
+CREATE INDEX idx_jobs_status_run_at ON jobs (status, run_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN deletion_code_expires_at;
+ALTER TABLE users DROP COLUMN deletion_code;
@@ -0,0 +1,4 @@
+-- Mailed code that confirms the deletion of an account instead of the
+-- password, for users that log in through an identity provider.
+ALTER TABLE users ADD COLUMN deletion_code text;
+ALTER TABLE users ADD COLUMN deletion_code_expires_at datetime;